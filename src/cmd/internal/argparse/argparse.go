@@ -8,7 +8,9 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // parseClientIps parses the X-Forwarded-For header value into a slice of valid net.IP addresses
@@ -42,32 +44,164 @@ func parseClientIps(value string) []net.IP {
 	return clientIps
 }
 
+// parseWindowSize parses a terminal window size given as COLUMNSxROWS, such as 80x24
+func parseWindowSize(value string) (int, int, error) {
+
+	columns, rows, ok := strings.Cut(strings.ToLower(value), "x")
+	if !ok {
+		return 0, 0, fmt.Errorf("must be COLUMNSxROWS: %s", value)
+	}
+
+	width, err := strconv.Atoi(columns)
+	if err != nil || width <= 0 {
+		return 0, 0, fmt.Errorf("must be COLUMNSxROWS: %s", value)
+	}
+
+	height, err := strconv.Atoi(rows)
+	if err != nil || height <= 0 {
+		return 0, 0, fmt.Errorf("must be COLUMNSxROWS: %s", value)
+	}
+
+	return width, height, nil
+}
+
+// destinationList collects --destination values. The flag may be repeated and each value may hold several
+// whitespace-separated destinations.
+type destinationList []string
+
+func (l *destinationList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *destinationList) Set(value string) error {
+	*l = append(*l, strings.Fields(value)...)
+	return nil
+}
+
+// parseParams parses --param values given as NAME=VALUE into a map. A name may be given only once.
+func parseParams(values []string) (map[string]string, error) {
+
+	params := make(map[string]string, len(values))
+
+	for _, value := range values {
+		name, v, ok := strings.Cut(value, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("must be NAME=VALUE: %s", value)
+		}
+		if _, ok := params[name]; ok {
+			return nil, fmt.Errorf("given more than once: %s", name)
+		}
+		params[name] = v
+	}
+
+	return params, nil
+}
+
+// stringList collects the values of a repeatable flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 // ParsedArgs holds the parsed command line arguments
 type ParsedArgs struct {
-	Destination   string
+	Destinations  []string
 	Command       string
+	Action        string
+	Params        map[string]string
+	Docker        string
+	DockerTarget  string
 	AuthHeader    string
 	ClientIps     []net.IP
 	CorrelationId string
+	Timeout       time.Duration
+	Output        string
+	Parallelism   int
+	OnError       string
+	Stdin         string
+	Pty           bool
+	PtyTerm       string
+	PtyWidth      int
+	PtyHeight     int
+	PtyModes      string
+	Uploads       []string
+	Downloads     []string
+	DryRun        bool
+	Handshake     bool
 }
 
+// Output formats accepted by --output
+const (
+	OutputJson   = "json"
+	OutputNdjson = "ndjson"
+)
+
+// Failure policies accepted by --on-error
+const (
+	OnErrorContinue = "continue"
+	OnErrorFailFast = "fail-fast"
+)
+
 // ParseArguments parses command line flags and returns the values.
 // Returns: ParsedArgs, error
 func ParseArguments(args []string) (ParsedArgs, error) {
 
-	var destination = flag.String("destination", "", "SSH destination (e.g., user@host or host)")
+	var destinations destinationList
+	flag.Var(&destinations, "destination", "SSH destination (e.g., user@host or host); repeat or separate with spaces to run on several hosts")
 	var command = flag.String("command", "", "Command to execute on the remote host")
+	var action = flag.String("action", "", "Name of a catalog action to run instead of --command")
+	var params stringList
+	flag.Var(&params, "param", "Parameter of the catalog action as NAME=VALUE (repeatable)")
+	var docker = flag.String("docker", "", "Docker operation to perform on the destination's daemon instead of --command: pull, recreate, inspect or list")
+	var dockerTarget = flag.String("docker-target", "", "Image to pull, container to recreate or inspect, or name to filter the list by")
 	var authorization = flag.String("authorization", "", "JWT token from Authorization Bearer header")
 	var correlationId = flag.String("correlation-id", "", "Correlation ID for traceability (auto-generated if not provided)")
 	var xForwardedFor = flag.String("X-Forwarded-For", "", "Client IP chain from X-Forwarded-For header (optional)")
+	var timeout = flag.String("timeout", "", "Command timeout shorter than WEBHOOK_COMMAND_TIMEOUT (e.g., 30s, 10m)")
+	var output = flag.String("output", OutputJson, "Output format: json (single response) or ndjson (streamed events)")
+	var parallelism = flag.Int("parallelism", 0, "Maximum number of destinations to run on at once (default WEBHOOK_FANOUT_PARALLELISM)")
+	var onError = flag.String("on-error", OnErrorContinue, "Multi-destination failure policy: continue or fail-fast")
+	var stdin = flag.String("stdin", "", "Source of the remote command's standard input: a file, - for this process' stdin, or base64:<data>")
+	var pty = flag.Bool("pty", false, "Run the command on a pseudo-terminal; stderr is merged into stdout")
+	var ptyTerm = flag.String("pty-term", "xterm", "Terminal type of the pseudo-terminal")
+	var ptySize = flag.String("pty-size", "80x24", "Window size of the pseudo-terminal as COLUMNSxROWS")
+	var ptyModes = flag.String("pty-modes", "", "Terminal modes of the pseudo-terminal as NAME=value,... (e.g., ECHO=0,ICRNL=1)")
+	var uploads, downloads stringList
+	flag.Var(&uploads, "upload", "File to upload over SFTP before the command runs, as LOCAL:REMOTE[:MODE[:SHA256]] (repeatable)")
+	flag.Var(&downloads, "download", "File to download over SFTP after the command runs, as REMOTE:LOCAL[:MODE[:SHA256]] (repeatable)")
+	var dryRun = flag.Bool("dry-run", false, "Report what would run on each destination, and the policy rule that allows it, without running it")
+	var handshake = flag.Bool("handshake", false, "With --dry-run, also connect to each destination, verifying its host key and authenticating")
 	var help = flag.Bool("help", false, "Show help message")
 
 	flagSet := flag.NewFlagSet("webhook-executor", flag.ContinueOnError)
-	flagSet.StringVar(destination, "destination", "", "SSH destination (e.g., user@host or host)")
+	flagSet.Var(&destinations, "destination", "SSH destination (e.g., user@host or host); repeat or separate with spaces to run on several hosts")
 	flagSet.StringVar(command, "command", "", "Command to execute on the remote host")
+	flagSet.StringVar(action, "action", "", "Name of a catalog action to run instead of --command")
+	flagSet.Var(&params, "param", "Parameter of the catalog action as NAME=VALUE (repeatable)")
+	flagSet.StringVar(docker, "docker", "", "Docker operation to perform on the destination's daemon instead of --command: pull, recreate, inspect or list")
+	flagSet.StringVar(dockerTarget, "docker-target", "", "Image to pull, container to recreate or inspect, or name to filter the list by")
 	flagSet.StringVar(authorization, "authorization", "", "JWT token from Authorization Bearer header")
 	flagSet.StringVar(correlationId, "correlation-id", "", "Correlation ID for traceability (auto-generated if not provided)")
 	flagSet.StringVar(xForwardedFor, "X-Forwarded-For", "", "Client IP chain from X-Forwarded-For header")
+	flagSet.StringVar(timeout, "timeout", "", "Command timeout shorter than WEBHOOK_COMMAND_TIMEOUT (e.g., 30s, 10m)")
+	flagSet.StringVar(output, "output", OutputJson, "Output format: json (single response) or ndjson (streamed events)")
+	flagSet.IntVar(parallelism, "parallelism", 0, "Maximum number of destinations to run on at once (default WEBHOOK_FANOUT_PARALLELISM)")
+	flagSet.StringVar(onError, "on-error", OnErrorContinue, "Multi-destination failure policy: continue or fail-fast")
+	flagSet.StringVar(stdin, "stdin", "", "Source of the remote command's standard input: a file, - for this process' stdin, or base64:<data>")
+	flagSet.BoolVar(pty, "pty", false, "Run the command on a pseudo-terminal; stderr is merged into stdout")
+	flagSet.StringVar(ptyTerm, "pty-term", "xterm", "Terminal type of the pseudo-terminal")
+	flagSet.StringVar(ptySize, "pty-size", "80x24", "Window size of the pseudo-terminal as COLUMNSxROWS")
+	flagSet.StringVar(ptyModes, "pty-modes", "", "Terminal modes of the pseudo-terminal as NAME=value,... (e.g., ECHO=0,ICRNL=1)")
+	flagSet.Var(&uploads, "upload", "File to upload over SFTP before the command runs, as LOCAL:REMOTE[:MODE[:SHA256]] (repeatable)")
+	flagSet.Var(&downloads, "download", "File to download over SFTP after the command runs, as REMOTE:LOCAL[:MODE[:SHA256]] (repeatable)")
+	flagSet.BoolVar(dryRun, "dry-run", false, "Report what would run on each destination, and the policy rule that allows it, without running it")
+	flagSet.BoolVar(handshake, "handshake", false, "With --dry-run, also connect to each destination, verifying its host key and authenticating")
 	flagSet.BoolVar(help, "help", false, "Show help message")
 
 	err := flagSet.Parse(args)
@@ -82,8 +216,8 @@ func ParseArguments(args []string) (ParsedArgs, error) {
 
 	// After parsing flags, treat remaining args as positional values when a corresponding named flag was not supplied.
 	// Positional order:
-	//   1) destination (required)
-	//   2) command (required)
+	//   1) destination (required unless --action is given; may list several whitespace-separated destinations)
+	//   2) command (required unless --action or --docker is given, in which case it is not taken)
 	//   3) auth-token (required)
 	//   4) correlation-id (optional)
 	//   5) X-Forwarded-For (optional)
@@ -98,31 +232,102 @@ func ParseArguments(args []string) (ParsedArgs, error) {
 		}
 	}
 
-	takePos(destination)
-	takePos(command)
+	if len(destinations) == 0 && *action == "" && index < len(pos) {
+		destinations.Set(pos[index])
+		index++
+	}
+	if *action == "" && *docker == "" {
+		takePos(command)
+	}
 	takePos(authorization)
 	takePos(correlationId)
 	takePos(xForwardedFor)
 
 	// Now validation for required params
 
-	if *destination == "" {
+	if *action != "" && *command != "" {
+		return ParsedArgs{}, fmt.Errorf("--action and --command cannot be used together")
+	}
+	if *action == "" && len(params) > 0 {
+		return ParsedArgs{}, fmt.Errorf("--param requires --action")
+	}
+	if *action == "" && len(destinations) == 0 {
 		return ParsedArgs{}, fmt.Errorf("--destination is required (or provide as 1st positional)")
 	}
-	if *command == "" {
+	if *docker != "" && (*command != "" || *action != "") {
+		return ParsedArgs{}, fmt.Errorf("--docker cannot be used with --command or --action")
+	}
+	if *docker == "" && *dockerTarget != "" {
+		return ParsedArgs{}, fmt.Errorf("--docker-target requires --docker")
+	}
+	if *docker != "" && (*stdin != "" || *pty || len(uploads) > 0 || len(downloads) > 0) {
+		return ParsedArgs{}, fmt.Errorf("--docker cannot be used with --stdin, --pty, --upload or --download")
+	}
+	if *handshake && !*dryRun {
+		return ParsedArgs{}, fmt.Errorf("--handshake requires --dry-run")
+	}
+	if *action == "" && *docker == "" && *command == "" {
 		return ParsedArgs{}, fmt.Errorf("--command is required (or provide as 2nd positional)")
 	}
 	if *authorization == "" {
 		return ParsedArgs{}, fmt.Errorf("--authorization is required (or provide as 3rd positional)")
 	}
 
+	var commandTimeout time.Duration
+	if *timeout != "" {
+		commandTimeout, err = time.ParseDuration(*timeout)
+		if err != nil || commandTimeout <= 0 {
+			return ParsedArgs{}, fmt.Errorf("--timeout must be a positive duration: %s", *timeout)
+		}
+	}
+
+	if *output != OutputJson && *output != OutputNdjson {
+		return ParsedArgs{}, fmt.Errorf("--output must be %s or %s: %s", OutputJson, OutputNdjson, *output)
+	}
+
+	if *parallelism < 0 {
+		return ParsedArgs{}, fmt.Errorf("--parallelism must not be negative: %d", *parallelism)
+	}
+
+	if *onError != OnErrorContinue && *onError != OnErrorFailFast {
+		return ParsedArgs{}, fmt.Errorf("--on-error must be %s or %s: %s", OnErrorContinue, OnErrorFailFast, *onError)
+	}
+
+	actionParams, err := parseParams(params)
+	if err != nil {
+		return ParsedArgs{}, fmt.Errorf("--param %v", err)
+	}
+
+	ptyWidth, ptyHeight, err := parseWindowSize(*ptySize)
+	if err != nil {
+		return ParsedArgs{}, fmt.Errorf("--pty-size %v", err)
+	}
+
 	clientIps := *xForwardedFor
 
 	return ParsedArgs{
-		Destination:   *destination,
+		Destinations:  destinations,
 		Command:       *command,
+		Action:        *action,
+		Params:        actionParams,
+		Docker:        *docker,
+		DockerTarget:  *dockerTarget,
 		AuthHeader:    *authorization,
 		ClientIps:     parseClientIps(clientIps),
 		CorrelationId: *correlationId,
+		Timeout:       commandTimeout,
+		Output:        *output,
+		Parallelism:   *parallelism,
+		OnError:       *onError,
+		Stdin:         *stdin,
+		Pty:           *pty,
+		PtyTerm:       *ptyTerm,
+		PtyWidth:      ptyWidth,
+		PtyHeight:     ptyHeight,
+		PtyModes:      *ptyModes,
+		Uploads:       uploads,
+		Downloads:     downloads,
+		DryRun:        *dryRun,
+		Handshake:     *handshake,
 	}, nil
 }
@@ -74,3 +74,64 @@ func TestParseClientIps_Empty(t *testing.T) {
 		t.Fatalf("expected no warnings for empty input, got: %s", out)
 	}
 }
+
+func TestDestinationList_Set(t *testing.T) {
+	var destinations destinationList
+	destinations.Set("web-1 user@web-2")
+	destinations.Set("  ssh://deploy@web-3?jump=bastion1,bastion2 ")
+
+	want := []string{"web-1", "user@web-2", "ssh://deploy@web-3?jump=bastion1,bastion2"}
+	if len(destinations) != len(want) {
+		t.Fatalf("expected %d destinations, got %v", len(want), destinations)
+	}
+	for i := range want {
+		if destinations[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, destinations)
+		}
+	}
+}
+
+func TestParseWindowSize(t *testing.T) {
+	tests := []struct {
+		value   string
+		width   int
+		height  int
+		wantErr bool
+	}{
+		{value: "80x24", width: 80, height: 24},
+		{value: "132X43", width: 132, height: 43},
+		{value: "80", wantErr: true},
+		{value: "0x24", wantErr: true},
+		{value: "80x-1", wantErr: true},
+		{value: "wide x tall", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		width, height, err := parseWindowSize(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Fatalf("%q: expected error", tt.value)
+			}
+			continue
+		}
+		if err != nil || width != tt.width || height != tt.height {
+			t.Fatalf("%q: expected %dx%d, got %dx%d (err %v)", tt.value, tt.width, tt.height, width, height, err)
+		}
+	}
+}
+
+func TestParseParams(t *testing.T) {
+	params, err := parseParams([]string{"service=web", "tag=v1.2.3", "note=a=b", "empty="})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if params["service"] != "web" || params["tag"] != "v1.2.3" || params["note"] != "a=b" || params["empty"] != "" {
+		t.Fatalf("unexpected params: %v", params)
+	}
+
+	for _, values := range [][]string{{"service"}, {"=web"}, {"service=web", "service=worker"}} {
+		if _, err := parseParams(values); err == nil {
+			t.Fatalf("expected error for %v", values)
+		}
+	}
+}
@@ -5,6 +5,8 @@ package azure
 import (
 	"context"
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/keyvault/azsecrets"
@@ -16,20 +18,119 @@ func FetchSecretFromKeyVault(vaultUrl, secretName string) ([]byte, error) {
 		return nil, fmt.Errorf("WEBHOOK_KEYVAULT_URL or WEBHOOK_TOKEN_SECRET_NAME not set")
 	}
 
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	client, err := newSecretsClient(vaultUrl)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Azure credential: %w", err)
+		return nil, err
 	}
 
-	client, err := azsecrets.NewClient(vaultUrl, cred, nil)
+	resp, err := client.GetSecret(context.Background(), secretName, "", nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Key Vault client: %w", err)
+		return nil, fmt.Errorf("failed to get secret: %w", err)
 	}
 
-	resp, err := client.GetSecret(context.Background(), secretName, "", nil)
+	return []byte(*resp.Value), nil
+}
+
+// SecretVersion is one version of a Key Vault secret
+type SecretVersion struct {
+	Version string
+	Value   []byte
+}
+
+// FetchSecretVersionsFromKeyVault retrieves the current version of the secret followed by up to previous earlier
+// versions, newest first. Earlier versions that are disabled, expired or not yet valid are skipped. Listing versions
+// needs the list permission on secrets as well as get.
+func FetchSecretVersionsFromKeyVault(vaultUrl, secretName string, previous int) ([]SecretVersion, error) {
+	if vaultUrl == "" || secretName == "" {
+		return nil, fmt.Errorf("WEBHOOK_KEYVAULT_URL or WEBHOOK_TOKEN_SECRET_NAME not set")
+	}
+
+	client, err := newSecretsClient(vaultUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+
+	resp, err := client.GetSecret(ctx, secretName, "", nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get secret: %w", err)
 	}
+	current := SecretVersion{Value: []byte(*resp.Value)}
+	if resp.ID != nil {
+		current.Version = resp.ID.Version()
+	}
 
-	return []byte(*resp.Value), nil
+	versions := []SecretVersion{current}
+	if previous <= 0 {
+		return versions, nil
+	}
+
+	// List the other usable versions, newest first
+
+	type candidate struct {
+		version string
+		created time.Time
+	}
+	var candidates []candidate
+
+	now := time.Now()
+	pager := client.NewListSecretVersionsPager(secretName, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list secret versions: %w", err)
+		}
+		for _, item := range page.Value {
+			if item.ID == nil || item.ID.Version() == current.Version || !usable(item.Attributes, now) {
+				continue
+			}
+			c := candidate{version: item.ID.Version()}
+			if item.Attributes.Created != nil {
+				c.created = *item.Attributes.Created
+			}
+			candidates = append(candidates, c)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].created.After(candidates[j].created) })
+
+	for _, c := range candidates[:min(previous, len(candidates))] {
+		resp, err := client.GetSecret(ctx, secretName, c.version, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get secret version %s: %w", c.version, err)
+		}
+		versions = append(versions, SecretVersion{Version: c.version, Value: []byte(*resp.Value)})
+	}
+
+	return versions, nil
+}
+
+// usable reports whether a secret version with attributes is enabled and valid at now
+func usable(attributes *azsecrets.SecretAttributes, now time.Time) bool {
+	switch {
+	case attributes == nil || attributes.Enabled == nil || !*attributes.Enabled:
+		return false
+	case attributes.NotBefore != nil && now.Before(*attributes.NotBefore):
+		return false
+	case attributes.Expires != nil && !now.Before(*attributes.Expires):
+		return false
+	default:
+		return true
+	}
+}
+
+func newSecretsClient(vaultUrl string) (*azsecrets.Client, error) {
+
+	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Azure credential: %w", err)
+	}
+
+	client, err := azsecrets.NewClient(vaultUrl, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Key Vault client: %w", err)
+	}
+
+	return client, nil
 }
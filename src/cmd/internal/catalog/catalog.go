@@ -0,0 +1,223 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+
+// Package catalog renders named actions with typed parameters into remote commands
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/NobleFactor/docker-webhook/cmd/internal/shellquote"
+)
+
+// Parameter constrains the value of one action parameter. A value must be one of Enum when Enum is set and must
+// match all of Pattern when Pattern is set. A parameter without a Default is required.
+//
+// Quoting keeps a value a single word, but a program still reads a word that starts with - as an option, so such values
+// are rejected unless AllowLeadingDash is set.
+type Parameter struct {
+	Enum             []string `json:"enum"`
+	Pattern          string   `json:"pattern"`
+	Default          *string  `json:"default"`
+	AllowLeadingDash bool     `json:"allowLeadingDash"`
+
+	pattern *regexp.Regexp
+}
+
+// Action is a named command. Command is a text/template whose fields are the action's parameters, each of which is
+// shell-quoted before it is substituted. Timeout, when set, bounds the command's run time. Destinations lists the
+// destinations the action may run on, and is where it runs when the request names none. ExitReasons names what the
+// command's exit codes mean.
+type Action struct {
+	Command      string                `json:"command"`
+	Parameters   map[string]*Parameter `json:"parameters"`
+	Timeout      string                `json:"timeout"`
+	Destinations []string              `json:"destinations"`
+	ExitReasons  map[int]string        `json:"exitReasons"`
+
+	template *template.Template
+	timeout  time.Duration
+}
+
+// Catalog holds the actions that may be requested by name
+type Catalog struct {
+	Actions map[string]*Action `json:"actions"`
+}
+
+// Invocation is an action rendered for a request
+type Invocation struct {
+	Action       string
+	Command      string
+	Timeout      time.Duration
+	Destinations []string
+	ExitReasons  map[int]string
+}
+
+// InvalidRequestError reports an action request that the catalog rejects
+type InvalidRequestError struct {
+	Action  string
+	Problem string
+}
+
+func (e *InvalidRequestError) Error() string {
+	return fmt.Sprintf("action %s: %s", e.Action, e.Problem)
+}
+
+// Load reads the catalog at catalogPath. A missing file yields an empty catalog.
+func Load(catalogPath string) (*Catalog, error) {
+
+	data, err := os.ReadFile(catalogPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Catalog{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read catalog: %v", err)
+	}
+
+	var catalog Catalog
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return nil, fmt.Errorf("failed to parse catalog %s: %v", catalogPath, err)
+	}
+
+	for name, action := range catalog.Actions {
+		if err := action.compile(name); err != nil {
+			return nil, fmt.Errorf("invalid catalog %s: action %s: %v", catalogPath, name, err)
+		}
+	}
+
+	return &catalog, nil
+}
+
+func (action *Action) compile(name string) error {
+
+	if action == nil || action.Command == "" {
+		return errors.New("command is required")
+	}
+
+	for parameterName, parameter := range action.Parameters {
+		if parameter == nil {
+			return fmt.Errorf("parameter %s has no definition", parameterName)
+		}
+		if parameter.Pattern != "" {
+			pattern, err := regexp.Compile("^(?:" + parameter.Pattern + ")$")
+			if err != nil {
+				return fmt.Errorf("invalid pattern for parameter %s: %v", parameterName, err)
+			}
+			parameter.pattern = pattern
+		}
+		if parameter.Default != nil {
+			if problem := parameter.check(*parameter.Default); problem != "" {
+				return fmt.Errorf("default of parameter %s %s", parameterName, problem)
+			}
+		}
+	}
+
+	command, err := template.New(name).Option("missingkey=error").Parse(action.Command)
+	if err != nil {
+		return fmt.Errorf("invalid command template: %v", err)
+	}
+
+	// Render once with every parameter present so that a template naming an undeclared parameter fails to load
+
+	placeholders := make(map[string]string, len(action.Parameters))
+	for parameterName := range action.Parameters {
+		placeholders[parameterName] = ""
+	}
+	if err := command.Execute(io.Discard, placeholders); err != nil {
+		return fmt.Errorf("invalid command template: %v", err)
+	}
+	action.template = command
+
+	if action.Timeout != "" {
+		timeout, err := time.ParseDuration(action.Timeout)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("timeout must be a positive duration: %s", action.Timeout)
+		}
+		action.timeout = timeout
+	}
+
+	return nil
+}
+
+// check returns why value is not acceptable, or the empty string when it is
+func (parameter *Parameter) check(value string) string {
+	if strings.HasPrefix(value, "-") && !parameter.AllowLeadingDash {
+		return "may not start with -"
+	}
+	if len(parameter.Enum) > 0 && !slices.Contains(parameter.Enum, value) {
+		return fmt.Sprintf("must be one of %s", strings.Join(parameter.Enum, ", "))
+	}
+	if parameter.pattern != nil && !parameter.pattern.MatchString(value) {
+		return fmt.Sprintf("must match %s", parameter.Pattern)
+	}
+	return ""
+}
+
+// Render validates params against the named action and returns the action's command with the parameters substituted.
+// Unknown parameters, missing required parameters, invalid values and destinations the action may not run on are
+// reported as an *InvalidRequestError. When destinations is empty the invocation runs on the action's destinations.
+func (c *Catalog) Render(name string, params map[string]string, destinations []string) (Invocation, error) {
+
+	action, ok := c.Actions[name]
+	if !ok {
+		return Invocation{}, &InvalidRequestError{Action: name, Problem: "unknown action"}
+	}
+
+	var unknown []string
+	for parameterName := range params {
+		if _, ok := action.Parameters[parameterName]; !ok {
+			unknown = append(unknown, parameterName)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return Invocation{}, &InvalidRequestError{Action: name, Problem: "unknown parameters " + strings.Join(unknown, ", ")}
+	}
+
+	quoted := make(map[string]string, len(action.Parameters))
+
+	for parameterName, parameter := range action.Parameters {
+		value, ok := params[parameterName]
+		if !ok {
+			if parameter.Default == nil {
+				return Invocation{}, &InvalidRequestError{Action: name, Problem: "missing parameter " + parameterName}
+			}
+			value = *parameter.Default
+		}
+		if problem := parameter.check(value); problem != "" {
+			return Invocation{}, &InvalidRequestError{Action: name, Problem: fmt.Sprintf("parameter %s %s", parameterName, problem)}
+		}
+		quoted[parameterName] = shellquote.Quote(value)
+	}
+
+	if len(destinations) == 0 {
+		destinations = action.Destinations
+	}
+	if len(destinations) == 0 {
+		return Invocation{}, &InvalidRequestError{Action: name, Problem: "no destination given and the action lists none"}
+	}
+	if len(action.Destinations) > 0 {
+		for _, destination := range destinations {
+			if !slices.Contains(action.Destinations, destination) {
+				return Invocation{}, &InvalidRequestError{Action: name, Problem: "may not run on " + destination}
+			}
+		}
+	}
+
+	var command strings.Builder
+	if err := action.template.Execute(&command, quoted); err != nil {
+		return Invocation{}, fmt.Errorf("failed to render action %s: %v", name, err)
+	}
+
+	return Invocation{Action: name, Command: command.String(), Timeout: action.timeout, Destinations: destinations, ExitReasons: action.ExitReasons}, nil
+}
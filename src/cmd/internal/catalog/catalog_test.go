@@ -0,0 +1,151 @@
+package catalog
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testCatalog = `{
+  "actions": {
+    "redeploy": {
+      "command": "/srv/bin/redeploy {{.service}} {{.tag}}",
+      "parameters": {
+        "service": {"enum": ["web", "worker"]},
+        "tag": {"pattern": "v[0-9]+\\.[0-9]+\\.[0-9]+", "default": "v1.0.0"}
+      },
+      "timeout": "5m",
+      "destinations": ["deploy@web-1", "deploy@web-2"],
+      "exitReasons": {"3": "Image Not Found"}
+    },
+    "echo": {
+      "command": "echo {{.message}}",
+      "parameters": {"message": {}}
+    },
+    "grep": {
+      "command": "grep {{.flags}} -- {{.pattern}} /var/log/app.log",
+      "parameters": {"flags": {"enum": ["-i", "-v"], "allowLeadingDash": true}, "pattern": {}},
+      "destinations": ["deploy@web-1"]
+    }
+  }
+}`
+
+func loadCatalog(t *testing.T, content string) (*Catalog, error) {
+	t.Helper()
+	catalogPath := filepath.Join(t.TempDir(), "catalog.json")
+	if err := os.WriteFile(catalogPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write catalog: %v", err)
+	}
+	return Load(catalogPath)
+}
+
+func TestRender(t *testing.T) {
+	catalog, err := loadCatalog(t, testCatalog)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	invocation, err := catalog.Render("redeploy", map[string]string{"service": "web"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if invocation.Command != "/srv/bin/redeploy web v1.0.0" {
+		t.Fatalf("unexpected command %q", invocation.Command)
+	}
+	if invocation.Timeout != 5*time.Minute {
+		t.Fatalf("unexpected timeout %s", invocation.Timeout)
+	}
+	if len(invocation.Destinations) != 2 {
+		t.Fatalf("want the action's destinations, got %v", invocation.Destinations)
+	}
+	if invocation.ExitReasons[3] != "Image Not Found" {
+		t.Fatalf("want the action's exit reasons, got %v", invocation.ExitReasons)
+	}
+
+	invocation, err = catalog.Render("redeploy", map[string]string{"service": "worker", "tag": "v2.3.4"}, []string{"deploy@web-2"})
+	if err != nil || invocation.Command != "/srv/bin/redeploy worker v2.3.4" || len(invocation.Destinations) != 1 {
+		t.Fatalf("unexpected invocation %+v (err %v)", invocation, err)
+	}
+}
+
+func TestRender_QuotesValues(t *testing.T) {
+	catalog, err := loadCatalog(t, testCatalog)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	invocation, err := catalog.Render("echo", map[string]string{"message": "it's $(id); `id`"}, []string{"anywhere"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := `echo 'it'\''s $(id); ` + "`id`'"; invocation.Command != want {
+		t.Fatalf("want %s, got %s", want, invocation.Command)
+	}
+}
+
+func TestRender_AllowsLeadingDash(t *testing.T) {
+	catalog, err := loadCatalog(t, testCatalog)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	invocation, err := catalog.Render("grep", map[string]string{"flags": "-i", "pattern": "error"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "grep -i -- error /var/log/app.log"; invocation.Command != want {
+		t.Fatalf("want %s, got %s", want, invocation.Command)
+	}
+}
+
+func TestRender_Rejects(t *testing.T) {
+	catalog, err := loadCatalog(t, testCatalog)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		action       string
+		params       map[string]string
+		destinations []string
+	}{
+		{name: "unknown action", action: "reboot", params: map[string]string{}},
+		{name: "unknown parameter", action: "redeploy", params: map[string]string{"service": "web", "force": "yes"}},
+		{name: "missing parameter", action: "redeploy", params: map[string]string{}},
+		{name: "value outside the enum", action: "redeploy", params: map[string]string{"service": "db"}},
+		{name: "value not matching the pattern", action: "redeploy", params: map[string]string{"service": "web", "tag": "v1.0.0; reboot"}},
+		{name: "destination not listed", action: "redeploy", params: map[string]string{"service": "web"}, destinations: []string{"root@db-1"}},
+		{name: "no destination", action: "echo", params: map[string]string{"message": "hi"}},
+		{name: "value starting with a dash", action: "echo", params: map[string]string{"message": "--privileged"}, destinations: []string{"anywhere"}},
+		{name: "dash allowed only where opted in", action: "grep", params: map[string]string{"flags": "-i", "pattern": "-e"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := catalog.Render(tt.action, tt.params, tt.destinations)
+			var invalid *InvalidRequestError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("want an invalid request, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	for _, content := range []string{
+		`{"actions": {"a": {}}}`,
+		`{"actions": {"a": {"command": "echo {{.undeclared}}"}}}`,
+		`{"actions": {"a": {"command": "echo {{.x", "parameters": {"x": {}}}}}`,
+		`{"actions": {"a": {"command": "echo {{.x}}", "parameters": {"x": {"pattern": "("}}}}}`,
+		`{"actions": {"a": {"command": "echo {{.x}}", "parameters": {"x": {"enum": ["a"], "default": "b"}}}}}`,
+		`{"actions": {"a": {"command": "true", "timeout": "soon"}}}`,
+		`{"actions": {"a": {"command": "echo {{.x}}", "parameters": {"x": {"default": "-n"}}}}}`,
+	} {
+		if _, err := loadCatalog(t, content); err == nil {
+			t.Fatalf("want error for %s", content)
+		}
+	}
+}
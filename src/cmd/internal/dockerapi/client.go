@@ -0,0 +1,152 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+
+// Package dockerapi is a minimal client for the Docker Engine API
+package dockerapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// APIVersion is the Engine API version the client requests. Docker 20.10 and later serve it.
+const APIVersion = "v1.41"
+
+// DialFunc opens a connection to the Docker daemon
+type DialFunc func() (net.Conn, error)
+
+// UnixSocket returns a DialFunc that connects to the daemon listening on the Unix socket at socketPath
+func UnixSocket(socketPath string) DialFunc {
+	return func() (net.Conn, error) {
+		return net.Dial("unix", socketPath)
+	}
+}
+
+// Client talks to one Docker daemon over connections opened by its DialFunc
+type Client struct {
+	dial DialFunc
+	http *http.Client
+}
+
+// NewClient returns a client for the daemon reached through dial
+func NewClient(dial DialFunc) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dial()
+		},
+	}
+	return &Client{dial: dial, http: &http.Client{Transport: transport}}
+}
+
+// Error is an error response from the daemon
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("docker: %s (HTTP %d)", e.Message, e.StatusCode)
+}
+
+// NotFound reports whether err is a daemon response saying that the object named in the request does not exist
+func NotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// newRequest builds a request for path, which is relative to the API version, with body encoded as JSON when not nil
+func newRequest(ctx context.Context, method, path string, query url.Values, body any) (*http.Request, error) {
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	target := "http://docker/" + APIVersion + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	return request, nil
+}
+
+// send sends a request and returns the daemon's response, or an *Error when its status is not a success. The caller
+// closes the response body.
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, body any) (*http.Response, error) {
+
+	request, err := newRequest(ctx, method, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("docker: %v", err)
+	}
+
+	if err := checkResponse(response); err != nil {
+		response.Body.Close()
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// do sends a request and decodes a JSON response into out when out is not nil
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body any, out any) error {
+
+	response, err := c.send(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if out == nil {
+		io.Copy(io.Discard, response.Body)
+		return nil
+	}
+	if err := json.NewDecoder(response.Body).Decode(out); err != nil {
+		return fmt.Errorf("docker: invalid response to %s %s: %v", method, path, err)
+	}
+
+	return nil
+}
+
+// checkResponse returns an *Error for a response whose status is not a success. Not Modified, which the daemon
+// returns when asked to start a running container or stop a stopped one, is not an error.
+func checkResponse(response *http.Response) error {
+
+	if response.StatusCode < 300 || response.StatusCode == http.StatusNotModified {
+		return nil
+	}
+
+	var message struct {
+		Message string `json:"message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+	if json.Unmarshal(data, &message) != nil || message.Message == "" {
+		message.Message = strings.TrimSpace(string(data))
+	}
+
+	return &Error{StatusCode: response.StatusCode, Message: message.Message}
+}
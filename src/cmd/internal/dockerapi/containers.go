@@ -0,0 +1,185 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+package dockerapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// containerName matches a container name or ID
+var containerName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// ValidContainerName reports whether name is a container name or ID the client will send to the daemon
+func ValidContainerName(name string) bool {
+	return containerName.MatchString(name)
+}
+
+// Container is the part of a container's inspection that operations use
+type Container struct {
+	Id    string         `json:"Id"`
+	Name  string         `json:"Name"`
+	Image string         `json:"Image"`
+	State ContainerState `json:"State"`
+
+	Config struct {
+		Image    string `json:"Image"`
+		Hostname string `json:"Hostname"`
+	} `json:"Config"`
+
+	// The settings the container was created with, kept verbatim so that Recreate can create its replacement
+
+	rawConfig     map[string]json.RawMessage
+	rawHostConfig json.RawMessage
+	rawNetworks   map[string]json.RawMessage
+}
+
+// ContainerState is the state of a container
+type ContainerState struct {
+	Status  string `json:"Status"`
+	Running bool   `json:"Running"`
+	Health  *struct {
+		Status string `json:"Status"`
+	} `json:"Health"`
+}
+
+// HealthStatus returns the status of the container's health check, or "" when it has none
+func (state ContainerState) HealthStatus() string {
+	if state.Health == nil {
+		return ""
+	}
+	return state.Health.Status
+}
+
+// ContainerSummary is a container as listed by the daemon
+type ContainerSummary struct {
+	Id      string   `json:"Id"`
+	Names   []string `json:"Names"`
+	Image   string   `json:"Image"`
+	ImageID string   `json:"ImageID"`
+	State   string   `json:"State"`
+	Status  string   `json:"Status"`
+}
+
+// ContainerInspect returns the container named by name, which may also be a container ID
+func (c *Client) ContainerInspect(ctx context.Context, name string) (*Container, error) {
+
+	if !ValidContainerName(name) {
+		return nil, fmt.Errorf("docker: invalid container name %s", name)
+	}
+
+	var data json.RawMessage
+	if err := c.do(ctx, http.MethodGet, "/containers/"+name+"/json", nil, nil, &data); err != nil {
+		return nil, err
+	}
+
+	var container Container
+	var raw struct {
+		Config          map[string]json.RawMessage `json:"Config"`
+		HostConfig      json.RawMessage            `json:"HostConfig"`
+		NetworkSettings struct {
+			Networks map[string]json.RawMessage `json:"Networks"`
+		} `json:"NetworkSettings"`
+	}
+	if err := json.Unmarshal(data, &container); err != nil {
+		return nil, fmt.Errorf("docker: invalid inspection of container %s: %v", name, err)
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("docker: invalid inspection of container %s: %v", name, err)
+	}
+
+	container.Name = strings.TrimPrefix(container.Name, "/")
+	container.rawConfig, container.rawHostConfig, container.rawNetworks = raw.Config, raw.HostConfig, raw.NetworkSettings.Networks
+
+	return &container, nil
+}
+
+// ContainerList returns every container, running or not, or only those whose name contains name when it is not empty
+func (c *Client) ContainerList(ctx context.Context, name string) ([]ContainerSummary, error) {
+
+	query := url.Values{"all": {"1"}}
+	if name != "" {
+		filters, err := json.Marshal(map[string][]string{"name": {name}})
+		if err != nil {
+			return nil, err
+		}
+		query.Set("filters", string(filters))
+	}
+
+	var containers []ContainerSummary
+	if err := c.do(ctx, http.MethodGet, "/containers/json", query, nil, &containers); err != nil {
+		return nil, err
+	}
+
+	return containers, nil
+}
+
+// createBody returns the request that creates a container configured as container was. The hostname is dropped when
+// it is the one the daemon derived from the old container's ID, so that the replacement gets its own.
+func (container *Container) createBody() map[string]any {
+
+	body := make(map[string]any, len(container.rawConfig)+2)
+	for key, value := range container.rawConfig {
+		body[key] = value
+	}
+	if len(container.Id) >= 12 && container.Config.Hostname == container.Id[:12] {
+		delete(body, "Hostname")
+	}
+
+	body["HostConfig"] = container.rawHostConfig
+
+	// Keep only the endpoint settings a user can choose, less the alias the daemon derived from the old container's ID
+
+	endpoints := make(map[string]any, len(container.rawNetworks))
+	for network, raw := range container.rawNetworks {
+		var settings struct {
+			IPAMConfig json.RawMessage `json:"IPAMConfig,omitempty"`
+			Links      []string        `json:"Links,omitempty"`
+			Aliases    []string        `json:"Aliases,omitempty"`
+		}
+		json.Unmarshal(raw, &settings)
+		if string(settings.IPAMConfig) == "null" {
+			settings.IPAMConfig = nil
+		}
+		settings.Aliases = slices.DeleteFunc(settings.Aliases, func(alias string) bool {
+			return len(alias) >= 12 && strings.HasPrefix(container.Id, alias)
+		})
+		endpoints[network] = settings
+	}
+	body["NetworkingConfig"] = map[string]any{"EndpointsConfig": endpoints}
+
+	return body
+}
+
+func (c *Client) containerCreate(ctx context.Context, name string, body map[string]any) (string, error) {
+	var created struct {
+		Id string `json:"Id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/containers/create", url.Values{"name": {name}}, body, &created); err != nil {
+		return "", err
+	}
+	return created.Id, nil
+}
+
+func (c *Client) containerStart(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, "/containers/"+id+"/start", nil, nil, nil)
+}
+
+func (c *Client) containerStop(ctx context.Context, id string, seconds int) error {
+	return c.do(ctx, http.MethodPost, "/containers/"+id+"/stop", url.Values{"t": {strconv.Itoa(seconds)}}, nil, nil)
+}
+
+func (c *Client) containerRename(ctx context.Context, id, name string) error {
+	return c.do(ctx, http.MethodPost, "/containers/"+id+"/rename", url.Values{"name": {name}}, nil, nil)
+}
+
+func (c *Client) containerRemove(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/containers/"+id, url.Values{"force": {"1"}}, nil, nil)
+}
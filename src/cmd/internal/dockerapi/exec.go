@@ -0,0 +1,186 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+package dockerapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ErrStopped is returned by Exec when its stop channel is closed before the command finishes. The Engine API cannot
+// signal an exec'd process, so the command is abandoned rather than killed and may still be running.
+var ErrStopped = errors.New("docker: stopped waiting for the command")
+
+// ExecOptions describes a command to run in a container
+type ExecOptions struct {
+	Cmd []string
+	Env []string
+
+	// Tty runs the command on a pseudo-terminal, which merges its stderr into stdout
+	Tty bool
+
+	// Stdin, when not nil, is copied to the command's standard input, which is then closed
+	Stdin io.Reader
+
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Exec runs a command in container, copies its output to options.Stdout and options.Stderr, and returns its exit
+// code. Closing stop abandons the command and returns ErrStopped.
+func (c *Client) Exec(container string, options ExecOptions, stop <-chan struct{}) (int, error) {
+
+	config := map[string]any{
+		"AttachStdin":  options.Stdin != nil,
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"Tty":          options.Tty,
+		"Env":          options.Env,
+		"Cmd":          options.Cmd,
+	}
+
+	var created struct {
+		Id string `json:"Id"`
+	}
+	if err := c.do(context.Background(), http.MethodPost, "/containers/"+url.PathEscape(container)+"/exec", nil, config, &created); err != nil {
+		return -1, err
+	}
+
+	finished := make(chan error, 1)
+	go func() {
+		finished <- c.startExec(created.Id, options, stop)
+	}()
+
+	select {
+	case err := <-finished:
+		if err != nil {
+			return -1, err
+		}
+	case <-stop:
+		<-finished
+		return -1, ErrStopped
+	}
+
+	return c.execExitCode(created.Id)
+}
+
+// startExec starts exec id with its streams attached and copies its output until the command closes them
+func (c *Client) startExec(id string, options ExecOptions, stop <-chan struct{}) error {
+
+	request, err := newRequest(context.Background(), http.MethodPost, "/exec/"+id+"/start", nil, map[string]any{"Detach": false, "Tty": options.Tty})
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Upgrade", "tcp")
+
+	// The daemon hijacks the connection for the exec's streams, so speak HTTP on a connection of our own
+
+	conn, err := c.dial()
+	if err != nil {
+		return fmt.Errorf("docker: %v", err)
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-stop:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	if err := request.Write(conn); err != nil {
+		return fmt.Errorf("docker: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		return fmt.Errorf("docker: %v", err)
+	}
+	if err := checkResponse(response); err != nil {
+		return err
+	}
+
+	var output io.Reader = reader
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		output = response.Body
+	}
+
+	if options.Stdin != nil {
+		go func() {
+			io.Copy(conn, options.Stdin)
+			if closer, ok := conn.(interface{ CloseWrite() error }); ok {
+				closer.CloseWrite()
+			}
+		}()
+	}
+
+	if options.Tty {
+		_, err = io.Copy(options.Stdout, output)
+	} else {
+		err = demultiplex(output, options.Stdout, options.Stderr)
+	}
+	if err != nil {
+		return fmt.Errorf("docker: failed to read the command's output: %v", err)
+	}
+
+	return nil
+}
+
+// execExitCode returns the exit code of a finished exec, waiting briefly for the daemon to record it
+func (c *Client) execExitCode(id string) (int, error) {
+
+	var inspected struct {
+		Running  bool `json:"Running"`
+		ExitCode int  `json:"ExitCode"`
+	}
+
+	for attempt := 0; attempt < 20; attempt++ {
+		if err := c.do(context.Background(), http.MethodGet, "/exec/"+id+"/json", nil, nil, &inspected); err != nil {
+			return -1, err
+		}
+		if !inspected.Running {
+			return inspected.ExitCode, nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return -1, errors.New("docker: the command closed its output but is still running")
+}
+
+// demultiplex splits the daemon's multiplexed stream into stdout and stderr. Each frame is an 8-byte header, holding
+// the stream in its first byte and the payload size as a big-endian uint32 in its last four, followed by the payload.
+func demultiplex(r io.Reader, stdout, stderr io.Writer) error {
+
+	header := make([]byte, 8)
+
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		w := stdout
+		if header[0] == 2 {
+			w = stderr
+		}
+
+		if _, err := io.CopyN(w, r, int64(binary.BigEndian.Uint32(header[4:]))); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package dockerapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDaemon is an in-process stand-in for the Docker daemon listening on a Unix socket
+type fakeDaemon struct {
+	socket string
+	mutex  sync.Mutex
+	execs  map[string]fakeExec
+	run    func(exec fakeExec, stdin []byte, stdout, stderr io.Writer) int
+}
+
+type fakeExec struct {
+	Container   string
+	Cmd         []string
+	Env         []string
+	Tty         bool
+	AttachStdin bool
+	exitCode    int
+	done        bool
+}
+
+func startFakeDaemon(t *testing.T, run func(exec fakeExec, stdin []byte, stdout, stderr io.Writer) int) *fakeDaemon {
+	t.Helper()
+
+	daemon := &fakeDaemon{execs: map[string]fakeExec{}, run: run}
+	daemon.socket = serveUnix(t, daemon)
+
+	return daemon
+}
+
+// serveUnix serves handler on a Unix socket until the test ends and returns the socket's path
+func serveUnix(t *testing.T, handler http.Handler) string {
+	t.Helper()
+
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	return socket
+}
+
+func (d *fakeDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	path := strings.TrimPrefix(r.URL.Path, "/"+APIVersion)
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	switch {
+	case len(parts) == 3 && parts[0] == "containers" && parts[2] == "exec":
+		if parts[1] != "web" {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "No such container: " + parts[1]})
+			return
+		}
+		var exec fakeExec
+		json.NewDecoder(r.Body).Decode(&exec)
+		exec.Container = parts[1]
+		d.mutex.Lock()
+		id := "exec" + string(rune('a'+len(d.execs)))
+		d.execs[id] = exec
+		d.mutex.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"Id": id})
+
+	case len(parts) == 3 && parts[0] == "exec" && parts[2] == "start":
+		d.mutex.Lock()
+		exec := d.execs[parts[1]]
+		d.mutex.Unlock()
+
+		var start struct{ Detach, Tty bool }
+		json.NewDecoder(r.Body).Decode(&start)
+
+		conn, buffered, _ := w.(http.Hijacker).Hijack()
+		defer conn.Close()
+		buffered.WriteString("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.multiplexed-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+		buffered.Flush()
+
+		var stdin []byte
+		if exec.AttachStdin {
+			stdin, _ = io.ReadAll(buffered)
+		}
+
+		var stdout, stderr bytes.Buffer
+		exec.exitCode = d.run(exec, stdin, &stdout, &stderr)
+		exec.done = true
+
+		if exec.Tty {
+			conn.Write(stdout.Bytes())
+		} else {
+			writeFrame(conn, 1, stdout.Bytes())
+			writeFrame(conn, 2, stderr.Bytes())
+		}
+
+		d.mutex.Lock()
+		d.execs[parts[1]] = exec
+		d.mutex.Unlock()
+
+	case len(parts) == 3 && parts[0] == "exec" && parts[2] == "json":
+		d.mutex.Lock()
+		exec := d.execs[parts[1]]
+		d.mutex.Unlock()
+		json.NewEncoder(w).Encode(map[string]any{"Running": !exec.done, "ExitCode": exec.exitCode})
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"message": "page not found"})
+	}
+}
+
+func writeFrame(w io.Writer, stream byte, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+	w.Write(append(header, data...))
+}
+
+func TestExec(t *testing.T) {
+	var got fakeExec
+	daemon := startFakeDaemon(t, func(exec fakeExec, stdin []byte, stdout, stderr io.Writer) int {
+		got = exec
+		stdout.Write(stdin)
+		stderr.Write([]byte("warning\n"))
+		return 3
+	})
+
+	client := NewClient(UnixSocket(daemon.socket))
+
+	var stdout, stderr bytes.Buffer
+	exitCode, err := client.Exec("web", ExecOptions{
+		Cmd:    []string{"/bin/sh", "-c", "cat"},
+		Env:    []string{"WEBHOOK_CORRELATION_ID=cid-1"},
+		Stdin:  strings.NewReader("payload"),
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}, nil)
+
+	if err != nil || exitCode != 3 {
+		t.Fatalf("want exit code 3, got %d (err %v)", exitCode, err)
+	}
+	if stdout.String() != "payload" || stderr.String() != "warning\n" {
+		t.Fatalf("want demultiplexed output, got stdout %q stderr %q", stdout.String(), stderr.String())
+	}
+	if strings.Join(got.Cmd, " ") != "/bin/sh -c cat" || len(got.Env) != 1 || !got.AttachStdin {
+		t.Fatalf("unexpected exec config: %+v", got)
+	}
+}
+
+func TestExec_NotFound(t *testing.T) {
+	daemon := startFakeDaemon(t, nil)
+
+	_, err := NewClient(UnixSocket(daemon.socket)).Exec("db", ExecOptions{Cmd: []string{"true"}, Stdout: io.Discard, Stderr: io.Discard}, nil)
+	if !NotFound(err) || !strings.Contains(err.Error(), "No such container: db") {
+		t.Fatalf("want a not found error, got %v", err)
+	}
+}
+
+func TestExec_Stop(t *testing.T) {
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	daemon := startFakeDaemon(t, func(exec fakeExec, stdin []byte, stdout, stderr io.Writer) int {
+		<-release
+		return 0
+	})
+
+	stop := make(chan struct{})
+	time.AfterFunc(100*time.Millisecond, func() { close(stop) })
+
+	_, err := NewClient(UnixSocket(daemon.socket)).Exec("web", ExecOptions{Cmd: []string{"sleep", "60"}, Stdout: io.Discard, Stderr: io.Discard}, stop)
+	if err != ErrStopped {
+		t.Fatalf("want ErrStopped, got %v", err)
+	}
+}
+
+func TestDemultiplex(t *testing.T) {
+	var stream bytes.Buffer
+	writeFrame(&stream, 1, []byte("out1 "))
+	writeFrame(&stream, 2, []byte("err"))
+	writeFrame(&stream, 1, []byte("out2"))
+
+	var stdout, stderr bytes.Buffer
+	if err := demultiplex(bufio.NewReader(&stream), &stdout, &stderr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stdout.String() != "out1 out2" || stderr.String() != "err" {
+		t.Fatalf("unexpected output: stdout %q stderr %q", stdout.String(), stderr.String())
+	}
+
+	truncated := bytes.NewReader([]byte{1, 0, 0, 0, 0, 0, 0, 9, 'a'})
+	if err := demultiplex(truncated, io.Discard, io.Discard); err == nil {
+		t.Fatalf("want an error for a truncated frame")
+	}
+}
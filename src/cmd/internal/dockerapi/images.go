@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+package dockerapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// imageReference matches an image reference such as nginx, nginx:1.27, ghcr.io/org/app:v1 or app@sha256:...
+var imageReference = regexp.MustCompile(`^[a-z0-9][a-z0-9._/:-]*(@sha256:[a-f0-9]{64})?$`)
+
+// ValidReference reports whether reference is an image reference the client will send to the daemon
+func ValidReference(reference string) bool {
+	return imageReference.MatchString(reference) && !strings.Contains(reference, "..") && !strings.Contains(reference, "//")
+}
+
+// Image is the part of an image's inspection that operations report
+type Image struct {
+	Id          string   `json:"Id"`
+	RepoTags    []string `json:"RepoTags"`
+	RepoDigests []string `json:"RepoDigests"`
+}
+
+// Digest returns the registry digest of the image for the repository of reference, or the first digest the image
+// has when none matches. Images that were built locally rather than pulled have none.
+func (image *Image) Digest(reference string) string {
+
+	repository, _ := splitReference(reference)
+
+	digest := ""
+	for _, repoDigest := range image.RepoDigests {
+		name, d, ok := strings.Cut(repoDigest, "@")
+		if !ok {
+			continue
+		}
+		if name == repository {
+			return d
+		}
+		if digest == "" {
+			digest = d
+		}
+	}
+
+	return digest
+}
+
+// ImageInspect returns the image named by reference, which may also be an image ID
+func (c *Client) ImageInspect(ctx context.Context, reference string) (*Image, error) {
+
+	if !ValidReference(reference) {
+		return nil, fmt.Errorf("docker: invalid image reference %s", reference)
+	}
+
+	var image Image
+	if err := c.do(ctx, http.MethodGet, "/images/"+reference+"/json", nil, nil, &image); err != nil {
+		return nil, err
+	}
+
+	return &image, nil
+}
+
+// ImagePull pulls the image named by reference, waiting until the daemon has finished. A reference without a tag or
+// digest pulls the latest tag. The daemon pulls anonymously: credentials stored for the docker CLI are not used.
+func (c *Client) ImagePull(ctx context.Context, reference string) error {
+
+	if !ValidReference(reference) {
+		return fmt.Errorf("docker: invalid image reference %s", reference)
+	}
+
+	repository, tag := splitReference(reference)
+
+	response, err := c.send(ctx, http.MethodPost, "/images/create", url.Values{"fromImage": {repository}, "tag": {tag}}, nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	// The daemon reports progress, and any failure, as a stream of JSON messages after a successful status
+
+	decoder := json.NewDecoder(response.Body)
+
+	for {
+		var message struct {
+			Error string `json:"error"`
+		}
+		if err := decoder.Decode(&message); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("docker: failed to read the progress of pulling %s: %v", reference, err)
+		}
+		if message.Error != "" {
+			return fmt.Errorf("docker: failed to pull %s: %s", reference, message.Error)
+		}
+	}
+}
+
+// splitReference splits an image reference into its repository and its tag or digest, which defaults to latest
+func splitReference(reference string) (string, string) {
+
+	if repository, digest, ok := strings.Cut(reference, "@"); ok {
+		return repository, digest
+	}
+
+	if colon := strings.LastIndex(reference, ":"); colon > strings.LastIndex(reference, "/") {
+		return reference[:colon], reference[colon+1:]
+	}
+
+	return reference, "latest"
+}
@@ -0,0 +1,294 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+package dockerapi
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Report is the structured result of a container operation
+type Report struct {
+	Operation  string            `json:"operation"`
+	Image      *ImageReport      `json:"image,omitempty"`
+	Containers []ContainerReport `json:"containers,omitempty"`
+	Warnings   []string          `json:"warnings,omitempty"`
+}
+
+// ImageReport describes the image a reference named before and after it was pulled
+type ImageReport struct {
+	Reference    string `json:"reference"`
+	IdBefore     string `json:"idBefore,omitempty"`
+	DigestBefore string `json:"digestBefore,omitempty"`
+	IdAfter      string `json:"idAfter,omitempty"`
+	DigestAfter  string `json:"digestAfter,omitempty"`
+	Updated      bool   `json:"updated"`
+}
+
+// ContainerReport describes a container. PreviousId is the ID of the container it replaced.
+type ContainerReport struct {
+	Name        string `json:"name"`
+	Id          string `json:"id"`
+	PreviousId  string `json:"previousId,omitempty"`
+	Image       string `json:"image"`
+	ImageId     string `json:"imageId"`
+	ImageDigest string `json:"imageDigest,omitempty"`
+	State       string `json:"state"`
+	Health      string `json:"health,omitempty"`
+	Status      string `json:"status,omitempty"`
+}
+
+// Unhealthy reports whether a container in the report failed its health check
+func (report *Report) Unhealthy() bool {
+	for _, container := range report.Containers {
+		if container.Health == "unhealthy" {
+			return true
+		}
+	}
+	return false
+}
+
+// stopTimeout is how many seconds Recreate gives the old container to stop before the daemon kills it
+const stopTimeout = 10
+
+// healthPollInterval is how often Recreate checks the health of the new container while its health check is starting
+var healthPollInterval = time.Second
+
+// Pull pulls the image named by reference and reports whether that changed the image the reference names
+func (c *Client) Pull(ctx context.Context, reference string) (*Report, error) {
+	report := &Report{Operation: "pull"}
+	image, err := c.pull(ctx, reference)
+	report.Image = image
+	return report, err
+}
+
+// Inspect reports the state of the container named by name
+func (c *Client) Inspect(ctx context.Context, name string) (*Report, error) {
+
+	container, err := c.ContainerInspect(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Report{Operation: "inspect", Containers: []ContainerReport{c.containerReport(ctx, container)}}, nil
+}
+
+// List reports every container, or those whose name contains name when it is not empty
+func (c *Client) List(ctx context.Context, name string) (*Report, error) {
+
+	containers, err := c.ContainerList(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Operation: "list", Containers: make([]ContainerReport, 0, len(containers))}
+	digests := map[string]string{}
+
+	for _, container := range containers {
+
+		digest, ok := digests[container.ImageID]
+		if !ok {
+			if image, err := c.ImageInspect(ctx, container.ImageID); err == nil {
+				digest = image.Digest(container.Image)
+			}
+			digests[container.ImageID] = digest
+		}
+
+		name := ""
+		if len(container.Names) > 0 {
+			name = strings.TrimPrefix(container.Names[0], "/")
+		}
+
+		report.Containers = append(report.Containers, ContainerReport{
+			Name:        name,
+			Id:          container.Id,
+			Image:       container.Image,
+			ImageId:     container.ImageID,
+			ImageDigest: digest,
+			State:       container.State,
+			Health:      summaryHealth(container.Status),
+			Status:      container.Status,
+		})
+	}
+
+	return report, nil
+}
+
+// Recreate pulls the image of the container named by name and, as docker compose up does for a service whose image
+// changed, replaces the container with a new one created from the same configuration. When the pull leaves the image
+// the container already runs, the container is left as it is and reported without a PreviousId. The old container is
+// stopped and renamed aside until its replacement has started, and is restored if the replacement cannot be created or
+// started. When the image has a health check, Recreate waits until it is no longer starting or ctx is done.
+//
+// Only pulling and waiting for health are cut short when ctx is done: once the old container is stopped, the
+// replacement or the restoration is carried through.
+func (c *Client) Recreate(ctx context.Context, name string) (*Report, error) {
+
+	old, err := c.ContainerInspect(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Operation: "recreate"}
+
+	image, err := c.pull(ctx, old.Config.Image)
+	report.Image = image
+	if err != nil {
+		return report, err
+	}
+
+	// The image the container runs may no longer be the one its reference named before the pull, so the before values
+	// describe the container's own image instead
+
+	image.IdBefore, image.DigestBefore = old.Image, ""
+	if before, err := c.ImageInspect(ctx, old.Image); err == nil {
+		image.DigestBefore = before.Digest(old.Config.Image)
+	}
+	image.Updated = image.IdAfter != image.IdBefore
+
+	if !image.Updated {
+		report.Containers = []ContainerReport{c.containerReport(context.WithoutCancel(ctx), old)}
+		return report, nil
+	}
+
+	id, err := c.replace(context.WithoutCancel(ctx), old)
+	if err != nil {
+		return report, err
+	}
+
+	if err := c.containerRemove(context.WithoutCancel(ctx), old.Id); err != nil {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("failed to remove the previous container %s: %v", old.Id, err))
+	}
+
+	container, err := c.waitForHealth(ctx, id)
+	if err != nil {
+		return report, err
+	}
+
+	containerReport := c.containerReport(context.WithoutCancel(ctx), container)
+	containerReport.PreviousId = old.Id
+	report.Containers = []ContainerReport{containerReport}
+
+	return report, nil
+}
+
+// pull pulls reference and describes the image it named before and after
+func (c *Client) pull(ctx context.Context, reference string) (*ImageReport, error) {
+
+	image := &ImageReport{Reference: reference}
+
+	before, err := c.ImageInspect(ctx, reference)
+	switch {
+	case err == nil:
+		image.IdBefore, image.DigestBefore = before.Id, before.Digest(reference)
+	case !NotFound(err):
+		return image, err
+	}
+
+	if err := c.ImagePull(ctx, reference); err != nil {
+		return image, err
+	}
+
+	after, err := c.ImageInspect(ctx, reference)
+	if err != nil {
+		return image, err
+	}
+	image.IdAfter, image.DigestAfter = after.Id, after.Digest(reference)
+	image.Updated = image.IdAfter != image.IdBefore
+
+	return image, nil
+}
+
+// replace stops old, renames it aside and starts a container configured as old was under its name. When the new
+// container cannot be created or started, it is removed and old is restored.
+func (c *Client) replace(ctx context.Context, old *Container) (string, error) {
+
+	if old.State.Running {
+		if err := c.containerStop(ctx, old.Id, stopTimeout); err != nil {
+			return "", err
+		}
+	}
+
+	restart := func() string {
+		if !old.State.Running {
+			return ""
+		}
+		if err := c.containerStart(ctx, old.Id); err != nil {
+			return fmt.Sprintf("; restarting the previous container also failed: %v", err)
+		}
+		return ""
+	}
+
+	aside := fmt.Sprintf("%s-replaced-%.12s", old.Name, old.Id)
+	if err := c.containerRename(ctx, old.Id, aside); err != nil {
+		return "", fmt.Errorf("%w%s", err, restart())
+	}
+
+	restore := func(id string, cause error) error {
+		if id != "" {
+			c.containerRemove(ctx, id)
+		}
+		if err := c.containerRename(ctx, old.Id, old.Name); err != nil {
+			return fmt.Errorf("%w; restoring the previous container also failed: it is named %s: %v", cause, aside, err)
+		}
+		return fmt.Errorf("%w; the previous container was restored%s", cause, restart())
+	}
+
+	id, err := c.containerCreate(ctx, old.Name, old.createBody())
+	if err != nil {
+		return "", restore("", err)
+	}
+	if err := c.containerStart(ctx, id); err != nil {
+		return "", restore(id, err)
+	}
+
+	return id, nil
+}
+
+// waitForHealth inspects container id until its health check, if it has one, is no longer starting or ctx is done
+func (c *Client) waitForHealth(ctx context.Context, id string) (*Container, error) {
+
+	for {
+		container, err := c.ContainerInspect(context.WithoutCancel(ctx), id)
+		if err != nil || container.State.HealthStatus() != "starting" {
+			return container, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return container, nil
+		case <-time.After(healthPollInterval):
+		}
+	}
+}
+
+// containerReport describes container, looking up the digest of its image
+func (c *Client) containerReport(ctx context.Context, container *Container) ContainerReport {
+
+	report := ContainerReport{
+		Name:    container.Name,
+		Id:      container.Id,
+		Image:   container.Config.Image,
+		ImageId: container.Image,
+		State:   container.State.Status,
+		Health:  container.State.HealthStatus(),
+	}
+
+	if image, err := c.ImageInspect(ctx, container.Image); err == nil {
+		report.ImageDigest = image.Digest(container.Config.Image)
+	}
+
+	return report
+}
+
+// summaryHealth returns the health the daemon notes in a listed container's status, such as "Up 2 hours (healthy)"
+func summaryHealth(status string) string {
+	for _, health := range []string{"unhealthy", "healthy", "health: starting"} {
+		if strings.HasSuffix(status, "("+health+")") {
+			return strings.TrimPrefix(health, "health: ")
+		}
+	}
+	return ""
+}
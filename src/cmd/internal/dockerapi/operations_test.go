@@ -0,0 +1,367 @@
+package dockerapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEngine is an in-process stand-in for the image and container endpoints of the Docker daemon
+type fakeEngine struct {
+	mutex      sync.Mutex
+	images     map[string]string         // image ID by reference
+	registry   map[string]string         // image ID a pull of each reference fetches
+	containers map[string]*fakeContainer // containers by ID
+	created    []map[string]json.RawMessage
+	failStart  bool // fail to start the containers that are created
+	nextId     int
+}
+
+type fakeContainer struct {
+	Id         string
+	Name       string
+	Reference  string
+	ImageId    string
+	Running    bool
+	Health     []string // health reported by successive inspections; the last one sticks
+	HostConfig json.RawMessage
+}
+
+func imageId(n byte) string {
+	return "sha256:" + strings.Repeat(string(n), 64)
+}
+
+func newFakeEngine() *fakeEngine {
+	return &fakeEngine{images: map[string]string{}, registry: map[string]string{}, containers: map[string]*fakeContainer{}}
+}
+
+func (e *fakeEngine) add(name, reference, image string, running bool) *fakeContainer {
+	e.nextId++
+	container := &fakeContainer{
+		Id:         fmt.Sprintf("%064x", e.nextId),
+		Name:       name,
+		Reference:  reference,
+		ImageId:    image,
+		Running:    running,
+		HostConfig: json.RawMessage(`{"RestartPolicy":{"Name":"unless-stopped"}}`),
+	}
+	e.containers[container.Id] = container
+	return container
+}
+
+func (e *fakeEngine) find(name string) *fakeContainer {
+	for id, container := range e.containers {
+		if id == name || container.Name == name {
+			return container
+		}
+	}
+	return nil
+}
+
+func (e *fakeEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/"+APIVersion)
+	notFound := func(what string) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"message": "No such " + what})
+	}
+
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/images/") && strings.HasSuffix(path, "/json"):
+		reference := strings.TrimSuffix(strings.TrimPrefix(path, "/images/"), "/json")
+		id, ok := e.images[reference]
+		if !ok && strings.HasPrefix(reference, "sha256:") {
+			id, ok = reference, true
+		}
+		if !ok {
+			notFound("image: " + reference)
+			return
+		}
+		repository, _ := splitReference(reference)
+		json.NewEncoder(w).Encode(Image{Id: id, RepoDigests: []string{repository + "@sha256:digest-of-" + id[7:15]}})
+
+	case r.Method == http.MethodPost && path == "/images/create":
+		reference := r.URL.Query().Get("fromImage") + ":" + r.URL.Query().Get("tag")
+		id, ok := e.registry[reference]
+		if !ok {
+			fmt.Fprintf(w, `{"status":"Pulling from %s"}`+"\n", reference)
+			fmt.Fprintf(w, `{"errorDetail":{"message":"manifest unknown"},"error":"manifest unknown"}`+"\n")
+			return
+		}
+		e.images[reference] = id
+		fmt.Fprintf(w, `{"status":"Status: Downloaded newer image for %s"}`+"\n", reference)
+
+	case r.Method == http.MethodGet && path == "/containers/json":
+		var filters map[string][]string
+		json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters)
+		var list []ContainerSummary
+		for _, container := range e.containers {
+			if len(filters["name"]) > 0 && !strings.Contains(container.Name, filters["name"][0]) {
+				continue
+			}
+			status := "Exited (0) 1 minute ago"
+			if container.Running {
+				status = "Up 2 hours (healthy)"
+			}
+			list = append(list, ContainerSummary{Id: container.Id, Names: []string{"/" + container.Name}, Image: container.Reference, ImageID: container.ImageId, State: map[bool]string{true: "running", false: "exited"}[container.Running], Status: status})
+		}
+		json.NewEncoder(w).Encode(list)
+
+	case r.Method == http.MethodPost && path == "/containers/create":
+		name := r.URL.Query().Get("name")
+		if e.find(name) != nil {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"message": "Conflict. The container name is already in use"})
+			return
+		}
+		var body map[string]json.RawMessage
+		json.NewDecoder(r.Body).Decode(&body)
+		e.created = append(e.created, body)
+		var reference string
+		json.Unmarshal(body["Image"], &reference)
+		container := e.add(name, reference, e.images[reference], false)
+		container.HostConfig = body["HostConfig"]
+		container.Health = []string{"starting", "healthy"}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"Id": container.Id})
+
+	case r.Method == http.MethodDelete && strings.HasPrefix(path, "/containers/"):
+		container := e.find(strings.TrimPrefix(path, "/containers/"))
+		if container == nil {
+			notFound("container")
+			return
+		}
+		delete(e.containers, container.Id)
+		w.WriteHeader(http.StatusNoContent)
+
+	case strings.HasPrefix(path, "/containers/"):
+		parts := strings.Split(strings.TrimPrefix(path, "/containers/"), "/")
+		container := e.find(parts[0])
+		if container == nil || len(parts) != 2 {
+			notFound("container: " + parts[0])
+			return
+		}
+		switch parts[1] {
+		case "json":
+			state := map[string]any{"Status": map[bool]string{true: "running", false: "exited"}[container.Running], "Running": container.Running}
+			if len(container.Health) > 0 {
+				state["Health"] = map[string]string{"Status": container.Health[0]}
+				if len(container.Health) > 1 {
+					container.Health = container.Health[1:]
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]any{
+				"Id":         container.Id,
+				"Name":       "/" + container.Name,
+				"Image":      container.ImageId,
+				"State":      state,
+				"Config":     map[string]any{"Image": container.Reference, "Hostname": container.Id[:12], "Env": []string{"MODE=production"}},
+				"HostConfig": container.HostConfig,
+				"NetworkSettings": map[string]any{"Networks": map[string]any{
+					"app": map[string]any{"IPAMConfig": nil, "Aliases": []string{container.Name, container.Id[:12]}, "IPAddress": "172.18.0.2"},
+				}},
+			})
+		case "start":
+			if e.failStart && len(e.created) > 0 && container.Id == fmt.Sprintf("%064x", e.nextId) {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"message": "port is already allocated"})
+				return
+			}
+			if container.Running {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			container.Running = true
+			w.WriteHeader(http.StatusNoContent)
+		case "stop":
+			container.Running = false
+			w.WriteHeader(http.StatusNoContent)
+		case "rename":
+			container.Name = r.URL.Query().Get("name")
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			notFound("endpoint")
+		}
+
+	default:
+		notFound("endpoint")
+	}
+}
+
+func startFakeEngine(t *testing.T, engine *fakeEngine) *Client {
+	t.Helper()
+	healthPollInterval = 10 * time.Millisecond
+	return NewClient(UnixSocket(serveUnix(t, engine)))
+}
+
+func TestPull(t *testing.T) {
+	engine := newFakeEngine()
+	engine.images["nginx:1.27"] = imageId('a')
+	engine.registry["nginx:1.27"] = imageId('b')
+	client := startFakeEngine(t, engine)
+
+	report, err := client.Pull(context.Background(), "nginx:1.27")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	image := report.Image
+	if !image.Updated || image.IdBefore != imageId('a') || image.IdAfter != imageId('b') {
+		t.Fatalf("want the image updated from a to b, got %+v", image)
+	}
+	if image.DigestBefore != "sha256:digest-of-aaaaaaaa" || image.DigestAfter != "sha256:digest-of-bbbbbbbb" {
+		t.Fatalf("want the digests before and after, got %+v", image)
+	}
+
+	// Pulling again changes nothing
+
+	report, err = client.Pull(context.Background(), "nginx:1.27")
+	if err != nil || report.Image.Updated {
+		t.Fatalf("want the image unchanged, got %+v (err %v)", report.Image, err)
+	}
+}
+
+func TestPull_Failure(t *testing.T) {
+	client := startFakeEngine(t, newFakeEngine())
+
+	_, err := client.Pull(context.Background(), "nginx:missing")
+	if err == nil || !strings.Contains(err.Error(), "manifest unknown") {
+		t.Fatalf("want the error reported in the progress stream, got %v", err)
+	}
+
+	if _, err := client.Pull(context.Background(), "nginx:1.27?x=1"); err == nil {
+		t.Fatalf("want an invalid reference refused")
+	}
+}
+
+func TestRecreate(t *testing.T) {
+	engine := newFakeEngine()
+	engine.images["app:v1"] = imageId('a')
+	engine.registry["app:v1"] = imageId('b')
+	old := engine.add("web", "app:v1", imageId('a'), true)
+	client := startFakeEngine(t, engine)
+
+	report, err := client.Recreate(context.Background(), "web")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !report.Image.Updated || report.Image.IdBefore != imageId('a') || report.Image.IdAfter != imageId('b') {
+		t.Fatalf("want the image updated, got %+v", report.Image)
+	}
+	if len(report.Containers) != 1 {
+		t.Fatalf("want the new container reported, got %+v", report.Containers)
+	}
+	container := report.Containers[0]
+	if container.Name != "web" || container.PreviousId != old.Id || container.Id == old.Id || container.ImageId != imageId('b') {
+		t.Fatalf("unexpected container: %+v", container)
+	}
+	if container.State != "running" || container.Health != "healthy" {
+		t.Fatalf("want the new container running and healthy, got %+v", container)
+	}
+	if len(engine.containers) != 1 || engine.containers[old.Id] != nil {
+		t.Fatalf("want the previous container removed, got %d containers", len(engine.containers))
+	}
+
+	// The new container is created from the old one's configuration
+
+	body := engine.created[0]
+	if _, ok := body["Hostname"]; ok {
+		t.Fatalf("want the derived hostname dropped")
+	}
+	if string(body["Env"]) != `["MODE=production"]` || !strings.Contains(string(body["HostConfig"]), "unless-stopped") {
+		t.Fatalf("want the configuration carried over, got %s", body)
+	}
+	if networking := string(body["NetworkingConfig"]); !strings.Contains(networking, `"Aliases":["web"]`) || strings.Contains(networking, "IPAddress") {
+		t.Fatalf("want only the chosen endpoint settings, got %s", networking)
+	}
+}
+
+func TestRecreate_Unchanged(t *testing.T) {
+	engine := newFakeEngine()
+	engine.images["app:v1"] = imageId('a')
+	engine.registry["app:v1"] = imageId('a')
+	old := engine.add("web", "app:v1", imageId('a'), true)
+	client := startFakeEngine(t, engine)
+
+	report, err := client.Recreate(context.Background(), "web")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Image.Updated || len(report.Containers) != 1 {
+		t.Fatalf("want the image unchanged and the container reported, got %+v", report)
+	}
+	if container := report.Containers[0]; container.Id != old.Id || container.PreviousId != "" || container.State != "running" {
+		t.Fatalf("want the container left as it is, got %+v", container)
+	}
+	if len(engine.created) != 0 || len(engine.containers) != 1 {
+		t.Fatalf("want no container created, got %d created", len(engine.created))
+	}
+}
+
+func TestRecreate_RestoresOnFailure(t *testing.T) {
+	engine := newFakeEngine()
+	engine.images["app:v1"] = imageId('a')
+	engine.registry["app:v1"] = imageId('b')
+	engine.failStart = true
+	old := engine.add("web", "app:v1", imageId('a'), true)
+	client := startFakeEngine(t, engine)
+
+	_, err := client.Recreate(context.Background(), "web")
+	if err == nil || !strings.Contains(err.Error(), "port is already allocated") || !strings.Contains(err.Error(), "previous container was restored") {
+		t.Fatalf("want the failure reported with the restoration, got %v", err)
+	}
+	if len(engine.containers) != 1 || old.Name != "web" || !old.Running {
+		t.Fatalf("want only the previous container, named web and running, got %d containers, %+v", len(engine.containers), old)
+	}
+}
+
+func TestInspect_NotFound(t *testing.T) {
+	client := startFakeEngine(t, newFakeEngine())
+
+	if _, err := client.Inspect(context.Background(), "db"); !NotFound(err) {
+		t.Fatalf("want not found, got %v", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	engine := newFakeEngine()
+	engine.add("web-1", "app:v1", imageId('a'), true)
+	engine.add("worker", "app:v1", imageId('a'), false)
+	client := startFakeEngine(t, engine)
+
+	report, err := client.List(context.Background(), "web")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(report.Containers) != 1 {
+		t.Fatalf("want the containers matching the name, got %+v", report.Containers)
+	}
+	container := report.Containers[0]
+	if container.Name != "web-1" || container.State != "running" || container.Health != "healthy" || container.ImageDigest != "sha256:digest-of-aaaaaaaa" {
+		t.Fatalf("unexpected container: %+v", container)
+	}
+}
+
+func TestSplitReference(t *testing.T) {
+	tests := []struct{ reference, repository, tag string }{
+		{"nginx", "nginx", "latest"},
+		{"nginx:1.27", "nginx", "1.27"},
+		{"registry:5000/team/app", "registry:5000/team/app", "latest"},
+		{"registry:5000/team/app:v2", "registry:5000/team/app", "v2"},
+		{"app@sha256:" + strings.Repeat("0", 64), "app", "sha256:" + strings.Repeat("0", 64)},
+	}
+	for _, tt := range tests {
+		repository, tag := splitReference(tt.reference)
+		if repository != tt.repository || tag != tt.tag {
+			t.Fatalf("%s: want %s and %s, got %s and %s", tt.reference, tt.repository, tt.tag, repository, tag)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+package executor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/NobleFactor/docker-webhook/cmd/internal/dockerapi"
+	"github.com/NobleFactor/docker-webhook/cmd/internal/sshremote"
+)
+
+// DefaultDockerSocket is where the Docker daemon listens unless configured otherwise
+const DefaultDockerSocket = "/var/run/docker.sock"
+
+// Docker runs commands with /bin/sh in a container through the Docker Engine API. The API cannot signal an exec'd
+// process, so a command that outlives its timeout is abandoned, not killed.
+type Docker struct {
+	name string
+
+	Container string
+	Client    *dockerapi.Client
+}
+
+func (e *Docker) Name() string {
+	return e.name
+}
+
+func (e *Docker) Execute(command string, options sshremote.ExecuteOptions) sshremote.Response {
+
+	if response := unsupported(options); response != nil {
+		return *response
+	}
+
+	capture := sshremote.NewCapture(options)
+
+	execOptions := dockerapi.ExecOptions{
+		Cmd:    []string{"/bin/sh", "-c", command},
+		Env:    dockerEnvironment(options.Environment),
+		Tty:    options.Pty != nil,
+		Stdout: capture.Stdout,
+		Stderr: capture.Stderr,
+	}
+	if options.Stdin != nil {
+		execOptions.Stdin = bytes.NewReader(options.Stdin)
+	}
+
+	// Stop waiting when the timeout expires or the caller cancels, remembering which came first
+
+	stop := make(chan struct{})
+	finished := make(chan struct{})
+	defer close(finished)
+
+	stopped := make(chan sshremote.StopCause, 1)
+	go func() {
+		var expired <-chan time.Time
+		if options.Timeout > 0 {
+			timer := time.NewTimer(options.Timeout)
+			defer timer.Stop()
+			expired = timer.C
+		}
+		select {
+		case <-expired:
+			stopped <- sshremote.StoppedByTimeout
+			close(stop)
+		case <-options.Cancel:
+			stopped <- sshremote.StoppedByCancel
+			close(stop)
+		case <-finished:
+		}
+	}()
+
+	exitCode, err := e.Client.Exec(e.Container, execOptions, stop)
+
+	response := sshremote.Response{Attempts: 1, StderrMerged: options.Pty != nil}
+	capture.Fill(&response)
+
+	switch {
+	case errors.Is(err, dockerapi.ErrStopped) && <-stopped == sshremote.StoppedByTimeout:
+		errorMsg := fmt.Sprintf("command timed out after %s and may still be running in %s", options.Timeout, e.Container)
+		response.Error, response.Status, response.Reason, response.TimedOut = &errorMsg, sshremote.TimedOutStatus, "Timed Out", true
+	case errors.Is(err, dockerapi.ErrStopped):
+		errorMsg := fmt.Sprintf("command cancelled and may still be running in %s", e.Container)
+		response.Error, response.Status, response.Reason = &errorMsg, -1, "Cancelled"
+	case dockerapi.NotFound(err):
+		errorMsg := err.Error()
+		response.Error, response.Status, response.Reason = &errorMsg, -1, "Container Not Found"
+	case err != nil:
+		errorMsg := err.Error()
+		response.Error, response.Status, response.Reason = &errorMsg, -1, "Docker Error"
+	default:
+		response.Status = exitCode
+		response.Reason = options.ExitReasons.Reason(exitCode)
+	}
+
+	return response
+}
+
+// dockerEnvironment returns environment as the sorted NAME=value list the Engine API expects
+func dockerEnvironment(environment map[string]string) []string {
+
+	env := make([]string, 0, len(environment))
+	for name, value := range environment {
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+
+	return env
+}
@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+package executor
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/NobleFactor/docker-webhook/cmd/internal/dockerapi"
+	"github.com/NobleFactor/docker-webhook/cmd/internal/sshremote"
+)
+
+// ReasonDryRun is the reason given by a dry run that found nothing wrong
+const ReasonDryRun = "Dry Run"
+
+// DryRun returns an executor that reports what destination would run, and the policy rule that allowed it, instead
+// of running it. When handshake is set it also reaches the destination: an SSH destination is connected to, its host
+// key verified and the client authenticated; a docker container is inspected; a local directory is checked.
+func DryRun(destination Executor, rule string, handshake bool) Executor {
+	return &dryRunExecutor{target: destination, rule: rule, handshake: handshake}
+}
+
+type dryRunExecutor struct {
+	target    Executor
+	rule      string
+	handshake bool
+}
+
+func (e *dryRunExecutor) Name() string {
+	return e.target.Name()
+}
+
+// Execute reports what would run. Its status is zero unless the handshake fails, in which case the response is the
+// one running the command would have returned.
+func (e *dryRunExecutor) Execute(command string, options sshremote.ExecuteOptions) sshremote.Response {
+
+	plan := &sshremote.DryRun{Command: command, PolicyRule: e.rule}
+
+	target := e.target
+	if operation, ok := target.(*operationExecutor); ok {
+		target = operation.ssh
+	}
+
+	response := sshremote.Response{Status: 0, Reason: ReasonDryRun, Attempts: 1, DryRun: plan}
+
+	switch target := target.(type) {
+	case *SSH:
+		plan.Backend = "ssh"
+		plan.Address = target.Destination.Address
+		plan.User = target.Destination.ClientConfig.User
+		plan.Identities = target.Destination.Identities()
+		for _, jump := range target.Destination.Jumps {
+			plan.Jumps = append(plan.Jumps, jump.Address)
+		}
+		if e.handshake {
+			tunnel, failure := sshremote.OpenTunnel(target.Destination, options.Retry, options.Cancel)
+			if failure != nil {
+				failure.DryRun = plan
+				return *failure
+			}
+			tunnel.Fill(&response)
+			tunnel.Close()
+		}
+
+	case *Local:
+		plan.Backend = "local"
+		plan.Directory = target.Directory
+		if e.handshake {
+			if info, err := os.Stat(target.Directory); err != nil || !info.IsDir() {
+				errorMsg := fmt.Sprintf("local directory %s does not exist", target.Directory)
+				response.Error, response.Status, response.Reason = &errorMsg, -1, "Executor Error"
+				return response
+			}
+		}
+
+	case *Docker:
+		plan.Backend = "docker"
+		plan.Container = target.Container
+		if e.handshake {
+			ctx, cancel := context.Background(), context.CancelFunc(func() {})
+			if options.Timeout > 0 {
+				ctx, cancel = context.WithTimeout(ctx, options.Timeout)
+			}
+			_, err := target.Client.ContainerInspect(ctx, target.Container)
+			cancel()
+			if err != nil {
+				errorMsg := err.Error()
+				response.Error, response.Status, response.Reason = &errorMsg, -1, "Docker Error"
+				if dockerapi.NotFound(err) {
+					response.Reason = "Container Not Found"
+				}
+				return response
+			}
+		}
+	}
+
+	plan.Handshake = e.handshake
+	return response
+}
@@ -0,0 +1,65 @@
+package executor
+
+import (
+	"net"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/NobleFactor/docker-webhook/cmd/internal/sshremote"
+)
+
+func TestDryRun_SSH(t *testing.T) {
+	config := Config{ConfigDirectory: t.TempDir()}
+	writeSSHDirectory(t, config.ConfigDirectory)
+
+	// Take a port nothing listens on so that a handshake is refused
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	target, err := New("ssh://deploy@"+address, config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	response := DryRun(target, "deployers", false).Execute("uptime", sshremote.ExecuteOptions{})
+	if response.Status != 0 || response.Reason != ReasonDryRun || response.DryRun == nil {
+		t.Fatalf("unexpected response %+v", response)
+	}
+	identity := filepath.Join(config.ConfigDirectory, "ssh", "id_ed25519")
+	plan := response.DryRun
+	if plan.Backend != "ssh" || plan.Address != address || plan.User != "deploy" || plan.Command != "uptime" || plan.PolicyRule != "deployers" || plan.Handshake {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	if !slices.Equal(plan.Identities, []string{identity}) {
+		t.Fatalf("unexpected identities %v", plan.Identities)
+	}
+
+	response = DryRun(target, "deployers", true).Execute("uptime", sshremote.ExecuteOptions{Retry: sshremote.RetryPolicy{Attempts: 1}})
+	if response.Status != -1 || response.Reason != "Connection Refused" || response.DryRun == nil || response.DryRun.Handshake {
+		t.Fatalf("unexpected response %+v", response)
+	}
+}
+
+func TestDryRun_Local(t *testing.T) {
+	directory := t.TempDir()
+
+	response := DryRun(&Local{name: "local://" + directory, Directory: directory}, "", true).Execute("make", sshremote.ExecuteOptions{})
+	if response.Status != 0 || response.Reason != ReasonDryRun {
+		t.Fatalf("unexpected response %+v", response)
+	}
+	if plan := response.DryRun; plan.Backend != "local" || plan.Directory != directory || !plan.Handshake {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+
+	missing := filepath.Join(directory, "missing")
+	response = DryRun(&Local{name: "local://" + missing, Directory: missing}, "", true).Execute("make", sshremote.ExecuteOptions{})
+	if response.Status != -1 || response.Reason != "Executor Error" {
+		t.Fatalf("unexpected response %+v", response)
+	}
+}
@@ -0,0 +1,110 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+
+// Package executor runs commands on the backend named by a destination's scheme
+package executor
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/NobleFactor/docker-webhook/cmd/internal/dockerapi"
+	"github.com/NobleFactor/docker-webhook/cmd/internal/sshremote"
+)
+
+// Executor runs commands on one destination and reports each outcome as a sshremote.Response
+type Executor interface {
+
+	// Name returns the destination as it was requested
+	Name() string
+
+	// Execute runs command under options. Options a backend cannot honour are reported in the response.
+	Execute(command string, options sshremote.ExecuteOptions) sshremote.Response
+}
+
+// Destination schemes
+const (
+	SchemeSsh    = "ssh://"
+	SchemeLocal  = "local://"
+	SchemeDocker = "docker://"
+)
+
+// Config holds what the backends need to resolve a destination
+type Config struct {
+
+	// ConfigDirectory is $WEBHOOK_CONFIG, where SSH destinations are resolved
+	ConfigDirectory string
+
+	// Passphrase decrypts encrypted SSH identities
+	Passphrase sshremote.PassphraseFunc
+
+	// DockerSocket is the path of the Docker daemon's Unix socket
+	DockerSocket string
+
+	// LocalPath is the PATH given to local commands
+	LocalPath string
+}
+
+// New returns the executor for destination:
+//
+//   - local:// or local:///working/directory runs the command in this container
+//   - docker://container runs the command in a container through the Docker Engine API
+//   - anything else, with or without ssh://, is an SSH destination
+func New(destination string, config Config) (Executor, error) {
+
+	destination = strings.TrimSpace(destination)
+
+	if directory, ok := strings.CutPrefix(destination, SchemeLocal); ok {
+		if directory == "" {
+			directory = "/"
+		}
+		if !path.IsAbs(directory) || strings.ContainsAny(directory, "?#") {
+			return nil, fmt.Errorf("invalid local destination %s: want local:// or local:///absolute/directory", destination)
+		}
+		return &Local{name: destination, Directory: path.Clean(directory), Path: config.LocalPath}, nil
+	}
+
+	if container, ok := strings.CutPrefix(destination, SchemeDocker); ok {
+		if !dockerapi.ValidContainerName(container) {
+			return nil, fmt.Errorf("invalid docker destination %s: want docker://container", destination)
+		}
+		client := dockerapi.NewClient(dockerapi.UnixSocket(config.DockerSocket))
+		return &Docker{name: destination, Container: container, Client: client}, nil
+	}
+
+	sshDestination, err := sshremote.ParseSshDestination(destination, config.ConfigDirectory, config.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return &SSH{Destination: sshDestination}, nil
+}
+
+// IsSsh reports whether New would return an SSH executor for destination
+func IsSsh(destination string) bool {
+	destination = strings.TrimSpace(destination)
+	return !strings.HasPrefix(destination, SchemeLocal) && !strings.HasPrefix(destination, SchemeDocker)
+}
+
+// SSH runs commands over SSH
+type SSH struct {
+	Destination *sshremote.Destination
+}
+
+func (e *SSH) Name() string {
+	return e.Destination.Name
+}
+
+func (e *SSH) Execute(command string, options sshremote.ExecuteOptions) sshremote.Response {
+	return sshremote.ExecuteRemoteCommand(e.Destination, command, options)
+}
+
+// unsupported returns the response of a backend that cannot honour options, or nil when it can. Only SSH transfers
+// files.
+func unsupported(options sshremote.ExecuteOptions) *sshremote.Response {
+	if len(options.Transfers) == 0 {
+		return nil
+	}
+	errorMsg := "file transfers need an ssh:// destination"
+	return &sshremote.Response{Error: &errorMsg, Status: -1, Reason: "Executor Error", Attempts: 1}
+}
@@ -0,0 +1,109 @@
+package executor
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestNew(t *testing.T) {
+	config := Config{ConfigDirectory: t.TempDir(), DockerSocket: "/var/run/docker.sock", LocalPath: "/bin"}
+
+	writeSSHDirectory(t, config.ConfigDirectory)
+
+	tests := []struct {
+		destination string
+		check       func(Executor) bool
+	}{
+		{"local://", func(e Executor) bool { l, ok := e.(*Local); return ok && l.Directory == "/" && l.Path == "/bin" }},
+		{"local:///srv/app/", func(e Executor) bool { l, ok := e.(*Local); return ok && l.Directory == "/srv/app" }},
+		{"docker://web_1", func(e Executor) bool { d, ok := e.(*Docker); return ok && d.Container == "web_1" }},
+		{"deploy@example.com", func(e Executor) bool { s, ok := e.(*SSH); return ok && s.Destination.Address == "example.com:22" }},
+		{"ssh://deploy@example.com:2222", func(e Executor) bool { s, ok := e.(*SSH); return ok && s.Destination.Address == "example.com:2222" }},
+	}
+
+	for _, tt := range tests {
+		e, err := New(tt.destination, config)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.destination, err)
+		}
+		if !tt.check(e) || e.Name() != tt.destination {
+			t.Fatalf("%s: unexpected executor %#v", tt.destination, e)
+		}
+		if _, isSsh := e.(*SSH); IsSsh(tt.destination) != isSsh {
+			t.Fatalf("%s: IsSsh returned %v", tt.destination, !isSsh)
+		}
+	}
+
+	for _, destination := range []string{"local://relative", "local:///srv?x=1", "docker://", "docker://web/exec", "docker://-web"} {
+		if _, err := New(destination, config); err == nil {
+			t.Fatalf("want error for %s", destination)
+		}
+	}
+}
+
+// writeSSHDirectory writes the identity and empty known_hosts SSH destinations need under configDirectory
+func writeSSHDirectory(t *testing.T, configDirectory string) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(configDirectory, "ssh"), 0o700); err != nil {
+		t.Fatalf("failed to create ssh directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(configDirectory, "ssh", "id_ed25519"), pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(configDirectory, "ssh", "known_hosts"), nil, 0o600); err != nil {
+		t.Fatalf("failed to write known_hosts: %v", err)
+	}
+}
+
+func TestParseOperation(t *testing.T) {
+	valid := []Operation{
+		{OperationPull, "ghcr.io/org/app:v1.2"},
+		{OperationRecreate, "web"},
+		{OperationInspect, "web_1"},
+		{OperationList, ""},
+		{OperationList, "web"},
+	}
+	for _, want := range valid {
+		operation, err := ParseOperation(want.Name, want.Target)
+		if err != nil || operation != want {
+			t.Fatalf("%s %s: unexpected result %+v (err %v)", want.Name, want.Target, operation, err)
+		}
+	}
+
+	invalid := []Operation{
+		{"exec", "web"},
+		{OperationPull, ""},
+		{OperationPull, "app:v1; reboot"},
+		{OperationRecreate, ""},
+		{OperationInspect, "../web"},
+	}
+	for _, operation := range invalid {
+		if _, err := ParseOperation(operation.Name, operation.Target); err == nil {
+			t.Fatalf("%s %q: want an error", operation.Name, operation.Target)
+		}
+	}
+
+	if command := (Operation{OperationPull, "nginx:1.27"}).Command(); command != "docker pull nginx:1.27" {
+		t.Fatalf("unexpected command %q", command)
+	}
+}
+
+func TestWithOperation_NeedsSSH(t *testing.T) {
+	local := &Local{name: "local://", Directory: "/"}
+	if _, err := WithOperation(local, Operation{OperationList, ""}, DefaultRemoteDockerSocket); err == nil {
+		t.Fatalf("want docker operations refused on local://")
+	}
+}
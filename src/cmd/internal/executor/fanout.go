@@ -0,0 +1,157 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+package executor
+
+import (
+	"sync"
+
+	"github.com/NobleFactor/docker-webhook/cmd/internal/sshremote"
+)
+
+// FanOutOptions controls how ExecuteOnAll runs a command on several destinations
+type FanOutOptions struct {
+
+	// Parallelism is the largest number of destinations the command runs on at once. Zero means all of them.
+	Parallelism int
+
+	// FailFast stops the fan-out at the first failure: commands still running are cancelled and destinations not yet
+	// started are skipped. Otherwise every destination runs regardless of the others.
+	FailFast bool
+
+	// Output, when set, returns the function that receives a destination's output as it arrives
+	Output func(destination string) sshremote.OutputFunc
+
+	// Started and Finished, when set, are called as the command starts and finishes on each destination. They may be
+	// called from several goroutines at once.
+	Started  func(destination string)
+	Finished func(response sshremote.Response)
+}
+
+// AggregateResponse is the result of running a command on several destinations. Responses holds one Response per
+// destination in the order the destinations were given.
+type AggregateResponse struct {
+	Status         int                  `json:"status"`
+	Reason         string               `json:"reason"`
+	Total          int                  `json:"total"`
+	Succeeded      int                  `json:"succeeded"`
+	Failed         int                  `json:"failed"`
+	Cancelled      int                  `json:"cancelled"`
+	Skipped        int                  `json:"skipped"`
+	Responses      []sshremote.Response `json:"responses"`
+	AuthToken      *string              `json:"authToken,omitempty"`
+	Reauthenticate bool                 `json:"reauthenticate,omitempty"`
+	CorrelationId  string               `json:"correlationId"`
+}
+
+// ExecuteOnAll runs command on every destination concurrently, at most fanOut.Parallelism at a time, and aggregates the
+// responses. The aggregate status is 0 only when the command succeeded everywhere.
+func ExecuteOnAll(destinations []Executor, command string, options sshremote.ExecuteOptions, fanOut FanOutOptions) AggregateResponse {
+
+	parallelism := fanOut.Parallelism
+	if parallelism <= 0 || parallelism > len(destinations) {
+		parallelism = len(destinations)
+	}
+
+	responses := make([]sshremote.Response, len(destinations))
+	slots := make(chan struct{}, parallelism)
+	cancel := make(chan struct{})
+	var cancelOnce sync.Once
+	var group sync.WaitGroup
+
+	for i, destination := range destinations {
+
+		// Wait for a free slot, giving up if an earlier destination failed under fail-fast
+
+		acquired := false
+		select {
+		case slots <- struct{}{}:
+			acquired = true
+		case <-cancel:
+		}
+
+		if cancelled(cancel) {
+			if acquired {
+				<-slots
+			}
+			responses[i] = skippedResponse(destination)
+			if fanOut.Finished != nil {
+				fanOut.Finished(responses[i])
+			}
+			continue
+		}
+
+		group.Add(1)
+		go func(i int, destination Executor) {
+			defer group.Done()
+			defer func() { <-slots }()
+
+			destinationOptions := options
+			destinationOptions.Cancel = cancel
+			if fanOut.Output != nil {
+				destinationOptions.Output = fanOut.Output(destination.Name())
+			}
+			if fanOut.Started != nil {
+				fanOut.Started(destination.Name())
+			}
+
+			response := destination.Execute(command, destinationOptions)
+			response.Destination = destination.Name()
+			responses[i] = response
+
+			if fanOut.Finished != nil {
+				fanOut.Finished(response)
+			}
+			if response.Status != 0 && fanOut.FailFast {
+				cancelOnce.Do(func() { close(cancel) })
+			}
+		}(i, destination)
+	}
+
+	group.Wait()
+
+	return aggregate(responses)
+}
+
+// aggregate counts the outcomes of responses and derives the overall status and reason
+func aggregate(responses []sshremote.Response) AggregateResponse {
+
+	result := AggregateResponse{Total: len(responses), Responses: responses}
+
+	for _, response := range responses {
+		switch {
+		case response.Status == 0:
+			result.Succeeded++
+		case response.Reason == "Cancelled":
+			result.Cancelled++
+		case response.Reason == "Skipped":
+			result.Skipped++
+		default:
+			result.Failed++
+		}
+	}
+
+	switch result.Succeeded {
+	case result.Total:
+		result.Status, result.Reason = 0, "OK"
+	case 0:
+		result.Status, result.Reason = 1, "Failed"
+	default:
+		result.Status, result.Reason = 1, "Partial Failure"
+	}
+
+	return result
+}
+
+func skippedResponse(destination Executor) sshremote.Response {
+	errorMsg := "skipped after an earlier destination failed"
+	return sshremote.Response{Error: &errorMsg, Status: -1, Reason: "Skipped", Destination: destination.Name()}
+}
+
+func cancelled(cancel <-chan struct{}) bool {
+	select {
+	case <-cancel:
+		return true
+	default:
+		return false
+	}
+}
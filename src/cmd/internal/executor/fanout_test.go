@@ -0,0 +1,112 @@
+package executor
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NobleFactor/docker-webhook/cmd/internal/sshremote"
+)
+
+// fakeExecutor runs a function in place of a command
+type fakeExecutor struct {
+	name string
+	run  func(options sshremote.ExecuteOptions) sshremote.Response
+}
+
+func (e *fakeExecutor) Name() string {
+	return e.name
+}
+
+func (e *fakeExecutor) Execute(command string, options sshremote.ExecuteOptions) sshremote.Response {
+	return e.run(options)
+}
+
+func TestExecuteOnAll_ContinueOnError(t *testing.T) {
+	var running, peak int32
+	succeed := func(options sshremote.ExecuteOptions) sshremote.Response {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		return sshremote.Response{Status: 0, Reason: "OK"}
+	}
+	refuse := func(options sshremote.ExecuteOptions) sshremote.Response {
+		return sshremote.Response{Status: -1, Reason: "Connection Refused"}
+	}
+
+	destinations := []Executor{
+		&fakeExecutor{name: "a", run: succeed},
+		&fakeExecutor{name: "b", run: succeed},
+		&fakeExecutor{name: "c", run: refuse},
+		&fakeExecutor{name: "d", run: succeed},
+	}
+
+	var mutex sync.Mutex
+	finished := map[string]bool{}
+	fanOut := FanOutOptions{Parallelism: 2, Finished: func(response sshremote.Response) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		finished[response.Destination] = true
+	}}
+
+	result := ExecuteOnAll(destinations, "uptime", sshremote.ExecuteOptions{}, fanOut)
+
+	if result.Status != 1 || result.Reason != "Partial Failure" {
+		t.Fatalf("want partial failure, got status %d reason %q", result.Status, result.Reason)
+	}
+	if result.Total != 4 || result.Succeeded != 3 || result.Failed != 1 || result.Cancelled != 0 || result.Skipped != 0 {
+		t.Fatalf("unexpected counts: %+v", result)
+	}
+	for i, name := range []string{"a", "b", "c", "d"} {
+		if result.Responses[i].Destination != name {
+			t.Fatalf("want responses in destination order, got %q at %d", result.Responses[i].Destination, i)
+		}
+		if !finished[name] {
+			t.Fatalf("want Finished called for %s", name)
+		}
+	}
+	if result.Responses[2].Reason != "Connection Refused" {
+		t.Fatalf("want the unreachable destination refused, got %q", result.Responses[2].Reason)
+	}
+	if peak > 2 {
+		t.Fatalf("want at most 2 commands at once, got %d", peak)
+	}
+}
+
+func TestExecuteOnAll_FailFast(t *testing.T) {
+	waitForCancel := func(options sshremote.ExecuteOptions) sshremote.Response {
+		<-options.Cancel
+		return sshremote.Response{Status: -1, Reason: "Cancelled"}
+	}
+	fail := func(options sshremote.ExecuteOptions) sshremote.Response {
+		return sshremote.Response{Status: 1, Reason: "General Error"}
+	}
+
+	// The first destination runs until cancelled, the second fails, and the third waits for a free slot
+
+	destinations := []Executor{
+		&fakeExecutor{name: "a", run: waitForCancel},
+		&fakeExecutor{name: "b", run: fail},
+		&fakeExecutor{name: "c", run: waitForCancel},
+	}
+
+	result := ExecuteOnAll(destinations, "deploy", sshremote.ExecuteOptions{}, FanOutOptions{Parallelism: 2, FailFast: true})
+
+	if result.Status != 1 || result.Reason != "Failed" {
+		t.Fatalf("want failure, got status %d reason %q", result.Status, result.Reason)
+	}
+	if result.Succeeded != 0 || result.Failed != 1 || result.Cancelled != 1 || result.Skipped != 1 {
+		t.Fatalf("unexpected counts: %+v", result)
+	}
+	reasons := []string{result.Responses[0].Reason, result.Responses[1].Reason, result.Responses[2].Reason}
+	if reasons[0] != "Cancelled" || reasons[1] != "General Error" || reasons[2] != "Skipped" {
+		t.Fatalf("want Cancelled, General Error and Skipped, got %q", reasons)
+	}
+}
@@ -0,0 +1,191 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+package executor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/NobleFactor/docker-webhook/cmd/internal/sshremote"
+)
+
+// DefaultLocalPath is the PATH given to local commands unless configured otherwise
+const DefaultLocalPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// Local runs commands with /bin/sh in this container. Commands do not inherit the executor's environment, which holds
+// its credentials: they see only PATH, HOME and the variables in ExecuteOptions.Environment.
+type Local struct {
+	name string
+
+	// Directory is the working directory of the command and its HOME
+	Directory string
+
+	// Path is the command's PATH. Empty means DefaultLocalPath.
+	Path string
+}
+
+func (e *Local) Name() string {
+	return e.name
+}
+
+func (e *Local) Execute(command string, options sshremote.ExecuteOptions) sshremote.Response {
+
+	if response := unsupported(options); response != nil {
+		return *response
+	}
+	if options.Pty != nil {
+		errorMsg := "a pseudo-terminal needs an ssh:// or docker:// destination"
+		return sshremote.Response{Error: &errorMsg, Status: -1, Reason: "Executor Error", Attempts: 1}
+	}
+
+	searchPath := e.Path
+	if searchPath == "" {
+		searchPath = DefaultLocalPath
+	}
+
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Dir = e.Directory
+	cmd.Env = localEnvironment(searchPath, e.Directory, options.Environment)
+
+	// Run the command in its own process group so that a timeout stops everything it started
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	capture := sshremote.NewCapture(options)
+	cmd.Stdout = capture.Stdout
+	cmd.Stderr = capture.Stderr
+	if options.Stdin != nil {
+		cmd.Stdin = bytes.NewReader(options.Stdin)
+	}
+
+	// A process that leaves the group, such as one started with setsid or nohup, can hold the output pipes open after
+	// the command has exited. Stop waiting for them as long after the exit as a signal is given to take effect.
+
+	cmd.WaitDelay = killAfter(options)
+
+	response := sshremote.Response{Attempts: 1}
+
+	if err := cmd.Start(); err != nil {
+		errorMsg := "Failed to start command: " + err.Error()
+		response.Error, response.Status, response.Reason = &errorMsg, -1, "Executor Error"
+		capture.Fill(&response)
+		return response
+	}
+
+	stopped, err := waitLocal(cmd, options)
+	capture.Fill(&response)
+
+	switch {
+	case stopped == sshremote.StoppedByTimeout:
+		errorMsg := fmt.Sprintf("command timed out after %s", options.Timeout)
+		response.Error, response.Status, response.Reason, response.TimedOut = &errorMsg, sshremote.TimedOutStatus, "Timed Out", true
+	case stopped == sshremote.StoppedByCancel:
+		errorMsg := "command cancelled"
+		response.Error, response.Status, response.Reason = &errorMsg, -1, "Cancelled"
+	case err == nil || errors.Is(err, exec.ErrWaitDelay):
+		response.Status, response.Reason = 0, "OK"
+	default:
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			errorMsg := err.Error()
+			response.Error, response.Status, response.Reason = &errorMsg, -1, "Executor Error"
+			break
+		}
+		response.Status = exitErr.ExitCode()
+		response.Reason = options.ExitReasons.Reason(response.Status)
+	}
+
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		response.Signal = signalName(status.Signal())
+		if stopped == sshremote.NotStopped {
+			response.Status = 128 + int(status.Signal())
+			response.Reason = "Terminated by Signal"
+		}
+	}
+
+	return response
+}
+
+// localEnvironment returns the environment of a local command
+func localEnvironment(searchPath, home string, environment map[string]string) []string {
+
+	env := []string{"PATH=" + searchPath, "HOME=" + home}
+
+	names := make([]string, 0, len(environment))
+	for name := range environment {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		env = append(env, name+"="+environment[name])
+	}
+
+	return env
+}
+
+// waitLocal waits for cmd to finish. If options.Timeout expires or options.Cancel is closed first, the command's process
+// group is sent SIGTERM and then SIGKILL, each after options.KillAfter, as a remote command would be.
+func waitLocal(cmd *exec.Cmd, options sshremote.ExecuteOptions) (sshremote.StopCause, error) {
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var expired <-chan time.Time
+	if options.Timeout > 0 {
+		timer := time.NewTimer(options.Timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	var stopped sshremote.StopCause
+
+	select {
+	case err := <-done:
+		return sshremote.NotStopped, err
+	case <-expired:
+		stopped = sshremote.StoppedByTimeout
+	case <-options.Cancel:
+		stopped = sshremote.StoppedByCancel
+	}
+
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	select {
+	case err := <-done:
+		return stopped, err
+	case <-time.After(killAfter(options)):
+	}
+
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	return stopped, <-done
+}
+
+// killAfter returns how long to wait after each signal before escalating
+func killAfter(options sshremote.ExecuteOptions) time.Duration {
+	if options.KillAfter <= 0 {
+		return 5 * time.Second
+	}
+	return options.KillAfter
+}
+
+// signalNames maps signals to the names SSH uses for them (RFC 4254 section 6.10)
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGABRT: "ABRT", syscall.SIGALRM: "ALRM", syscall.SIGFPE: "FPE", syscall.SIGHUP: "HUP",
+	syscall.SIGILL: "ILL", syscall.SIGINT: "INT", syscall.SIGKILL: "KILL", syscall.SIGPIPE: "PIPE",
+	syscall.SIGQUIT: "QUIT", syscall.SIGSEGV: "SEGV", syscall.SIGTERM: "TERM", syscall.SIGUSR1: "USR1",
+	syscall.SIGUSR2: "USR2",
+}
+
+func signalName(signal syscall.Signal) string {
+	if name, ok := signalNames[signal]; ok {
+		return name
+	}
+	return fmt.Sprintf("%d", int(signal))
+}
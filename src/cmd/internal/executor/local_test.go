@@ -0,0 +1,90 @@
+package executor
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NobleFactor/docker-webhook/cmd/internal/sshremote"
+)
+
+func TestLocal_Execute(t *testing.T) {
+	t.Setenv("WEBHOOK_TEST_SECRET", "do-not-leak")
+
+	directory := t.TempDir()
+	local := &Local{name: "local://" + directory, Directory: directory}
+
+	response := local.Execute(`pwd; echo "$WEBHOOK_CORRELATION_ID ${WEBHOOK_TEST_SECRET:-unset}"; cat; echo oops >&2; exit 3`, sshremote.ExecuteOptions{
+		Environment: map[string]string{"WEBHOOK_CORRELATION_ID": "cid-1"},
+		Stdin:       []byte("payload\n"),
+		ExitReasons: sshremote.ExitReasons{3: "Nothing To Do"},
+	})
+
+	want := directory + "\ncid-1 unset\npayload\n"
+	if response.Status != 3 || response.Reason != "Nothing To Do" {
+		t.Fatalf("want status 3 with the configured reason, got %d %q: %s", response.Status, response.Reason, deref(response.Error))
+	}
+	if deref(response.Stdout) != want || deref(response.Stderr) != "oops\n" {
+		t.Fatalf("unexpected output: stdout %q stderr %q", deref(response.Stdout), deref(response.Stderr))
+	}
+	if response.StdinSha256 == "" || response.Attempts != 1 {
+		t.Fatalf("want the stdin digest and one attempt, got %+v", response)
+	}
+}
+
+func TestLocal_Timeout(t *testing.T) {
+	local := &Local{name: "local://", Directory: os.TempDir()}
+
+	start := time.Now()
+	response := local.Execute("echo started; sleep 30", sshremote.ExecuteOptions{Timeout: 200 * time.Millisecond, KillAfter: 200 * time.Millisecond})
+
+	if response.Status != sshremote.TimedOutStatus || response.Reason != "Timed Out" || !response.TimedOut || response.Signal != "TERM" {
+		t.Fatalf("want a timeout ended by TERM, got %d %q signal %q", response.Status, response.Reason, response.Signal)
+	}
+	if deref(response.Stdout) != "started\n" {
+		t.Fatalf("want the partial output, got %q", deref(response.Stdout))
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("want the command stopped promptly, took %s", elapsed)
+	}
+}
+
+func TestLocal_OutputHeldOpen(t *testing.T) {
+	local := &Local{name: "local://", Directory: os.TempDir()}
+
+	start := time.Now()
+	response := local.Execute("echo started; sleep 5 &", sshremote.ExecuteOptions{KillAfter: 200 * time.Millisecond})
+
+	if response.Status != 0 || response.Reason != "OK" || deref(response.Stdout) != "started\n" {
+		t.Fatalf("want the command's own result, got %d %q %q", response.Status, response.Reason, deref(response.Stdout))
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("want the wait for output bounded, took %s", elapsed)
+	}
+}
+
+func TestLocal_Signal(t *testing.T) {
+	local := &Local{name: "local://", Directory: os.TempDir()}
+
+	response := local.Execute("kill -KILL $$", sshremote.ExecuteOptions{})
+	if response.Status != 137 || response.Reason != "Terminated by Signal" || response.Signal != "KILL" {
+		t.Fatalf("want termination by KILL, got %d %q signal %q", response.Status, response.Reason, response.Signal)
+	}
+}
+
+func TestLocal_Unsupported(t *testing.T) {
+	local := &Local{name: "local://", Directory: os.TempDir()}
+
+	response := local.Execute("true", sshremote.ExecuteOptions{Transfers: []sshremote.Transfer{{Direction: sshremote.Upload}}})
+	if response.Reason != "Executor Error" || !strings.Contains(deref(response.Error), "ssh://") {
+		t.Fatalf("want transfers refused, got %q %s", response.Reason, deref(response.Error))
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
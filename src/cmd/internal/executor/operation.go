@@ -0,0 +1,175 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/NobleFactor/docker-webhook/cmd/internal/dockerapi"
+	"github.com/NobleFactor/docker-webhook/cmd/internal/sshremote"
+)
+
+// Docker operations
+const (
+	OperationPull     = "pull"
+	OperationRecreate = "recreate"
+	OperationInspect  = "inspect"
+	OperationList     = "list"
+)
+
+// DefaultRemoteDockerSocket is where the Docker daemon of an SSH destination listens unless configured otherwise
+const DefaultRemoteDockerSocket = "/var/run/docker.sock"
+
+// Operation is a structured operation on the Docker daemon of a destination. Target is the image reference to pull,
+// the container to recreate or inspect, or an optional name filter for list.
+type Operation struct {
+	Name   string
+	Target string
+}
+
+// ParseOperation returns the operation named by name on target
+func ParseOperation(name, target string) (Operation, error) {
+
+	operation := Operation{Name: name, Target: target}
+
+	switch name {
+	case OperationPull:
+		if !dockerapi.ValidReference(target) {
+			return Operation{}, fmt.Errorf("%s needs an image reference: %q", name, target)
+		}
+	case OperationRecreate, OperationInspect:
+		if !dockerapi.ValidContainerName(target) {
+			return Operation{}, fmt.Errorf("%s needs a container name: %q", name, target)
+		}
+	case OperationList:
+		if target != "" && !dockerapi.ValidContainerName(target) {
+			return Operation{}, fmt.Errorf("list needs a container name to filter by or none: %q", target)
+		}
+	default:
+		return Operation{}, fmt.Errorf("unknown docker operation %q: want %s, %s, %s or %s", name, OperationPull, OperationRecreate, OperationInspect, OperationList)
+	}
+
+	return operation, nil
+}
+
+// Command returns the operation written as the docker CLI command it stands for. It is what the authorization policy
+// matches and what the logs show.
+func (o Operation) Command() string {
+	if o.Target == "" {
+		return "docker " + o.Name
+	}
+	return "docker " + o.Name + " " + o.Target
+}
+
+// WithOperation returns an executor that performs operation on the Docker daemon of destination instead of running a
+// command. The daemon is reached by tunnelling to its Unix socket, socketPath, over the SSH connection, so only ssh://
+// destinations support it.
+func WithOperation(destination Executor, operation Operation, socketPath string) (Executor, error) {
+	target, ok := destination.(*SSH)
+	if !ok {
+		return nil, fmt.Errorf("docker operations need an ssh:// destination: %s", destination.Name())
+	}
+	return &operationExecutor{ssh: target, operation: operation, socketPath: socketPath}, nil
+}
+
+type operationExecutor struct {
+	ssh        *SSH
+	operation  Operation
+	socketPath string
+}
+
+func (e *operationExecutor) Name() string {
+	return e.ssh.Name()
+}
+
+// Execute performs the operation, ignoring command. Its report is returned in the response's docker field.
+func (e *operationExecutor) Execute(command string, options sshremote.ExecuteOptions) sshremote.Response {
+
+	if response := unsupported(options); response != nil {
+		return *response
+	}
+
+	tunnel, failure := sshremote.OpenTunnel(e.ssh.Destination, options.Retry, options.Cancel)
+	if failure != nil {
+		return *failure
+	}
+	defer tunnel.Close()
+
+	client := dockerapi.NewClient(func() (net.Conn, error) {
+		return tunnel.DialUnix(e.socketPath)
+	})
+
+	// Bound the operation by the timeout and stop it when the caller cancels
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if options.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), options.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+
+	cancelled := make(chan struct{})
+	go func() {
+		select {
+		case <-options.Cancel:
+			close(cancelled)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	report, err := e.perform(ctx, client)
+
+	response := sshremote.Response{Docker: report}
+	tunnel.Fill(&response)
+
+	switch {
+	case err == nil && report.Unhealthy():
+		errorMsg := "the container is unhealthy"
+		response.Error, response.Status, response.Reason = &errorMsg, 1, "Container Unhealthy"
+	case err == nil:
+		response.Status, response.Reason = 0, "OK"
+	case isClosed(cancelled):
+		errorMsg := fmt.Sprintf("docker %s cancelled", e.operation.Name)
+		response.Error, response.Status, response.Reason = &errorMsg, -1, "Cancelled"
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		errorMsg := fmt.Sprintf("docker %s timed out after %s: %v", e.operation.Name, options.Timeout, err)
+		response.Error, response.Status, response.Reason, response.TimedOut = &errorMsg, sshremote.TimedOutStatus, "Timed Out", true
+	case dockerapi.NotFound(err) && e.operation.Name != OperationPull:
+		errorMsg := err.Error()
+		response.Error, response.Status, response.Reason = &errorMsg, -1, "Container Not Found"
+	default:
+		errorMsg := err.Error()
+		response.Error, response.Status, response.Reason = &errorMsg, -1, "Docker Error"
+	}
+
+	return response
+}
+
+func (e *operationExecutor) perform(ctx context.Context, client *dockerapi.Client) (*dockerapi.Report, error) {
+	switch e.operation.Name {
+	case OperationPull:
+		return client.Pull(ctx, e.operation.Target)
+	case OperationRecreate:
+		return client.Recreate(ctx, e.operation.Target)
+	case OperationInspect:
+		return client.Inspect(ctx, e.operation.Target)
+	default:
+		return client.List(ctx, e.operation.Target)
+	}
+}
+
+// isClosed reports whether channel is closed
+func isClosed(channel <-chan struct{}) bool {
+	select {
+	case <-channel:
+		return true
+	default:
+		return false
+	}
+}
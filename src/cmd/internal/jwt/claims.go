@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+package jwt
+
+import (
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ClaimString returns the named claim of a validated token as a string. Non-string values are formatted with %v and
+// a missing claim yields "".
+func ClaimString(token *jwt.Token, name string) string {
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return ""
+	}
+
+	switch value := claims[name].(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
+
+// ClaimStrings returns the named claim of a validated token as a list of strings. The claim may be a single string or
+// an array of strings; any other value yields nil.
+func ClaimStrings(token *jwt.Token, name string) []string {
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil
+	}
+
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			s, ok := item.(string)
+			if !ok {
+				return nil
+			}
+			values = append(values, s)
+		}
+		return values
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,438 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Defaults of a JWKS
+const (
+	DefaultJWKSRefetchInterval = time.Minute
+	DefaultJWKSMaxAge          = time.Hour
+	DefaultJWKSMaxStale        = time.Hour
+)
+
+// jwksDocumentLimit bounds the size of a JWKS document
+const jwksDocumentLimit = 1 << 20
+
+// JWKS is a JSON Web Key Set that verification keys are selected from by kid. A set published at a URL is cached in
+// CachePath for as long as its Cache-Control header allows, and refetched when a token names a kid it does not hold,
+// at most once every RefetchInterval. Because the cache is on disk, the limit holds across processes that share it.
+type JWKS struct {
+
+	// Location is the http:// or https:// URL of the set, or the path of a file holding it
+	Location string
+
+	// CachePath is the file a fetched set is cached in; empty disables the cache
+	CachePath string
+
+	// Client fetches the set; nil uses a client with a ten-second timeout
+	Client *http.Client
+
+	// RefetchInterval is the least time between fetches of the set; zero uses DefaultJWKSRefetchInterval
+	RefetchInterval time.Duration
+
+	// DefaultMaxAge is how long a set is cached when the response has no Cache-Control max-age; zero uses
+	// DefaultJWKSMaxAge
+	DefaultMaxAge time.Duration
+
+	// MaxStale is how long past its expiry a cached set is still used while fetches fail. Past it, keys the provider
+	// may have rotated out or revoked no longer verify tokens and the fetch error is returned. Zero uses
+	// DefaultJWKSMaxStale.
+	MaxStale time.Duration
+
+	now     func() time.Time
+	keys    []jwksKey
+	fetched time.Time
+}
+
+// jwksKey is a verification key of a set with the kid and the alg it was published with
+type jwksKey struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+// jwksCache is the content of a JWKS cache file. Fetched is when the set was last fetched, whether or not the fetch
+// succeeded, and Document is the last set that may be stored, if any.
+type jwksCache struct {
+	Location string          `json:"location"`
+	Fetched  time.Time       `json:"fetched"`
+	Failed   bool            `json:"failed,omitempty"`
+	Expires  time.Time       `json:"expires"`
+	Document json.RawMessage `json:"document,omitempty"`
+}
+
+// Key returns the key of the set identified by kid, which must not be published for an algorithm other than alg. An
+// unknown kid refetches the set, unless it was fetched less than RefetchInterval ago.
+func (jwks *JWKS) Key(kid, alg string) (crypto.PublicKey, error) {
+
+	if err := jwks.load(false); err != nil {
+		return nil, err
+	}
+
+	key, ok := jwks.find(kid)
+	if !ok && jwks.remote() && jwks.clock().Sub(jwks.fetched) >= jwks.refetchInterval() {
+		if err := jwks.load(true); err != nil {
+			return nil, err
+		}
+		key, ok = jwks.find(kid)
+	}
+
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("no JWKS key has kid %q", kid)
+	case key.alg != "" && key.alg != alg:
+		return nil, fmt.Errorf("JWKS key %q is for %s, not %s", kid, key.alg, alg)
+	default:
+		return key.key, nil
+	}
+}
+
+// Keys returns every key of the set that is not published for an algorithm other than alg
+func (jwks *JWKS) Keys(alg string) ([]crypto.PublicKey, error) {
+
+	if err := jwks.load(false); err != nil {
+		return nil, err
+	}
+
+	keys := make([]crypto.PublicKey, 0, len(jwks.keys))
+	for _, key := range jwks.keys {
+		if key.alg == "" || key.alg == alg {
+			keys = append(keys, key.key)
+		}
+	}
+	return keys, nil
+}
+
+// find returns the key of the set identified by kid
+func (jwks *JWKS) find(kid string) (jwksKey, bool) {
+	for _, key := range jwks.keys {
+		if key.kid == kid {
+			return key, true
+		}
+	}
+	return jwksKey{}, false
+}
+
+// load loads the set when it is not loaded yet or when refetch is set. A set at a URL is read from the cache while it
+// is fresh, or while it was fetched less than RefetchInterval ago when refetching or after a failed fetch, and fetched
+// otherwise. When a fetch fails, a cached set is used rather than none until it has been expired for MaxStale.
+//
+// Every fetch is recorded in the cache, including one that fails or returns a set that may not be stored, so that the
+// refetch limit holds across processes. A set that may not be stored is still fetched once by each process.
+func (jwks *JWKS) load(refetch bool) error {
+
+	if jwks.keys != nil && !refetch {
+		return nil
+	}
+
+	if !jwks.remote() {
+		document, err := os.ReadFile(jwks.Location)
+		if err != nil {
+			return fmt.Errorf("failed to read JWKS: %w", err)
+		}
+		return jwks.parse(document, time.Time{})
+	}
+
+	now := jwks.clock()
+	cache := jwks.readCache()
+
+	if cache != nil {
+		fresh := now.Before(cache.Expires) && !refetch
+		recent := now.Sub(cache.Fetched) < jwks.refetchInterval() && (refetch || cache.Failed)
+		expired := cache.Failed && !jwks.usable(cache, now)
+		if (fresh || recent) && !expired && cache.Document != nil && jwks.parse(cache.Document, cache.Fetched) == nil {
+			return nil
+		}
+		if recent && cache.Failed {
+			return fmt.Errorf("failed to fetch JWKS: the fetch at %s failed and is not retried for %s", cache.Fetched.Format(time.RFC3339), jwks.refetchInterval())
+		}
+	}
+
+	document, maxAge, store, err := jwks.fetch()
+	if err == nil {
+		err = jwks.parse(document, now)
+	}
+	if err != nil {
+		failed := jwksCache{Location: jwks.Location, Fetched: now, Failed: true}
+		if cache != nil {
+			failed.Expires, failed.Document = cache.Expires, cache.Document
+		}
+		jwks.writeCache(failed)
+		if failed.Document != nil && jwks.usable(&failed, now) && jwks.parse(failed.Document, now) == nil {
+			return nil
+		}
+		if failed.Document != nil {
+			return fmt.Errorf("%w; the cached JWKS expired at %s", err, failed.Expires.Format(time.RFC3339))
+		}
+		return err
+	}
+
+	fetched := jwksCache{Location: jwks.Location, Fetched: now}
+	if store {
+		fetched.Expires, fetched.Document = now.Add(maxAge), document
+	}
+	jwks.writeCache(fetched)
+
+	return nil
+}
+
+// fetch fetches the set and returns it with how long it may be cached and whether it may be stored at all
+func (jwks *JWKS) fetch() ([]byte, time.Duration, bool, error) {
+
+	client := jwks.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	response, err := client.Get(jwks.Location)
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, 0, false, fmt.Errorf("failed to fetch JWKS: %s", response.Status)
+	}
+
+	document, err := io.ReadAll(io.LimitReader(response.Body, jwksDocumentLimit+1))
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	if len(document) > jwksDocumentLimit {
+		return nil, 0, false, fmt.Errorf("failed to fetch JWKS: document exceeds %d bytes", jwksDocumentLimit)
+	}
+
+	maxAge, store := cacheLifetime(response.Header.Get("Cache-Control"), jwks.defaultMaxAge())
+	return document, maxAge, store, nil
+}
+
+// cacheLifetime returns how long a response with the Cache-Control header value may be cached, defaulting to
+// defaultMaxAge, and whether it may be stored at all. no-cache allows storing it, but not using it without a fetch.
+func cacheLifetime(value string, defaultMaxAge time.Duration) (time.Duration, bool) {
+
+	maxAge, noCache := defaultMaxAge, false
+
+	for _, directive := range strings.Split(value, ",") {
+		name, argument, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-store":
+			return 0, false
+		case "no-cache":
+			noCache = true
+		case "max-age":
+			if seconds, err := strconv.Atoi(strings.Trim(argument, `"`)); err == nil && seconds >= 0 {
+				maxAge = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	if noCache {
+		return 0, true
+	}
+	return maxAge, true
+}
+
+// parse replaces the keys of the set with those of document, fetched at fetched
+func (jwks *JWKS) parse(document []byte, fetched time.Time) error {
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(document, &set); err != nil {
+		return fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	// Keys meant for encryption, or of types that cannot verify tokens, are skipped rather than rejected. So is a key
+	// whose kid an earlier key has, since a kid must select one key.
+
+	keys := make([]jwksKey, 0, len(set.Keys))
+	kids := make(map[string]bool, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if (jwk.Use != "" && jwk.Use != "sig") || (jwk.Kid != "" && kids[jwk.Kid]) {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			keys = append(keys, jwksKey{kid: jwk.Kid, alg: jwk.Alg, key: key})
+			kids[jwk.Kid] = true
+		}
+	}
+
+	jwks.keys, jwks.fetched = keys, fetched
+	return nil
+}
+
+// jsonWebKey is a public key of a JWKS
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey returns the RSA, ECDSA or Ed25519 key jwk describes
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+
+	decode := func(value string) *big.Int {
+		b, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil || len(b) == 0 {
+			return nil
+		}
+		return new(big.Int).SetBytes(b)
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, e := decode(jwk.N), decode(jwk.E)
+		if n == nil || e == nil || !e.IsInt64() {
+			return nil, fmt.Errorf("invalid RSA key %q", jwk.Kid)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[jwk.Crv]
+		x, y := decode(jwk.X), decode(jwk.Y)
+		if !ok || x == nil || y == nil || !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("invalid EC key %q", jwk.Kid)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if jwk.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid OKP key %q", jwk.Kid)
+		}
+		return ed25519.PublicKey(x), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+}
+
+// readCache returns the cached set, or nil when there is none for this location. A file that another user owns, or that
+// its group or others may write, is ignored, since whoever can write it chooses the keys that verify tokens.
+func (jwks *JWKS) readCache() *jwksCache {
+
+	if jwks.CachePath == "" {
+		return nil
+	}
+
+	file, err := os.Open(jwks.CachePath)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0o022 != 0 {
+		return nil
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !ok || int(stat.Uid) != os.Getuid() {
+		return nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, 2*jwksDocumentLimit))
+	if err != nil {
+		return nil
+	}
+
+	var cache jwksCache
+	if json.Unmarshal(data, &cache) != nil || cache.Location != jwks.Location {
+		return nil
+	}
+	return &cache
+}
+
+// writeCache replaces the cache file, creating its directory readable only by this user if need be. The file is
+// renamed into place so that concurrent readers never see part of it. Failures are ignored: without the cache, the set
+// is only fetched more often.
+func (jwks *JWKS) writeCache(cache jwksCache) {
+
+	if jwks.CachePath == "" {
+		return
+	}
+
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(jwks.CachePath), 0o700); err != nil {
+		return
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(jwks.CachePath), filepath.Base(jwks.CachePath)+".*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		os.Rename(file.Name(), jwks.CachePath)
+	}
+}
+
+// usable reports whether the set in cache may still be used at now, which is until it has been expired for MaxStale
+func (jwks *JWKS) usable(cache *jwksCache, now time.Time) bool {
+	return now.Before(cache.Expires.Add(jwks.maxStale()))
+}
+
+func (jwks *JWKS) remote() bool {
+	return strings.HasPrefix(jwks.Location, "https://") || strings.HasPrefix(jwks.Location, "http://")
+}
+
+func (jwks *JWKS) clock() time.Time {
+	if jwks.now != nil {
+		return jwks.now()
+	}
+	return time.Now()
+}
+
+func (jwks *JWKS) refetchInterval() time.Duration {
+	if jwks.RefetchInterval > 0 {
+		return jwks.RefetchInterval
+	}
+	return DefaultJWKSRefetchInterval
+}
+
+func (jwks *JWKS) defaultMaxAge() time.Duration {
+	if jwks.DefaultMaxAge > 0 {
+		return jwks.DefaultMaxAge
+	}
+	return DefaultJWKSMaxAge
+}
+
+func (jwks *JWKS) maxStale() time.Duration {
+	if jwks.MaxStale > 0 {
+		return jwks.MaxStale
+	}
+	return DefaultJWKSMaxStale
+}
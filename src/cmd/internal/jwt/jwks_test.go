@@ -0,0 +1,368 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// helper to describe the public keys of signers, by kid, as a JWKS document
+func jwksDocument(t *testing.T, signers map[string]crypto.Signer) []byte {
+	t.Helper()
+	encode := base64.RawURLEncoding.EncodeToString
+	keys := []map[string]string{}
+	for kid, signer := range signers {
+		switch key := signer.Public().(type) {
+		case *ecdsa.PublicKey:
+			keys = append(keys, map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": encode(key.X.FillBytes(make([]byte, 32))), "y": encode(key.Y.FillBytes(make([]byte, 32)))})
+		case ed25519.PublicKey:
+			keys = append(keys, map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": encode(key), "use": "sig"})
+		default:
+			t.Fatalf("unexpected key type %T", key)
+		}
+	}
+	document, err := json.Marshal(map[string]any{"keys": keys})
+	if err != nil {
+		t.Fatalf("failed to marshal JWKS: %v", err)
+	}
+	return document
+}
+
+// helper to serve the JWKS document returned by document with the given Cache-Control, counting requests
+func serveJWKS(t *testing.T, cacheControl string, document func() []byte) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		w.Write(document())
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+// helper to sign a token with signer, naming it by kid
+func signWithKid(t *testing.T, method jwt.SigningMethod, signer crypto.Signer, kid string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, jwt.MapClaims{"iss": DefaultIssuer, "sub": "loc", "exp": time.Now().Add(time.Minute).Unix()})
+	token.Header["kid"] = kid
+	s, err := token.SignedString(signer)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return s
+}
+
+func TestJWKS_SelectsByKidAndCaches(t *testing.T) {
+	signers := generateKeys(t)
+	document := jwksDocument(t, map[string]crypto.Signer{"ec-1": signers["ES256"], "ed-1": signers["EdDSA"]})
+	server, requests := serveJWKS(t, "public, max-age=600", func() []byte { return document })
+	cachePath := filepath.Join(t.TempDir(), "jwks.json")
+
+	keys := &Keys{JWKS: &JWKS{Location: server.URL, CachePath: cachePath}}
+	if _, _, err := keys.ValidateJWT("Bearer "+signWithKid(t, jwt.SigningMethodES256, signers["ES256"], "ec-1"), "loc"); err != nil {
+		t.Fatalf("ValidateJWT failed: %v", err)
+	}
+
+	// The token must be signed by the key its kid names
+	if _, _, err := keys.ValidateJWT("Bearer "+signWithKid(t, jwt.SigningMethodES256, signers["ES256"], "ed-1"), "loc"); err == nil {
+		t.Fatalf("expected ValidateJWT to reject a token whose kid names another key")
+	}
+
+	// A later process reads the set from the cache while it is fresh
+	keys = &Keys{JWKS: &JWKS{Location: server.URL, CachePath: cachePath}}
+	if _, _, err := keys.ValidateJWT("Bearer "+signWithKid(t, jwt.SigningMethodEdDSA, signers["EdDSA"], "ed-1"), "loc"); err != nil {
+		t.Fatalf("ValidateJWT failed: %v", err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("expected 1 fetch, got %d", n)
+	}
+
+	// Once it expires, the set is fetched again
+	now := time.Now().Add(11 * time.Minute)
+	keys = &Keys{JWKS: &JWKS{Location: server.URL, CachePath: cachePath, now: func() time.Time { return now }}}
+	if _, err := keys.JWKS.Key("ed-1", "EdDSA"); err != nil {
+		t.Fatalf("Key failed: %v", err)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Fatalf("expected 2 fetches, got %d", n)
+	}
+}
+
+func TestJWKS_RefetchesUnknownKidWithRateLimit(t *testing.T) {
+	signers := generateKeys(t)
+	rotated := generateKeys(t)
+	var current atomic.Value
+	current.Store(jwksDocument(t, map[string]crypto.Signer{"key-1": signers["EdDSA"]}))
+	server, requests := serveJWKS(t, "max-age=3600", func() []byte { return current.Load().([]byte) })
+	cachePath := filepath.Join(t.TempDir(), "jwks.json")
+
+	now := time.Now()
+	clock := func() time.Time { return now }
+
+	jwks := &JWKS{Location: server.URL, CachePath: cachePath, RefetchInterval: time.Minute, now: clock}
+	if _, err := jwks.Key("key-1", "EdDSA"); err != nil {
+		t.Fatalf("Key failed: %v", err)
+	}
+
+	// The provider rotates its key. Within the refetch interval an unknown kid does not refetch, even in another process.
+	current.Store(jwksDocument(t, map[string]crypto.Signer{"key-2": rotated["EdDSA"]}))
+	now = now.Add(30 * time.Second)
+	if _, err := jwks.Key("key-2", "EdDSA"); err == nil {
+		t.Fatalf("expected unknown kid within the refetch interval to fail")
+	}
+	jwks = &JWKS{Location: server.URL, CachePath: cachePath, RefetchInterval: time.Minute, now: clock}
+	if _, err := jwks.Key("key-2", "EdDSA"); err == nil {
+		t.Fatalf("expected unknown kid within the refetch interval to fail")
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("expected 1 fetch, got %d", n)
+	}
+
+	// After it, the set is refetched and the new key found, though the cache had not expired
+	now = now.Add(time.Minute)
+	key, err := jwks.Key("key-2", "EdDSA")
+	if err != nil {
+		t.Fatalf("Key failed: %v", err)
+	}
+	if !rotated["EdDSA"].Public().(ed25519.PublicKey).Equal(key) {
+		t.Fatalf("unexpected key %v", key)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Fatalf("expected 2 fetches, got %d", n)
+	}
+}
+
+func TestJWKS_NoStore(t *testing.T) {
+	signers := generateKeys(t)
+	document := jwksDocument(t, map[string]crypto.Signer{"key-1": signers["EdDSA"]})
+	server, _ := serveJWKS(t, "no-store", func() []byte { return document })
+	cachePath := filepath.Join(t.TempDir(), "jwks.json")
+
+	jwks := &JWKS{Location: server.URL, CachePath: cachePath}
+	if _, err := jwks.Key("key-1", "EdDSA"); err != nil {
+		t.Fatalf("Key failed: %v", err)
+	}
+
+	// The fetch is recorded, but not the set
+	data, err := os.ReadFile(cachePath)
+	if err != nil {
+		t.Fatalf("failed to read cache: %v", err)
+	}
+	var cache jwksCache
+	if err := json.Unmarshal(data, &cache); err != nil || cache.Fetched.IsZero() || cache.Document != nil {
+		t.Fatalf("expected a cache entry without a document, got %s (%v)", data, err)
+	}
+}
+
+func TestJWKS_FailedFetchIsRateLimited(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	signers := generateKeys(t)
+	document := jwksDocument(t, map[string]crypto.Signer{"key-1": signers["EdDSA"]})
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(document)
+	}))
+	t.Cleanup(server.Close)
+	cachePath := filepath.Join(t.TempDir(), "jwks.json")
+
+	now := time.Now()
+	clock := func() time.Time { return now }
+
+	// Within the refetch interval, a later process does not retry a failed fetch
+	for i := 0; i < 2; i++ {
+		jwks := &JWKS{Location: server.URL, CachePath: cachePath, RefetchInterval: time.Minute, now: clock}
+		if _, err := jwks.Key("key-1", "EdDSA"); err == nil {
+			t.Fatalf("expected Key to fail while the JWKS is unavailable")
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("expected 1 fetch, got %d", n)
+	}
+
+	// After it, the fetch is retried
+	failing.Store(false)
+	now = now.Add(time.Minute)
+	jwks := &JWKS{Location: server.URL, CachePath: cachePath, RefetchInterval: time.Minute, now: clock}
+	if _, err := jwks.Key("key-1", "EdDSA"); err != nil {
+		t.Fatalf("Key failed: %v", err)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Fatalf("expected 2 fetches, got %d", n)
+	}
+}
+
+func TestJWKS_StaleSetIsBounded(t *testing.T) {
+	var failing atomic.Bool
+	signers := generateKeys(t)
+	document := jwksDocument(t, map[string]crypto.Signer{"key-1": signers["EdDSA"]})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write(document)
+	}))
+	t.Cleanup(server.Close)
+	cachePath := filepath.Join(t.TempDir(), "jwks.json")
+
+	now := time.Now()
+	clock := func() time.Time { return now }
+	newJWKS := func() *JWKS {
+		return &JWKS{Location: server.URL, CachePath: cachePath, MaxStale: time.Hour, now: clock}
+	}
+
+	if _, err := newJWKS().Key("key-1", "EdDSA"); err != nil {
+		t.Fatalf("Key failed: %v", err)
+	}
+
+	// While the provider is unreachable, the expired set is used for MaxStale, both after a failed fetch and from the
+	// record of it
+	failing.Store(true)
+	now = now.Add(30 * time.Minute)
+	for i := 0; i < 2; i++ {
+		if _, err := newJWKS().Key("key-1", "EdDSA"); err != nil {
+			t.Fatalf("Key failed within MaxStale: %v", err)
+		}
+	}
+
+	// Past it, the fetch error is returned instead
+	now = now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		if _, err := newJWKS().Key("key-1", "EdDSA"); err == nil {
+			t.Fatalf("expected Key to fail once the cached set is past MaxStale")
+		}
+	}
+}
+
+func TestJWKS_IgnoresWritableCache(t *testing.T) {
+	signers := generateKeys(t)
+	planted := generateKeys(t)
+	document := jwksDocument(t, map[string]crypto.Signer{"key-1": signers["EdDSA"]})
+	server, requests := serveJWKS(t, "max-age=3600", func() []byte { return document })
+	cachePath := filepath.Join(t.TempDir(), "jwks.json")
+
+	// A cache file others may write is not trusted, however fresh it claims to be
+	data, err := json.Marshal(jwksCache{Location: server.URL, Fetched: time.Now(), Expires: time.Now().Add(time.Hour), Document: jwksDocument(t, map[string]crypto.Signer{"key-1": planted["EdDSA"]})})
+	if err != nil {
+		t.Fatalf("failed to marshal cache: %v", err)
+	}
+	if err := os.WriteFile(cachePath, data, 0o600); err != nil {
+		t.Fatalf("failed to write cache: %v", err)
+	}
+	if err := os.Chmod(cachePath, 0o666); err != nil {
+		t.Fatalf("failed to chmod cache: %v", err)
+	}
+
+	jwks := &JWKS{Location: server.URL, CachePath: cachePath}
+	key, err := jwks.Key("key-1", "EdDSA")
+	if err != nil {
+		t.Fatalf("Key failed: %v", err)
+	}
+	if !signers["EdDSA"].Public().(ed25519.PublicKey).Equal(key) {
+		t.Fatalf("expected the fetched key, got the planted one")
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("expected 1 fetch, got %d", n)
+	}
+}
+
+func TestJWKS_AlgAndDuplicateKid(t *testing.T) {
+	signers := generateKeys(t)
+	encode := base64.RawURLEncoding.EncodeToString
+	rsaKey := signers["RS256"].Public().(*rsa.PublicKey)
+	document, err := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "key-1", "alg": "RS256", "n": encode(rsaKey.N.Bytes()), "e": encode(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "OKP", "kid": "key-1", "crv": "Ed25519", "x": encode(signers["EdDSA"].Public().(ed25519.PublicKey))},
+	}})
+	if err != nil {
+		t.Fatalf("failed to marshal JWKS: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, document, 0o600); err != nil {
+		t.Fatalf("failed to write JWKS: %v", err)
+	}
+	keys := &Keys{JWKS: &JWKS{Location: path}}
+
+	if _, _, err := keys.ValidateJWT("Bearer "+signWithKid(t, jwt.SigningMethodRS256, signers["RS256"], "key-1"), "loc"); err != nil {
+		t.Fatalf("ValidateJWT failed: %v", err)
+	}
+
+	// A key published for RS256 verifies no other algorithm, whether selected by kid or not
+	for _, kid := range []string{"key-1", ""} {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS384, jwt.MapClaims{"iss": DefaultIssuer, "sub": "loc", "exp": time.Now().Add(time.Minute).Unix()})
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(signers["RS256"])
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		if _, _, err := keys.ValidateJWT("Bearer "+signed, "loc"); err == nil {
+			t.Fatalf("expected an RS384 token with kid %q to be refused", kid)
+		}
+	}
+
+	// The second key with kid key-1 is skipped
+	if _, _, err := keys.ValidateJWT("Bearer "+signWithKid(t, jwt.SigningMethodEdDSA, signers["EdDSA"], "key-1"), "loc"); err == nil {
+		t.Fatalf("expected the duplicate kid to be skipped")
+	}
+}
+
+func TestJWKS_File(t *testing.T) {
+	signers := generateKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwksDocument(t, map[string]crypto.Signer{"key-1": signers["EdDSA"]}), 0o600); err != nil {
+		t.Fatalf("failed to write JWKS: %v", err)
+	}
+
+	keys := &Keys{JWKS: &JWKS{Location: path}}
+	if _, _, err := keys.ValidateJWT("Bearer "+signWithKid(t, jwt.SigningMethodEdDSA, signers["EdDSA"], "key-1"), "loc"); err != nil {
+		t.Fatalf("ValidateJWT failed: %v", err)
+	}
+	if _, _, err := keys.ValidateJWT("Bearer "+signWithKid(t, jwt.SigningMethodEdDSA, signers["EdDSA"], "key-2"), "loc"); err == nil {
+		t.Fatalf("expected ValidateJWT to reject an unknown kid")
+	}
+}
+
+func TestCacheLifetime(t *testing.T) {
+	tests := []struct {
+		value  string
+		maxAge time.Duration
+		store  bool
+	}{
+		{"", time.Hour, true},
+		{"public, max-age=300", 5 * time.Minute, true},
+		{"max-age=300, no-cache", 0, true},
+		{"no-store, max-age=300", 0, false},
+		{"max-age=invalid", time.Hour, true},
+	}
+	for _, tt := range tests {
+		maxAge, store := cacheLifetime(tt.value, time.Hour)
+		if maxAge != tt.maxAge || store != tt.store {
+			t.Fatalf("%q: got %s %v, want %s %v", tt.value, maxAge, store, tt.maxAge, tt.store)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SupportedAlgorithms lists the values of the alg header that tokens may be signed with
+var SupportedAlgorithms = []string{
+	"HS256", "HS384", "HS512",
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+// Keys holds the keys tokens are verified and refreshed with
+type Keys struct {
+
+	// Secret verifies and signs HMAC tokens. SecretVersion, when set, names it in the kid header of the tokens it signs.
+	Secret        []byte
+	SecretVersion string
+
+	// PreviousSecrets verify, but never sign, HMAC tokens whose kid header names their version. A token without kid is
+	// verified by Secret alone, so that a leaked version stops verifying tokens once it is no longer current.
+	PreviousSecrets map[string][]byte
+
+	// PublicKeys verify RSA, ECDSA and Ed25519 tokens. Each key of the right type is tried in turn.
+	PublicKeys []crypto.PublicKey
+
+	// SigningKey signs refreshed tokens that were not signed with Secret, and its public key verifies them. Without it
+	// such tokens are not refreshed.
+	SigningKey crypto.Signer
+
+	// JWKS, when set, holds further public keys. A token that names a key with its kid header is verified only by the
+	// key of the set with that kid.
+	JWKS *JWKS
+
+	// Profile is what the claims of a token must satisfy; nil uses DefaultProfile
+	Profile *Profile
+
+	// MaxSessionAge, when set, is the longest time after its session began that a token may be refreshed
+	MaxSessionAge time.Duration
+
+	// Algorithms lists the accepted values of the alg header. When empty, every supported algorithm that a configured
+	// key can verify is accepted.
+	Algorithms []string
+}
+
+// HMACKeys returns the keys of a hex-encoded HMAC secret
+func HMACKeys(secretHex string) (*Keys, error) {
+	secret, err := hex.DecodeString(secretHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret hex: %w", err)
+	}
+	return &Keys{Secret: secret}, nil
+}
+
+// ParsePublicKey parses the first PEM block of data: a PKIX public key, a PKCS #1 RSA public key or an X.509
+// certificate holding an RSA, ECDSA or Ed25519 key
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+
+	var key crypto.PublicKey
+	var err error
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var certificate *x509.Certificate
+		certificate, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			key = certificate.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q: want PUBLIC KEY, RSA PUBLIC KEY or CERTIFICATE", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+}
+
+// ParsePrivateKey parses the first PEM block of data: an unencrypted PKCS #8, PKCS #1 RSA or SEC 1 EC private key
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+
+	var key any
+	var err error
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q: want PRIVATE KEY, RSA PRIVATE KEY or EC PRIVATE KEY", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok || signingMethodFor(signer) == nil {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+// signingMethodFor returns the method refreshed tokens are signed with by key, or nil when key cannot sign tokens
+func signingMethodFor(key crypto.Signer) jwt.SigningMethod {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		return ecdsaMethod(key.Curve)
+	case ed25519.PrivateKey:
+		return jwt.SigningMethodEdDSA
+	default:
+		return nil
+	}
+}
+
+// ecdsaMethod returns the ECDSA method that signs with curve, or nil for curves JWS does not use
+func ecdsaMethod(curve elliptic.Curve) *jwt.SigningMethodECDSA {
+	switch curve {
+	case elliptic.P256():
+		return jwt.SigningMethodES256
+	case elliptic.P384():
+		return jwt.SigningMethodES384
+	case elliptic.P521():
+		return jwt.SigningMethodES512
+	default:
+		return nil
+	}
+}
+
+// publicKeys returns the configured public keys and the public key of the signing key
+func (keys *Keys) publicKeys() []crypto.PublicKey {
+	if keys.SigningKey == nil {
+		return keys.PublicKeys
+	}
+	return append(slices.Clip(keys.PublicKeys), keys.SigningKey.Public())
+}
+
+// algorithms returns the accepted values of the alg header
+func (keys *Keys) algorithms() []string {
+
+	if len(keys.Algorithms) > 0 {
+		return keys.Algorithms
+	}
+
+	var algorithms []string
+	if len(keys.Secret) > 0 {
+		algorithms = append(algorithms, "HS256", "HS384", "HS512")
+	}
+	if keys.JWKS != nil {
+		algorithms = append(algorithms, SupportedAlgorithms[3:]...)
+	}
+	for _, key := range keys.publicKeys() {
+		switch key := key.(type) {
+		case *rsa.PublicKey:
+			algorithms = append(algorithms, "RS256", "RS384", "RS512", "PS256", "PS384", "PS512")
+		case *ecdsa.PublicKey:
+			if method := ecdsaMethod(key.Curve); method != nil {
+				algorithms = append(algorithms, method.Alg())
+			}
+		case ed25519.PublicKey:
+			algorithms = append(algorithms, "EdDSA")
+		}
+	}
+	slices.Sort(algorithms)
+
+	return slices.Compact(algorithms)
+}
+
+// hmacKey returns the secret that may have signed an HMAC token: the version its kid header names, or the current one
+// when it names none
+func (keys *Keys) hmacKey(token *jwt.Token) (interface{}, error) {
+
+	if len(keys.Secret) == 0 {
+		return nil, fmt.Errorf("no HMAC secret is configured")
+	}
+
+	kid, _ := token.Header["kid"].(string)
+
+	if kid == "" || kid == keys.SecretVersion {
+		return keys.Secret, nil
+	}
+
+	if secret, ok := keys.PreviousSecrets[kid]; ok {
+		return secret, nil
+	}
+	return nil, fmt.Errorf("no HMAC secret version %q is accepted", kid)
+}
+
+// verificationKey returns the keys that may have signed token, by the type its algorithm needs
+func (keys *Keys) verificationKey(token *jwt.Token) (interface{}, error) {
+
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		return keys.hmacKey(token)
+	}
+
+	pool := keys.publicKeys()
+
+	if keys.JWKS != nil {
+		if kid, ok := token.Header["kid"].(string); ok && kid != "" {
+			key, err := keys.JWKS.Key(kid, token.Method.Alg())
+			if err != nil {
+				return nil, err
+			}
+			pool = []crypto.PublicKey{key}
+		} else {
+			jwksKeys, err := keys.JWKS.Keys(token.Method.Alg())
+			if err != nil {
+				return nil, err
+			}
+			pool = append(slices.Clip(pool), jwksKeys...)
+		}
+	}
+
+	var candidates []jwt.VerificationKey
+	for _, key := range pool {
+		switch method := token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+			if _, ok := key.(*rsa.PublicKey); ok {
+				candidates = append(candidates, key)
+			}
+		case *jwt.SigningMethodECDSA:
+			if key, ok := key.(*ecdsa.PublicKey); ok && ecdsaMethod(key.Curve) == method {
+				candidates = append(candidates, key)
+			}
+		case *jwt.SigningMethodEd25519:
+			if _, ok := key.(ed25519.PublicKey); ok {
+				candidates = append(candidates, key)
+			}
+		default:
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no public key is configured for %v", token.Header["alg"])
+	}
+
+	return jwt.VerificationKeySet{Keys: candidates}, nil
+}
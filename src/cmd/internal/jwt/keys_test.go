@@ -0,0 +1,201 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// helper to generate the private keys of each asymmetric algorithm
+func generateKeys(t *testing.T) map[string]crypto.Signer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ECDSA key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %v", err)
+	}
+	return map[string]crypto.Signer{"RS256": rsaKey, "ES256": ecKey, "EdDSA": edKey}
+}
+
+// helper to PEM-encode the public key of signer
+func publicKeyPEM(t *testing.T, signer crypto.Signer) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestKeys_ValidateJWT_Asymmetric(t *testing.T) {
+	now := time.Now()
+	claims := jwt.MapClaims{"iss": DefaultIssuer, "sub": "loc", "iat": now.Unix(), "exp": now.Add(time.Minute).Unix()}
+
+	for alg, signer := range generateKeys(t) {
+		publicKey, err := ParsePublicKey(publicKeyPEM(t, signer))
+		if err != nil {
+			t.Fatalf("%s: ParsePublicKey failed: %v", alg, err)
+		}
+		keys := &Keys{PublicKeys: []crypto.PublicKey{publicKey}}
+
+		tok, err := jwt.NewWithClaims(jwt.GetSigningMethod(alg), claims).SignedString(signer)
+		if err != nil {
+			t.Fatalf("%s: failed to sign token: %v", alg, err)
+		}
+		if _, _, err := keys.ValidateJWT("Bearer "+tok, "loc"); err != nil {
+			t.Fatalf("%s: ValidateJWT failed: %v", alg, err)
+		}
+
+		// The algorithm must be accepted
+		keys.Algorithms = []string{"HS512"}
+		if _, _, err := keys.ValidateJWT("Bearer "+tok, "loc"); err == nil {
+			t.Fatalf("%s: expected ValidateJWT to reject an algorithm that is not accepted", alg)
+		}
+	}
+}
+
+func TestKeys_ValidateJWT_WrongKey(t *testing.T) {
+	signers := generateKeys(t)
+	other := generateKeys(t)
+
+	tok, err := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{"iss": DefaultIssuer, "sub": "x"}).SignedString(signers["EdDSA"])
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	// Neither a key of another type nor another Ed25519 key verifies the token, and a public key is never an HMAC secret
+	keys := &Keys{Secret: []byte("secret"), PublicKeys: []crypto.PublicKey{signers["RS256"].Public(), other["EdDSA"].Public()}}
+	if _, _, err := keys.ValidateJWT("Bearer "+tok, ""); err == nil {
+		t.Fatalf("expected ValidateJWT to fail with the wrong keys")
+	}
+}
+
+func TestKeys_RefreshJWT_Asymmetric(t *testing.T) {
+	signers := generateKeys(t)
+	now := time.Now()
+	claims := jwt.MapClaims{"iss": DefaultIssuer, "sub": "loc", "iat": now.Unix(), "exp": now.Add(10 * time.Second).Unix()}
+
+	tok, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(signers["RS256"])
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	// Without a signing key the token is not refreshed, even when there is an HMAC secret
+	keys := &Keys{Secret: []byte("secret"), PublicKeys: []crypto.PublicKey{signers["RS256"].Public()}}
+	tokenStr, parsed, err := keys.ValidateJWT("Bearer "+tok, "loc")
+	if err != nil {
+		t.Fatalf("ValidateJWT failed: %v", err)
+	}
+	if _, refreshed, err := keys.RefreshJWT(parsed, tokenStr, "loc", time.Minute, 5*time.Minute); err == nil || refreshed {
+		t.Fatalf("expected RefreshJWT to refuse an RS256 token without a signing key")
+	}
+
+	// With one it is re-signed by the signing key, and the refreshed token validates
+	keys.SigningKey = signers["EdDSA"]
+	newTok, refreshed, err := keys.RefreshJWT(parsed, tokenStr, "loc", time.Minute, 5*time.Minute)
+	if err != nil || !refreshed {
+		t.Fatalf("expected refresh, got refreshed=%v err=%v", refreshed, err)
+	}
+	_, parsed2, err := keys.ValidateJWT("Bearer "+newTok, "loc")
+	if err != nil {
+		t.Fatalf("refreshed token failed validation: %v", err)
+	}
+	if parsed2.Method.Alg() != "EdDSA" {
+		t.Fatalf("expected refreshed token signed with EdDSA, got %s", parsed2.Method.Alg())
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	for alg, signer := range generateKeys(t) {
+		der, err := x509.MarshalPKCS8PrivateKey(signer)
+		if err != nil {
+			t.Fatalf("%s: failed to marshal private key: %v", alg, err)
+		}
+		key, err := ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+		if err != nil {
+			t.Fatalf("%s: ParsePrivateKey failed: %v", alg, err)
+		}
+		if signingMethodFor(key).Alg() != alg {
+			t.Fatalf("%s: unexpected signing method %s", alg, signingMethodFor(key).Alg())
+		}
+	}
+
+	if _, err := ParsePrivateKey(publicKeyPEM(t, generateKeys(t)["ES256"])); err == nil {
+		t.Fatalf("expected ParsePrivateKey to reject a public key")
+	}
+}
+
+func TestKeys_SecretVersions(t *testing.T) {
+	current, previous := []byte("current-secret"), []byte("previous-secret")
+	keys := &Keys{Secret: current, SecretVersion: "v2", PreviousSecrets: map[string][]byte{"v1": previous}}
+
+	now := time.Now()
+	claims := jwt.MapClaims{"iss": DefaultIssuer, "sub": "loc", "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()}
+
+	sign := func(secret []byte, kid string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		s, err := token.SignedString(secret)
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		return s
+	}
+
+	// Tokens signed by a previous version validate only when their kid names it; a token without kid is verified by the
+	// current version alone
+	for _, tok := range []string{sign(previous, "v1"), sign(current, "v2"), sign(current, "")} {
+		if _, _, err := keys.ValidateJWT("Bearer "+tok, "loc"); err != nil {
+			t.Fatalf("ValidateJWT failed: %v", err)
+		}
+	}
+	for _, tok := range []string{sign(previous, "v2"), sign(current, "v1"), sign(current, "v0"), sign(previous, "")} {
+		if _, _, err := keys.ValidateJWT("Bearer "+tok, "loc"); err == nil {
+			t.Fatalf("expected ValidateJWT to reject a token whose kid names another version")
+		}
+	}
+
+	// A token signed by a previous version is re-signed with the current one at once, outside the refresh window
+	tokenStr, parsed, err := keys.ValidateJWT("Bearer "+sign(previous, "v1"), "loc")
+	if err != nil {
+		t.Fatalf("ValidateJWT failed: %v", err)
+	}
+	newTok, refreshed, err := keys.RefreshJWT(parsed, tokenStr, "loc", time.Minute, 2*time.Hour)
+	if err != nil || !refreshed {
+		t.Fatalf("expected refresh, got refreshed=%v err=%v", refreshed, err)
+	}
+	_, parsed2, err := (&Keys{Secret: current, SecretVersion: "v2"}).ValidateJWT("Bearer "+newTok, "loc")
+	if err != nil {
+		t.Fatalf("refreshed token failed validation with the current version: %v", err)
+	}
+	if parsed2.Header["kid"] != "v2" {
+		t.Fatalf("expected refreshed token to name v2, got %v", parsed2.Header["kid"])
+	}
+
+	// A token of the current version is left alone
+	tokenStr, parsed, err = keys.ValidateJWT("Bearer "+newTok, "loc")
+	if err != nil {
+		t.Fatalf("ValidateJWT failed: %v", err)
+	}
+	if _, refreshed, err := keys.RefreshJWT(parsed, tokenStr, "loc", time.Minute, 2*time.Hour); err != nil || refreshed {
+		t.Fatalf("expected no refresh, got refreshed=%v err=%v", refreshed, err)
+	}
+}
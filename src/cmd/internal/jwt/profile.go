@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+package jwt
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DefaultIssuer is the issuer tokens must name unless a profile says otherwise
+const DefaultIssuer = "webhook-executor"
+
+// Profile is what the claims of a valid token must satisfy, beyond a valid signature
+type Profile struct {
+
+	// Issuer is the value iss must have when present; empty accepts any issuer
+	Issuer string
+
+	// RequiredClaims lists the claims a token must carry
+	RequiredClaims []string
+
+	// Audiences, when set, requires aud to name at least one of them
+	Audiences []string
+
+	// MaxAge, when set, requires iat and rejects tokens issued longer ago
+	MaxAge time.Duration
+
+	// Leeway is the clock skew tolerated when checking exp, nbf and iat
+	Leeway time.Duration
+}
+
+// DefaultProfile returns the profile of tokens validated without one: iss is required and must be DefaultIssuer
+func DefaultProfile() *Profile {
+	return &Profile{Issuer: DefaultIssuer, RequiredClaims: []string{"iss"}}
+}
+
+// parserOptions returns the options that have the parser check exp and nbf, when present, and iat with the leeway
+func (profile *Profile) parserOptions(algorithms []string) []jwt.ParserOption {
+	return []jwt.ParserOption{
+		jwt.WithValidMethods(algorithms),
+		jwt.WithLeeway(profile.Leeway),
+		jwt.WithIssuedAt(),
+	}
+}
+
+// check checks the claims the parser does not
+func (profile *Profile) check(claims jwt.MapClaims, now time.Time) error {
+
+	for _, name := range profile.RequiredClaims {
+		if claims[name] == nil {
+			return fmt.Errorf("missing required claim %s", name)
+		}
+	}
+
+	if iss, ok := claims["iss"]; ok && profile.Issuer != "" && iss != profile.Issuer {
+		return fmt.Errorf("invalid issuer: expected '%s', got '%v'", profile.Issuer, iss)
+	}
+
+	if len(profile.Audiences) > 0 {
+		audiences, err := claims.GetAudience()
+		if err != nil {
+			return fmt.Errorf("invalid audience: %w", err)
+		}
+		if !slices.ContainsFunc(audiences, func(aud string) bool { return slices.Contains(profile.Audiences, aud) }) {
+			return fmt.Errorf("invalid audience: expected one of %v, got %v", profile.Audiences, audiences)
+		}
+	}
+
+	if profile.MaxAge > 0 {
+		iat, err := claims.GetIssuedAt()
+		if err != nil || iat == nil {
+			return fmt.Errorf("missing or invalid iat: required to check the token's age")
+		}
+		if age := now.Sub(iat.Time); age > profile.MaxAge+profile.Leeway {
+			return fmt.Errorf("token issued %s ago, more than the maximum age of %s", age.Round(time.Second), profile.MaxAge)
+		}
+	}
+
+	return nil
+}
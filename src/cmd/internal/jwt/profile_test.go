@@ -0,0 +1,63 @@
+package jwt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestProfile_ValidateJWT(t *testing.T) {
+	secret := []byte("profile-test-secret")
+	now := time.Now()
+
+	sign := func(claims jwt.MapClaims) string {
+		s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		return "Bearer " + s
+	}
+
+	profile := &Profile{
+		Issuer:         "ci",
+		RequiredClaims: []string{"iss", "exp"},
+		Audiences:      []string{"webhook-executor", "deploy"},
+		MaxAge:         time.Hour,
+		Leeway:         30 * time.Second,
+	}
+
+	tests := []struct {
+		name    string
+		profile *Profile
+		claims  jwt.MapClaims
+		valid   bool
+	}{
+		{"default profile accepts iss", nil, jwt.MapClaims{"iss": DefaultIssuer, "sub": "loc"}, true},
+		{"default profile rejects missing iss", nil, jwt.MapClaims{"sub": "loc"}, false},
+		{"default profile rejects another iss", nil, jwt.MapClaims{"iss": "ci", "sub": "loc"}, false},
+		{"valid", profile, jwt.MapClaims{"iss": "ci", "sub": "loc", "aud": []string{"deploy"}, "iat": now.Unix(), "exp": now.Add(time.Minute).Unix()}, true},
+		{"missing required claim", profile, jwt.MapClaims{"iss": "ci", "sub": "loc", "aud": "deploy", "iat": now.Unix()}, false},
+		{"unexpected audience", profile, jwt.MapClaims{"iss": "ci", "sub": "loc", "aud": "other", "iat": now.Unix(), "exp": now.Add(time.Minute).Unix()}, false},
+		{"missing audience", profile, jwt.MapClaims{"iss": "ci", "sub": "loc", "iat": now.Unix(), "exp": now.Add(time.Minute).Unix()}, false},
+		{"missing iat", profile, jwt.MapClaims{"iss": "ci", "sub": "loc", "aud": "deploy", "exp": now.Add(time.Minute).Unix()}, false},
+		{"too old", profile, jwt.MapClaims{"iss": "ci", "sub": "loc", "aud": "deploy", "iat": now.Add(-2 * time.Hour).Unix(), "exp": now.Add(time.Minute).Unix()}, false},
+		{"not yet valid", profile, jwt.MapClaims{"iss": "ci", "sub": "loc", "aud": "deploy", "iat": now.Unix(), "nbf": now.Add(time.Minute).Unix(), "exp": now.Add(time.Hour).Unix()}, false},
+		{"nbf within leeway", profile, jwt.MapClaims{"iss": "ci", "sub": "loc", "aud": "deploy", "iat": now.Unix(), "nbf": now.Add(10 * time.Second).Unix(), "exp": now.Add(time.Hour).Unix()}, true},
+		{"iat within leeway", profile, jwt.MapClaims{"iss": "ci", "sub": "loc", "aud": "deploy", "iat": now.Add(10 * time.Second).Unix(), "exp": now.Add(time.Hour).Unix()}, true},
+		{"expired within leeway", profile, jwt.MapClaims{"iss": "ci", "sub": "loc", "aud": "deploy", "iat": now.Add(-time.Minute).Unix(), "exp": now.Add(-10 * time.Second).Unix()}, true},
+		{"expired", profile, jwt.MapClaims{"iss": "ci", "sub": "loc", "aud": "deploy", "iat": now.Add(-time.Minute).Unix(), "exp": now.Add(-time.Minute).Unix()}, false},
+		{"no required claims", &Profile{}, jwt.MapClaims{"sub": "loc"}, true},
+	}
+
+	for _, tt := range tests {
+		keys := &Keys{Secret: secret, Profile: tt.profile}
+		_, _, err := keys.ValidateJWT(sign(tt.claims), "loc")
+		if tt.valid && err != nil {
+			t.Fatalf("%s: ValidateJWT failed: %v", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Fatalf("%s: expected ValidateJWT to fail", tt.name)
+		}
+	}
+}
@@ -3,38 +3,55 @@
 package jwt
 
 import (
-    "encoding/hex"
+    "errors"
     "fmt"
-    "strings"
     "time"
     "strconv"
 
     "github.com/golang-jwt/jwt/v5"
 )
 
-// RefreshJWT refreshes the incoming Authorization header token using the provided secret (hex-encoded).
+// OriginalIssuedAtClaim names the claim that records when the session a token belongs to began. It is carried across
+// refreshes, so that the session can be capped however often its token is refreshed.
+const OriginalIssuedAtClaim = "orig_iat"
+
+// ErrReauthenticationRequired is returned by RefreshJWT when the token's session is older than the maximum session age
+var ErrReauthenticationRequired = errors.New("reauthentication required")
+
+// RefreshJWT refreshes the provided parsed token using the provided secret (hex-encoded).
+// The parsed token must already have been validated (signature + claims) by `ValidateJWT`.
 // If the token is within tokenRefreshWindow of expiry (or already expired) it returns a newly signed token with
-// tokenTtl. The function mirrors the original token's "alg" header for signing when possible.
+// tokenTtl, signed with the original token's HMAC algorithm. Tokens signed with any other algorithm are not refreshed.
 //
 // Returns: (newToken, refreshed, error)
-// RefreshJWT refreshes the provided parsed token using the provided secret (hex-encoded).
+func RefreshJWT(parsed *jwt.Token, tokenStr string, secretHex string, expectedLocation string, tokenRefreshWindow, tokenTtl time.Duration) (string, bool, error) {
+
+    keys, err := HMACKeys(secretHex)
+    if err != nil {
+        return "", false, err
+    }
+
+    return keys.RefreshJWT(parsed, tokenStr, expectedLocation, tokenRefreshWindow, tokenTtl)
+}
+
+// RefreshJWT refreshes the provided parsed token using keys.
 // The parsed token must already have been validated (signature + claims) by `ValidateJWT`.
 // If the token is within tokenRefreshWindow of expiry (or already expired) it returns a newly signed token with
-// tokenTtl. The function mirrors the original token's "alg" header for signing when possible.
-
+// tokenTtl. HMAC tokens are re-signed with the current secret and their own algorithm, and are refreshed right away
+// when their kid does not name the current secret version; other tokens are re-signed with the signing key, and
+// refused when there is none.
+//
+// The refreshed token carries the time its session began as orig_iat: the token's own orig_iat, else its auth_time,
+// else its iat. When keys.MaxSessionAge is set, a token whose session is older, or that has none of these claims, is not
+// refreshed and ErrReauthenticationRequired is returned, and no refreshed token expires after its session ends.
+//
 // Returns: (newToken, refreshed, error)
-func RefreshJWT(parsed *jwt.Token, tokenStr string, secretHex string, expectedLocation string, tokenRefreshWindow, tokenTtl time.Duration) (string, bool, error) {
+func (keys *Keys) RefreshJWT(parsed *jwt.Token, tokenStr string, expectedLocation string, tokenRefreshWindow, tokenTtl time.Duration) (string, bool, error) {
 
     if parsed == nil {
         return "", false, fmt.Errorf("parsed token is nil")
     }
 
-    // Decode secret
-    secretBytes, err := hex.DecodeString(secretHex)
-    if err != nil {
-        return "", false, fmt.Errorf("invalid secret hex: %w", err)
-    }
-
     // Extract claims
     claims, ok := parsed.Claims.(jwt.MapClaims)
     if !ok {
@@ -48,33 +65,41 @@ func RefreshJWT(parsed *jwt.Token, tokenStr string, secretHex string, expectedLo
         }
     }
 
-    // determine algorithm from token header (fallback to HS512)
-    alg := "HS512"
-    if parsed.Method != nil {
-        alg = parsed.Method.Alg()
-    } else if v, ok := parsed.Header["alg"].(string); ok && v != "" {
-        alg = v
+    // choose the signing method and key: never sign with an algorithm other than the token's or the signing key's
+    method := parsed.Method
+    if method == nil {
+        if v, ok := parsed.Header["alg"].(string); ok {
+            method = jwt.GetSigningMethod(v)
+        }
     }
-    alg = strings.ToUpper(alg)
 
-    // compute remaining TTL
-    now := time.Now()
-    var expTime time.Time
-    if expRaw, ok := claims["exp"]; ok {
-        switch v := expRaw.(type) {
-        case float64:
-            expTime = time.Unix(int64(v), 0)
-        case int64:
-            expTime = time.Unix(v, 0)
-        case string:
-            if i, err := strconv.ParseInt(v, 10, 64); err == nil {
-                expTime = time.Unix(i, 0)
-            }
-        default:
-            // leave zero time
+    var signMethod jwt.SigningMethod
+    var signKey interface{}
+    var kid string
+    var stale bool
+
+    switch method.(type) {
+    case nil:
+        return "", false, fmt.Errorf("token has no signing method")
+    case *jwt.SigningMethodHMAC:
+        if len(keys.Secret) == 0 {
+            return "", false, fmt.Errorf("refusing to refresh %s token: no HMAC secret is configured", method.Alg())
+        }
+        signMethod, signKey, kid = method, keys.Secret, keys.SecretVersion
+        // a token that does not name the current secret version is re-signed with it right away
+        tokenKid, _ := parsed.Header["kid"].(string)
+        stale = keys.SecretVersion != "" && tokenKid != keys.SecretVersion
+    default:
+        if keys.SigningKey == nil {
+            return "", false, fmt.Errorf("refusing to refresh %s token: no signing key is configured", method.Alg())
         }
+        signMethod, signKey = signingMethodFor(keys.SigningKey), keys.SigningKey
     }
 
+    // compute remaining TTL
+    now := time.Now()
+    expTime, _ := numericDate(claims, "exp")
+
     ttlRemaining := time.Duration(0)
     if !expTime.IsZero() {
         ttlRemaining = expTime.Sub(now)
@@ -84,11 +109,37 @@ func RefreshJWT(parsed *jwt.Token, tokenStr string, secretHex string, expectedLo
     }
 
     // decide whether to refresh: if no exp or ttlRemaining <= tokenRefreshWindow
-    if !expTime.IsZero() && ttlRemaining > tokenRefreshWindow {
+    if !expTime.IsZero() && ttlRemaining > tokenRefreshWindow && !stale {
         // no refresh needed; return the original token so callers can include it
         return tokenStr, false, nil
     }
 
+    // the session began when the first token of it was issued; refuse to extend it beyond the maximum session age
+    sessionStart, ok := numericDate(claims, OriginalIssuedAtClaim)
+    if !ok {
+        sessionStart, ok = numericDate(claims, "auth_time")
+    }
+    if !ok {
+        sessionStart, ok = numericDate(claims, "iat")
+    }
+    if !ok && keys.MaxSessionAge > 0 {
+        return "", false, fmt.Errorf("%w: token has no %s, auth_time or iat to tell when its session began", ErrReauthenticationRequired, OriginalIssuedAtClaim)
+    }
+    if !ok {
+        sessionStart = now
+    }
+
+    newExp := now.Add(tokenTtl)
+    if keys.MaxSessionAge > 0 {
+        sessionEnd := sessionStart.Add(keys.MaxSessionAge)
+        if !now.Before(sessionEnd) {
+            return "", false, fmt.Errorf("%w: session began at %v, more than %s ago", ErrReauthenticationRequired, sessionStart, keys.MaxSessionAge)
+        }
+        if newExp.After(sessionEnd) {
+            newExp = sessionEnd
+        }
+    }
+
     // build new claims: copy existing claims except iat/exp
     newClaims := jwt.MapClaims{}
     for k, v := range claims {
@@ -98,27 +149,32 @@ func RefreshJWT(parsed *jwt.Token, tokenStr string, secretHex string, expectedLo
         newClaims[k] = v
     }
     newClaims["iat"] = now.Unix()
-    newClaims["exp"] = now.Add(tokenTtl).Unix()
-
-    // choose signing method matching alg
-    var signMethod jwt.SigningMethod
-    switch alg {
-    case "HS256":
-        signMethod = jwt.SigningMethodHS256
-    case "HS384":
-        signMethod = jwt.SigningMethodHS384
-    case "HS512":
-        signMethod = jwt.SigningMethodHS512
-    default:
-        // fallback to HS512
-        signMethod = jwt.SigningMethodHS512
-    }
+    newClaims["exp"] = newExp.Unix()
+    newClaims[OriginalIssuedAtClaim] = sessionStart.Unix()
 
     newToken := jwt.NewWithClaims(signMethod, newClaims)
-    signed, err := newToken.SignedString(secretBytes)
+    if kid != "" {
+        newToken.Header["kid"] = kid
+    }
+    signed, err := newToken.SignedString(signKey)
     if err != nil {
         return "", false, fmt.Errorf("failed to sign refreshed token: %w", err)
     }
 
     return signed, true, nil
 }
+
+// numericDate returns the time held by the NumericDate claim name, if claims has it
+func numericDate(claims jwt.MapClaims, name string) (time.Time, bool) {
+    switch v := claims[name].(type) {
+    case float64:
+        return time.Unix(int64(v), 0), true
+    case int64:
+        return time.Unix(v, 0), true
+    case string:
+        if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+            return time.Unix(i, 0), true
+        }
+    }
+    return time.Time{}, false
+}
@@ -3,6 +3,7 @@ package jwt
 import (
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"testing"
 	"time"
 
@@ -40,6 +41,7 @@ func TestRefreshJWT_NoRefresh(t *testing.T) {
 	// set exp well beyond refresh window
 	claims := jwt.MapClaims{
 		"sub": "location-a",
+		"iss": "webhook-executor",
 		"iat": now.Unix(),
 		"exp": now.Add(10 * time.Minute).Unix(),
 	}
@@ -69,6 +71,7 @@ func TestRefreshJWT_Refresh(t *testing.T) {
 	// set exp small so it triggers refresh (within window)
 	claims := jwt.MapClaims{
 		"sub":    "loc-1",
+		"iss":    "webhook-executor",
 		"iat":    now.Unix(),
 		"exp":    now.Add(10 * time.Second).Unix(),
 		"custom": "value",
@@ -127,6 +130,7 @@ func TestValidateJWT_InvalidSignature(t *testing.T) {
 	now := time.Now()
 	claims := jwt.MapClaims{
 		"sub": "x",
+		"iss": "webhook-executor",
 		"iat": now.Unix(),
 		"exp": now.Add(1 * time.Minute).Unix(),
 	}
@@ -160,6 +164,7 @@ func TestRefreshJWT_NoExp(t *testing.T) {
 	now := time.Now()
 	claims := jwt.MapClaims{
 		"sub": "noexp",
+		"iss": "webhook-executor",
 		"iat": now.Unix(),
 		"foo": "bar",
 	}
@@ -180,3 +185,70 @@ func TestRefreshJWT_NoExp(t *testing.T) {
 		t.Fatalf("expected non-empty refreshed token")
 	}
 }
+
+func TestRefreshJWT_MaxSessionAge(t *testing.T) {
+	secret := []byte("session-secret-xxxxxxxxxxxxxxxxxxxx")
+	keys := &Keys{Secret: secret, MaxSessionAge: time.Hour}
+
+	now := time.Now()
+	refresh := func(claims jwt.MapClaims) (jwt.MapClaims, error) {
+		tokenStr, parsed, err := keys.ValidateJWT("Bearer "+buildHMACToken(t, "HS256", secret, claims), "loc")
+		if err != nil {
+			t.Fatalf("ValidateJWT failed: %v", err)
+		}
+		newTok, refreshed, err := keys.RefreshJWT(parsed, tokenStr, "loc", time.Minute, 45*time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		if !refreshed {
+			t.Fatalf("expected refreshed=true")
+		}
+		_, parsed, err = keys.ValidateJWT("Bearer "+newTok, "loc")
+		if err != nil {
+			t.Fatalf("refreshed token failed validation: %v", err)
+		}
+		return parsed.Claims.(jwt.MapClaims), nil
+	}
+
+	// The first refresh records the token's iat as the start of the session, and later refreshes carry it
+	issued := now.Add(-40 * time.Minute).Unix()
+	claims, err := refresh(jwt.MapClaims{"iss": DefaultIssuer, "sub": "loc", "iat": issued, "exp": now.Add(10 * time.Second).Unix()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claims[OriginalIssuedAtClaim] != float64(issued) {
+		t.Fatalf("expected %s %d, got %v", OriginalIssuedAtClaim, issued, claims[OriginalIssuedAtClaim])
+	}
+	claims["exp"] = now.Add(10 * time.Second).Unix()
+	claims, err = refresh(claims)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claims[OriginalIssuedAtClaim] != float64(issued) {
+		t.Fatalf("expected %s %d to be carried, got %v", OriginalIssuedAtClaim, issued, claims[OriginalIssuedAtClaim])
+	}
+
+	// A refreshed token does not outlive its session
+	if exp := int64(claims["exp"].(float64)); exp > issued+int64(time.Hour/time.Second) {
+		t.Fatalf("expected exp no later than the end of the session, got %v", time.Unix(exp, 0))
+	}
+
+	// auth_time starts the session when there is no orig_iat
+	authTime := now.Add(-2 * time.Hour).Unix()
+	_, err = refresh(jwt.MapClaims{"iss": DefaultIssuer, "sub": "loc", "iat": now.Unix(), "auth_time": authTime, "exp": now.Add(10 * time.Second).Unix()})
+	if !errors.Is(err, ErrReauthenticationRequired) {
+		t.Fatalf("expected ErrReauthenticationRequired, got %v", err)
+	}
+
+	// A token that does not tell when its session began cannot start a new one
+	_, err = refresh(jwt.MapClaims{"iss": DefaultIssuer, "sub": "loc", "exp": now.Add(10 * time.Second).Unix()})
+	if !errors.Is(err, ErrReauthenticationRequired) {
+		t.Fatalf("expected ErrReauthenticationRequired, got %v", err)
+	}
+
+	// Once the session is over, refresh is refused however recently the token was issued
+	_, err = refresh(jwt.MapClaims{"iss": DefaultIssuer, "sub": "loc", "iat": now.Unix(), OriginalIssuedAtClaim: now.Add(-61 * time.Minute).Unix(), "exp": now.Add(10 * time.Second).Unix()})
+	if !errors.Is(err, ErrReauthenticationRequired) {
+		t.Fatalf("expected ErrReauthenticationRequired, got %v", err)
+	}
+}
@@ -3,7 +3,6 @@
 package jwt
 
 import (
-	"encoding/hex"
 	"fmt"
 	"strings"
 	"time"
@@ -16,6 +15,20 @@ import (
 // Returns: The raw token string and the parsed token on success.
 func ValidateJWT(authHeader string, secretHex string, expectedLocation string) (string, *jwt.Token, error) {
 
+	keys, err := HMACKeys(secretHex)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid authToken: token parsing failed: %w", err)
+	}
+
+	return keys.ValidateJWT(authHeader, expectedLocation)
+}
+
+// ValidateJWT checks the token using keys, their profile and the expected location. The token must be signed with one
+// of the accepted algorithms by a key of the type that algorithm needs.
+//
+// Returns: The raw token string and the parsed token on success.
+func (keys *Keys) ValidateJWT(authHeader string, expectedLocation string) (string, *jwt.Token, error) {
+
 	// Parse token
 
 	tokenStr := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
@@ -23,16 +36,12 @@ func ValidateJWT(authHeader string, secretHex string, expectedLocation string) (
 		return "", nil, fmt.Errorf("invalid authToken: missing or empty value")
 	}
 
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		secretBytes, err := hex.DecodeString(secretHex)
-		if err != nil {
-			return nil, err
-		}
-		return secretBytes, nil
-	})
+	profile := keys.Profile
+	if profile == nil {
+		profile = DefaultProfile()
+	}
+
+	token, err := jwt.Parse(tokenStr, keys.verificationKey, profile.parserOptions(keys.algorithms())...)
 
 	if err != nil {
 		return "", nil, fmt.Errorf("invalid authToken: token parsing failed: %w", err)
@@ -45,15 +54,8 @@ func ValidateJWT(authHeader string, secretHex string, expectedLocation string) (
 	// Check claims
 
 	if claims, ok := token.Claims.(jwt.MapClaims); ok {
-		if exp, ok := claims["exp"].(float64); ok {
-			if time.Now().After(time.Unix(int64(exp), 0)) {
-				return "", nil, fmt.Errorf("invalid authToken: token expired at %v", time.Unix(int64(exp), 0))
-			}
-		}
-		if iss, ok := claims["iss"].(string); ok {
-			if iss != "webhook-executor" {
-				return "", nil, fmt.Errorf("invalid authToken: invalid issuer: expected 'webhook-executor', got '%s'", iss)
-			}
+		if err := profile.check(claims, time.Now()); err != nil {
+			return "", nil, fmt.Errorf("invalid authToken: %w", err)
 		}
 		if expectedLocation != "" {
 			if sub, ok := claims["sub"].(string); !ok || sub != expectedLocation {
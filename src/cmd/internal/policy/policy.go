@@ -0,0 +1,237 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+
+// Package policy decides which destinations and commands a token's subject may use
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/NobleFactor/docker-webhook/cmd/internal/sshremote"
+)
+
+// Rule grants the subjects it lists, and the holders of the roles it lists, permission to run the commands it lists on
+// the destinations it lists.
+//
+// Subjects are matched against the token's sub claim and may contain path.Match wildcards; roles are matched exactly
+// against the token's roles claim. A destination entry is either a CIDR, which matches a destination whose host is an
+// IP address in that range, or a glob, which matches the destination as given or the host it dials. Because a glob's *
+// does not match /, local:// and docker:// destinations are only allowed by entries that name their scheme, such as
+// docker://web-* or local:///srv/*. A command entry that starts with ^ and ends with $ is a regular expression the
+// whole command must match; any other entry must equal the command.
+type Rule struct {
+	Name         string   `json:"name"`
+	Subjects     []string `json:"subjects"`
+	Roles        []string `json:"roles"`
+	Destinations []string `json:"destinations"`
+	Commands     []string `json:"commands"`
+
+	networks []*net.IPNet
+	globs    []string
+	exact    map[string]bool
+	patterns []*regexp.Regexp
+}
+
+// Policy is an ordered list of rules. A request is allowed when any rule allows it.
+type Policy struct {
+	Rules []*Rule `json:"rules"`
+}
+
+// Request is what a token's holder asks to do. ConfigDirectory is $WEBHOOK_CONFIG, whose ssh/config resolves SSH
+// destinations and their ProxyJump hosts.
+type Request struct {
+	Subject         string
+	Roles           []string
+	Destination     string
+	Command         string
+	ConfigDirectory string
+}
+
+// ForbiddenError reports a request that no rule allows
+type ForbiddenError struct {
+	Request Request
+	Problem string
+}
+
+func (e *ForbiddenError) Error() string {
+	return fmt.Sprintf("subject %q may not run %q on %s: %s", e.Request.Subject, e.Request.Command, e.Request.Destination, e.Problem)
+}
+
+// Load reads the policy file at policyPath. A missing file yields a policy without rules, which denies everything.
+func Load(policyPath string) (*Policy, error) {
+
+	data, err := os.ReadFile(policyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Policy{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy: %v", err)
+	}
+
+	var policy Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse policy %s: %v", policyPath, err)
+	}
+
+	for i, rule := range policy.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("invalid policy %s: %s: %v", policyPath, rule.Name, err)
+		}
+	}
+
+	return &policy, nil
+}
+
+func (rule *Rule) compile() error {
+
+	for _, subject := range rule.Subjects {
+		if _, err := path.Match(subject, ""); err != nil {
+			return fmt.Errorf("invalid subject pattern %s: %v", subject, err)
+		}
+	}
+
+	for _, destination := range rule.Destinations {
+		if strings.Contains(destination, "/") && !strings.Contains(destination, "://") {
+			_, network, err := net.ParseCIDR(destination)
+			if err != nil {
+				return fmt.Errorf("invalid destination CIDR %s: %v", destination, err)
+			}
+			rule.networks = append(rule.networks, network)
+			continue
+		}
+		if _, err := path.Match(destination, ""); err != nil {
+			return fmt.Errorf("invalid destination pattern %s: %v", destination, err)
+		}
+		rule.globs = append(rule.globs, destination)
+	}
+
+	rule.exact = map[string]bool{}
+
+	for _, command := range rule.Commands {
+		if strings.HasPrefix(command, "^") && strings.HasSuffix(command, "$") {
+			pattern, err := regexp.Compile(command)
+			if err != nil {
+				return fmt.Errorf("invalid command pattern %s: %v", command, err)
+			}
+			rule.patterns = append(rule.patterns, pattern)
+			continue
+		}
+		rule.exact[command] = true
+	}
+
+	return nil
+}
+
+// Authorize returns the name of the first rule that allows request, or a *ForbiddenError when none does. A nil
+// policy allows every request. Jump hosts, whether named in the destination's ?jump= query or by ProxyJump, must be
+// allowed as destinations too.
+func (p *Policy) Authorize(request Request) (string, error) {
+
+	if p == nil {
+		return "", nil
+	}
+
+	hosts, err := destinationHosts(request.Destination, request.ConfigDirectory)
+	if err != nil {
+		return "", &ForbiddenError{Request: request, Problem: err.Error()}
+	}
+
+	problem := "no rule applies to the subject"
+
+	for _, rule := range p.Rules {
+		if !rule.appliesTo(request.Subject, request.Roles) {
+			continue
+		}
+		if !rule.allowsDestination(hosts) {
+			problem = "destination not allowed"
+			continue
+		}
+		if !rule.allowsCommand(request.Command) {
+			problem = "command not allowed"
+			continue
+		}
+		return rule.Name, nil
+	}
+
+	return "", &ForbiddenError{Request: request, Problem: problem}
+}
+
+func (rule *Rule) appliesTo(subject string, roles []string) bool {
+	for _, pattern := range rule.Subjects {
+		if matched, _ := path.Match(pattern, subject); matched && subject != "" {
+			return true
+		}
+	}
+	for _, role := range roles {
+		for _, allowed := range rule.Roles {
+			if role == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allowsDestination reports whether every host the destination reaches, including its jump hosts, is allowed
+func (rule *Rule) allowsDestination(hosts []sshremote.Host) bool {
+	for _, host := range hosts {
+		if !rule.allowsHost(host.Given, host.Host) {
+			return false
+		}
+	}
+	return true
+}
+
+func (rule *Rule) allowsHost(given, host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		for _, network := range rule.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+	for _, glob := range rule.globs {
+		if matched, _ := path.Match(glob, given); matched {
+			return true
+		}
+		if matched, _ := path.Match(glob, host); matched && host != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *Rule) allowsCommand(command string) bool {
+	if rule.exact[command] {
+		return true
+	}
+	for _, pattern := range rule.patterns {
+		if pattern.MatchString(command) {
+			return true
+		}
+	}
+	return false
+}
+
+// destinationHosts returns the target of a destination string followed by its jump hosts. SSH destinations are resolved
+// by sshremote, against configDirectory's ssh/config, so that the hosts authorized are the hosts the executor dials.
+func destinationHosts(destination string, configDirectory string) ([]sshremote.Host, error) {
+
+	destination = strings.TrimSpace(destination)
+
+	if strings.HasPrefix(destination, "local://") || strings.HasPrefix(destination, "docker://") {
+		return []sshremote.Host{{Given: destination}}, nil
+	}
+
+	return sshremote.ResolveHosts(destination, configDirectory)
+}
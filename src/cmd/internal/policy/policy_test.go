@@ -0,0 +1,127 @@
+package policy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testPolicy = `{
+  "rules": [
+    {
+      "name": "ci-deploy",
+      "subjects": ["ci-*"],
+      "roles": ["deployer"],
+      "destinations": ["deploy@web-*", "10.0.0.0/24", "bastion", "docker://web-*", "local:///srv/*"],
+      "commands": ["uptime", "^docker compose -f /srv/app/compose\\.yml (up -d|ps)$"]
+    },
+    {
+      "name": "ops",
+      "subjects": ["ops"],
+      "destinations": ["*"],
+      "commands": ["^.*$"]
+    }
+  ]
+}`
+
+func writePolicy(t *testing.T, content string) string {
+	t.Helper()
+	policyPath := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(policyPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write policy: %v", err)
+	}
+	return policyPath
+}
+
+func TestAuthorize(t *testing.T) {
+	policy, err := Load(writePolicy(t, testPolicy))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	configDirectory := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(configDirectory, "ssh"), 0o700); err != nil {
+		t.Fatalf("failed to create ssh directory: %v", err)
+	}
+	sshConfig := "Host web-proxied\n    ProxyJump elsewhere\nHost web-aliased\n    HostName 10.0.0.9\n"
+	if err := os.WriteFile(filepath.Join(configDirectory, "ssh", "config"), []byte(sshConfig), 0o600); err != nil {
+		t.Fatalf("failed to write ssh config: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		request  Request
+		wantRule string
+		wantErr  string
+	}{
+		{name: "subject glob and exact command", request: Request{Subject: "ci-github", Destination: "deploy@web-1", Command: "uptime"}, wantRule: "ci-deploy"},
+		{name: "role and anchored regex", request: Request{Subject: "someone", Roles: []string{"deployer"}, Destination: "ssh://deploy@web-2:2222", Command: "docker compose -f /srv/app/compose.yml up -d"}, wantRule: "ci-deploy"},
+		{name: "CIDR", request: Request{Subject: "ci-github", Destination: "root@10.0.0.7", Command: "uptime"}, wantRule: "ci-deploy"},
+		{name: "allowed jump host", request: Request{Subject: "ci-github", Destination: "ssh://deploy@web-3?jump=bastion", Command: "uptime"}, wantRule: "ci-deploy"},
+		{name: "docker container", request: Request{Subject: "ci-github", Destination: "docker://web-1", Command: "uptime"}, wantRule: "ci-deploy"},
+		{name: "local directory", request: Request{Subject: "ci-github", Destination: "local:///srv/app", Command: "uptime"}, wantRule: "ci-deploy"},
+		{name: "later rule", request: Request{Subject: "ops", Destination: "db-1", Command: "reboot"}, wantRule: "ops"},
+		{name: "unknown subject", request: Request{Subject: "intruder", Destination: "deploy@web-1", Command: "uptime"}, wantErr: "no rule applies to the subject"},
+		{name: "missing subject", request: Request{Destination: "deploy@web-1", Command: "uptime"}, wantErr: "no rule applies to the subject"},
+		{name: "destination outside the globs", request: Request{Subject: "ci-github", Destination: "root@web-1", Command: "uptime"}, wantErr: "destination not allowed"},
+		{name: "address outside the CIDR", request: Request{Subject: "ci-github", Destination: "10.0.1.7", Command: "uptime"}, wantErr: "destination not allowed"},
+		{name: "container outside the globs", request: Request{Subject: "ci-github", Destination: "docker://db-1", Command: "uptime"}, wantErr: "destination not allowed"},
+		{name: "bare glob excludes other schemes", request: Request{Subject: "ops", Destination: "local://", Command: "reboot"}, wantErr: "destination not allowed"},
+		{name: "jump host not allowed", request: Request{Subject: "ci-github", Destination: "ssh://deploy@web-3?jump=elsewhere", Command: "uptime"}, wantErr: "destination not allowed"},
+		{name: "fragment hides the dialed host", request: Request{Subject: "ci-github", Destination: "ssh://evil.example#@bastion", Command: "uptime"}, wantErr: `invalid ssh URI "ssh://evil.example#@bastion": want ssh://[user@]host[:port][?jump=host[,host...]]`},
+		{name: "path hides the dialed host", request: Request{Subject: "ci-github", Destination: "ssh://evil.example/@bastion", Command: "uptime"}, wantErr: `invalid ssh URI "ssh://evil.example/@bastion": want ssh://[user@]host[:port][?jump=host[,host...]]`},
+		{name: "ssh config jump host not allowed", request: Request{Subject: "ci-github", Destination: "deploy@web-proxied", Command: "uptime"}, wantErr: "destination not allowed"},
+		{name: "ssh config host name in CIDR", request: Request{Subject: "ci-github", Destination: "web-aliased", Command: "uptime"}, wantRule: "ci-deploy"},
+		{name: "command not exact", request: Request{Subject: "ci-github", Destination: "deploy@web-1", Command: "uptime; rm -rf /"}, wantErr: "command not allowed"},
+		{name: "regex is anchored", request: Request{Subject: "ci-github", Destination: "deploy@web-1", Command: "docker compose -f /srv/app/compose.yml ps && id"}, wantErr: "command not allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.request.ConfigDirectory = configDirectory
+			rule, err := policy.Authorize(tt.request)
+			if tt.wantErr != "" {
+				var forbidden *ForbiddenError
+				if !errors.As(err, &forbidden) || forbidden.Problem != tt.wantErr {
+					t.Fatalf("want forbidden with %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil || rule != tt.wantRule {
+				t.Fatalf("want rule %q, got %q (err %v)", tt.wantRule, rule, err)
+			}
+		})
+	}
+}
+
+func TestLoad_Missing(t *testing.T) {
+	policy, err := Load(filepath.Join(t.TempDir(), "policy.json"))
+	if err != nil || policy == nil || len(policy.Rules) != 0 {
+		t.Fatalf("want an empty policy and no error, got %v, %v", policy, err)
+	}
+	var forbidden *ForbiddenError
+	if _, err := policy.Authorize(Request{Subject: "anyone", Destination: "anywhere", Command: "anything"}); !errors.As(err, &forbidden) {
+		t.Fatalf("want a missing policy to deny everything, got %v", err)
+	}
+}
+
+func TestAuthorize_NilPolicy(t *testing.T) {
+	var policy *Policy
+	if _, err := policy.Authorize(Request{Subject: "anyone", Destination: "anywhere", Command: "anything"}); err != nil {
+		t.Fatalf("want a nil policy to allow everything, got %v", err)
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	for _, content := range []string{
+		`{"rules": [`,
+		`{"rules": [{"destinations": ["10.0.0.0/33"]}]}`,
+		`{"rules": [{"commands": ["^(unclosed$"]}]}`,
+		`{"rules": [{"subjects": ["[bad"]}]}`,
+	} {
+		if _, err := Load(writePolicy(t, content)); err == nil {
+			t.Fatalf("want error for %s", content)
+		}
+	}
+}
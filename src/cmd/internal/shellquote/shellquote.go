@@ -0,0 +1,22 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+
+// Package shellquote quotes strings as POSIX shell words
+package shellquote
+
+import (
+	"regexp"
+	"strings"
+)
+
+// unquoted matches the values that the shell reads literally without quotes
+var unquoted = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// Quote returns s as a single POSIX shell word. Values that need no quoting are returned as they are; any other value
+// is wrapped in single quotes, and each single quote inside it is closed, escaped and reopened.
+func Quote(s string) string {
+	if unquoted.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
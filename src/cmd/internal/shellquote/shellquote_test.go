@@ -0,0 +1,21 @@
+package shellquote
+
+import "testing"
+
+func TestQuote(t *testing.T) {
+	tests := map[string]string{
+		"web":              "web",
+		"v1.2.3":           "v1.2.3",
+		"":                 "''",
+		"two words":        "'two words'",
+		"it's":             `'it'\''s'`,
+		"$HOME":            "'$HOME'",
+		"a;b":              "'a;b'",
+		"/srv/app/key=val": "/srv/app/key=val",
+	}
+	for input, want := range tests {
+		if got := Quote(input); got != want {
+			t.Errorf("Quote(%q) = %s, want %s", input, got, want)
+		}
+	}
+}
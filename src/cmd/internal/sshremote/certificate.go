@@ -0,0 +1,164 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+package sshremote
+
+import (
+    "crypto/ed25519"
+    "crypto/rand"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "os"
+    "time"
+
+    "golang.org/x/crypto/ssh"
+)
+
+// loadCertificate loads the OpenSSH user certificate that accompanies the identity at keyPath (keyPath-cert.pub), if
+// there is one, and returns a signer that presents it. It returns nil when there is no certificate.
+func loadCertificate(keyPath string, signer ssh.Signer) (ssh.Signer, string, error) {
+
+    certPath := keyPath + "-cert.pub"
+
+    certBytes, err := os.ReadFile(certPath)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil, "", nil
+    }
+    if err != nil {
+        return nil, "", fmt.Errorf("failed to read certificate: %v", err)
+    }
+
+    publicKey, _, _, _, err := ssh.ParseAuthorizedKey(certBytes)
+    if err != nil {
+        return nil, "", fmt.Errorf("failed to parse certificate %s: %v", certPath, err)
+    }
+
+    cert, ok := publicKey.(*ssh.Certificate)
+    if !ok || cert.CertType != ssh.UserCert {
+        return nil, "", fmt.Errorf("failed to parse certificate %s: not an OpenSSH user certificate", certPath)
+    }
+
+    certSigner, err := ssh.NewCertSigner(cert, signer)
+    if err != nil {
+        return nil, "", fmt.Errorf("failed to load certificate %s: %v", certPath, err)
+    }
+
+    return certSigner, certPath, nil
+}
+
+// CertificateAuthority signs short-lived OpenSSH user certificates
+type CertificateAuthority struct {
+    signer ssh.Signer
+}
+
+// LoadCertificateAuthority loads the certificate authority private key at keyPath. Encrypted keys are decrypted with
+// the passphrase returned by passphrase.
+func LoadCertificateAuthority(keyPath string, passphrase PassphraseFunc) (*CertificateAuthority, error) {
+
+    keyBytes, err := os.ReadFile(keyPath)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read certificate authority key: %v", err)
+    }
+
+    signer, err := parsePrivateKey(keyPath, keyBytes, passphrase)
+    if err != nil {
+        return nil, err
+    }
+
+    return &CertificateAuthority{signer: signer}, nil
+}
+
+// CertificateRequest describes a per-request user certificate. When ForceCommand is set the certificate can run
+// nothing but that command, unless PermitPty is set it cannot allocate a PTY, and unless PermitPortForwarding is set
+// it cannot open tunnels.
+//
+// The server runs a forced command in place of whatever the client sends, and ignores the variables set over the
+// session unless AcceptEnv lists them, so the forced command is run under env(1) with Environment, exactly as
+// ExecuteRemoteCommand sends it. A certificate with a forced command cannot start the SFTP subsystem.
+type CertificateRequest struct {
+    KeyId                string
+    Principals           []string
+    ForceCommand         string
+    Environment          map[string]string
+    PermitPty            bool
+    PermitPortForwarding bool
+    Lifetime             time.Duration
+}
+
+// UseEphemeralCertificate replaces the identities offered to destination with a freshly generated key and a user
+// certificate for it, signed by authority and valid for request.Lifetime. Jump hosts keep their own identities.
+func (destination *Destination) UseEphemeralCertificate(authority *CertificateAuthority, request CertificateRequest) error {
+
+    if len(request.Principals) == 0 {
+        return fmt.Errorf("failed to issue certificate: no principals")
+    }
+    if request.Lifetime <= 0 {
+        return fmt.Errorf("failed to issue certificate: lifetime must be positive")
+    }
+
+    publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil {
+        return fmt.Errorf("failed to generate ephemeral key: %v", err)
+    }
+
+    signer, err := ssh.NewSignerFromKey(privateKey)
+    if err != nil {
+        return fmt.Errorf("failed to generate ephemeral key: %v", err)
+    }
+
+    sshPublicKey, err := ssh.NewPublicKey(publicKey)
+    if err != nil {
+        return fmt.Errorf("failed to generate ephemeral key: %v", err)
+    }
+
+    var serial [8]byte
+    if _, err := rand.Read(serial[:]); err != nil {
+        return fmt.Errorf("failed to generate certificate serial: %v", err)
+    }
+
+    // Back-date the start of the validity period by a minute to tolerate clock skew with the destination
+
+    now := time.Now()
+
+    cert := &ssh.Certificate{
+        Key:             sshPublicKey,
+        Serial:          binary.BigEndian.Uint64(serial[:]),
+        CertType:        ssh.UserCert,
+        KeyId:           request.KeyId,
+        ValidPrincipals: request.Principals,
+        ValidAfter:      uint64(now.Add(-time.Minute).Unix()),
+        ValidBefore:     uint64(now.Add(request.Lifetime).Unix()),
+    }
+
+    if request.ForceCommand != "" {
+        cert.CriticalOptions = map[string]string{"force-command": environmentCommand(request.ForceCommand, request.Environment)}
+    }
+
+    cert.Extensions = map[string]string{}
+    if request.PermitPty {
+        cert.Extensions["permit-pty"] = ""
+    }
+    if request.PermitPortForwarding {
+        cert.Extensions["permit-port-forwarding"] = ""
+    }
+
+    if err := cert.SignCert(rand.Reader, authority.signer); err != nil {
+        return fmt.Errorf("failed to sign certificate: %v", err)
+    }
+
+    certSigner, err := ssh.NewCertSigner(cert, signer)
+    if err != nil {
+        return fmt.Errorf("failed to sign certificate: %v", err)
+    }
+
+    identity := &identitySigner{Signer: certSigner, path: "ephemeral certificate " + request.KeyId, used: destination.identity}
+    if identity.used == nil {
+        identity.used = new(string)
+        destination.identity = identity.used
+    }
+
+    destination.ClientConfig.Auth = []ssh.AuthMethod{ssh.PublicKeys(identity)}
+    destination.identities = []string{identity.path}
+    destination.commandForced = request.ForceCommand != ""
+    return nil
+}
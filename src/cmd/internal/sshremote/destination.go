@@ -6,7 +6,6 @@ import (
     "fmt"
     "net"
     urlpkg "net/url"
-    "os"
     "os/user"
     "path/filepath"
     "strings"
@@ -15,108 +14,344 @@ import (
     "golang.org/x/crypto/ssh"
 )
 
-// ParseSshDestination parses an SSH destination and returns (address, *ssh.ClientConfig, error)
-func ParseSshDestination(destination string, configDirectory string) (string, *ssh.ClientConfig, error) {
+// Destination is an SSH destination resolved against the ssh_config, keys and known_hosts in WEBHOOK_CONFIG. Jumps
+// lists the bastion hosts, in connection order, through which Address is reached.
+type Destination struct {
+    Name                string
+    Address             string
+    ClientConfig        *ssh.ClientConfig
+    ServerAliveInterval time.Duration
+    Jumps               []*Destination
 
-    username, address, err := parseDestination(strings.TrimSpace(destination))
+    // Paths of the identity files offered, in order, and of the one that authenticated, set once the connection is
+    // established
+    identities []string
+    identity   *string
+
+    // Whether the destination authenticates with a certificate whose forced command sets the environment
+    commandForced bool
+}
+
+// ParseSshDestination parses an SSH destination and resolves it against $WEBHOOK_CONFIG/ssh/config the way the ssh CLI
+// would. Values given in the destination string take precedence over those from the ssh_config file.
+//
+// Every identity listed by IdentityFile and each of id_ed25519, id_ecdsa and id_rsa found in $WEBHOOK_CONFIG/ssh is
+// offered in that order. Encrypted private keys are decrypted with the passphrase returned by passphrase.
+//
+// Jump hosts come from the destination's jump query parameter (ssh://user@target?jump=bastion1,bastion2) or else
+// from ProxyJump. Each jump host is resolved the same way as the destination, except that its own ProxyJump is not
+// followed.
+func ParseSshDestination(destination string, configDirectory string, passphrase PassphraseFunc) (*Destination, error) {
+    return resolveDestination(strings.TrimSpace(destination), configDirectory, passphrase, true)
+}
+
+// Resolve destination and, when followJumps is true, its jump hosts
+func resolveDestination(destination string, configDirectory string, passphrase PassphraseFunc, followJumps bool) (*Destination, error) {
+
+    spec, hostConfig, err := resolveSpec(destination, configDirectory)
     if err != nil {
-        return "", nil, err
+        return nil, err
     }
 
-    // Load private key
+    username, host, port := spec.username, spec.host, spec.port
 
-    keyPath := filepath.Join(configDirectory, "ssh", "id_rsa")
-    keyBytes, err := os.ReadFile(keyPath)
+    // Apply ssh_config Host blocks
 
+    if hostConfig.HostName != "" {
+        host = hostConfig.HostName
+    }
+    if username == "" {
+        username = hostConfig.User
+    }
+    if username == "" {
+        if username, err = currentUsername(); err != nil {
+            return nil, err
+        }
+    }
+    if port == "" {
+        port = hostConfig.Port
+    }
+    if port == "" {
+        port = defaultPort
+    }
+
+    // Load private keys
+
+    sshDirectory := filepath.Join(configDirectory, "ssh")
+
+    identityFiles := make([]string, 0, len(hostConfig.IdentityFiles))
+    for _, identityFile := range hostConfig.IdentityFiles {
+        keyPath, err := expandIdentityFile(identityFile, sshDirectory, host, username)
+        if err != nil {
+            return nil, err
+        }
+        identityFiles = append(identityFiles, keyPath)
+    }
+
+    identities, err := loadIdentities(identityFiles, sshDirectory, passphrase)
     if err != nil {
-        return "", nil, fmt.Errorf("failed to read private key: %v", err)
+        return nil, err
+    }
+
+    used := new(string)
+    signers := make([]ssh.Signer, 0, len(identities))
+    paths := make([]string, 0, len(identities))
+    for _, identity := range identities {
+        identity.used = used
+        signers = append(signers, identity)
+        paths = append(paths, identity.path)
     }
 
-    signer, err := ssh.ParsePrivateKey(keyBytes)
+    // Load known hosts
+
+    hostKeyCallback, err := newHostKeyCallback(filepath.Join(sshDirectory, "known_hosts"))
     if err != nil {
-        return "", nil, fmt.Errorf("failed to parse private key: %v", err)
+        return nil, err
+    }
+
+    timeout := 10 * time.Second
+    if hostConfig.ConnectTimeout > 0 {
+        timeout = hostConfig.ConnectTimeout
     }
 
     config := &ssh.ClientConfig{
         User:            username,
-        Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
-        HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-        Timeout:         10 * time.Second,
+        Auth:            []ssh.AuthMethod{ssh.PublicKeys(signers...)},
+        HostKeyCallback: hostKeyCallback,
+        Timeout:         timeout,
     }
 
-    return address, config, nil
+    resolved := &Destination{
+        Name:                destination,
+        Address:             net.JoinHostPort(host, port),
+        ClientConfig:        config,
+        ServerAliveInterval: hostConfig.ServerAliveInterval,
+        identities:          paths,
+        identity:            used,
+    }
+
+    // Resolve jump hosts
+
+    if !followJumps {
+        return resolved, nil
+    }
+
+    for _, jump := range spec.jumpsOr(hostConfig) {
+        hop, err := resolveDestination(jumpUri(jump), configDirectory, passphrase, false)
+        if err != nil {
+            return nil, fmt.Errorf("invalid jump host %q: %v", jump, err)
+        }
+        hop.Name = jump
+        resolved.Jumps = append(resolved.Jumps, hop)
+    }
+
+    return resolved, nil
+}
+
+// Host is a host an SSH destination reaches. Given is the host as written, [user@]host[:port] without any ssh://
+// scheme or query, and Host is the name or address that is dialed once ssh_config HostName is applied.
+type Host struct {
+    Given string
+    Host  string
 }
 
-// Parse destination with support for plain form ([user@]host or [user@]host:port) and URI form (ssh://[user@]host[:port]).
-func parseDestination(destination string) (string, string, error) {
+// ResolveHosts resolves destination the way ParseSshDestination does, without loading any keys, and returns the host it
+// reaches followed by its jump hosts in connection order. Authorizing these hosts authorizes every host that
+// ParseSshDestination would have the executor dial.
+func ResolveHosts(destination string, configDirectory string) ([]Host, error) {
+
+    spec, hostConfig, err := resolveSpec(strings.TrimSpace(destination), configDirectory)
+    if err != nil {
+        return nil, err
+    }
+
+    hosts := []Host{spec.resolvedHost(hostConfig)}
+
+    for _, jump := range spec.jumpsOr(hostConfig) {
+        hop, hopConfig, err := resolveSpec(jumpUri(jump), configDirectory)
+        if err != nil {
+            return nil, fmt.Errorf("invalid jump host %q: %v", jump, err)
+        }
+        hosts = append(hosts, hop.resolvedHost(hopConfig))
+    }
+
+    return hosts, nil
+}
+
+// Split destination and look up the ssh_config Host blocks that apply to it
+func resolveSpec(destination string, configDirectory string) (destinationSpec, hostConfig, error) {
+
+    spec, err := splitDestination(destination)
+    if err != nil {
+        return destinationSpec{}, hostConfig{}, err
+    }
+
+    sshConfig, err := loadSshConfig(filepath.Join(configDirectory, "ssh", "config"))
+    if err != nil {
+        return destinationSpec{}, hostConfig{}, err
+    }
+
+    config, err := sshConfig.resolve(spec.host)
+    if err != nil {
+        return destinationSpec{}, hostConfig{}, err
+    }
+
+    return spec, config, nil
+}
+
+// Return the jump hosts given in the destination or else those from ProxyJump
+func (spec destinationSpec) jumpsOr(config hostConfig) []string {
+    if spec.jumps != nil {
+        return spec.jumps
+    }
+    return config.ProxyJump
+}
+
+// Return the host spec names and the one it dials
+func (spec destinationSpec) resolvedHost(config hostConfig) Host {
+    host := spec.host
+    if config.HostName != "" {
+        host = config.HostName
+    }
+    return Host{Given: spec.given, Host: host}
+}
+
+// Jump hosts use the ProxyJump form [user@]host[:port], which parses as an ssh URI
+func jumpUri(jump string) string {
+    if strings.HasPrefix(jump, "ssh://") {
+        return jump
+    }
+    return "ssh://" + jump
+}
+
+// Identities returns the paths of the identity files offered to destination, in the order they are offered
+func (destination *Destination) Identities() []string {
+    return append([]string(nil), destination.identities...)
+}
+
+// authenticatedIdentity returns the path of the identity file that authenticated to destination, if known
+func (destination *Destination) authenticatedIdentity() *string {
+    if destination.identity == nil || *destination.identity == "" {
+        return nil
+    }
+    identity := *destination.identity
+    return &identity
+}
+
+const defaultPort = "22"
+
+// destinationSpec holds the parts of a destination string. Fields the destination does not specify are left empty.
+// Given is [user@]host[:port] as parsed, without any scheme or query.
+type destinationSpec struct {
+    given    string
+    username string
+    host     string
+    port     string
+    jumps    []string
+}
+
+// Split destination into its user, host, port and jump hosts
+func splitDestination(destination string) (destinationSpec, error) {
 
     destination = strings.TrimSpace(destination)
     if destination == "" {
-        return "", "", fmt.Errorf("invalid ssh destination: empty")
+        return destinationSpec{}, fmt.Errorf("invalid ssh destination: empty")
     }
 
-    var username, host, port string
-    const defaultPort = "22"
+    var given, username, host, port string
+    var jumps []string
 
     if strings.HasPrefix(destination, "ssh://") {
 
-        // URI form: ssh://[user@]hostname[:port]
+        // URI form: ssh://[user@]hostname[:port][?jump=host[,host...]]
 
         url, err := urlpkg.Parse(destination)
         if err != nil {
-            return "", "", fmt.Errorf("invalid ssh URI %q: %v", destination, err)
+            return destinationSpec{}, fmt.Errorf("invalid ssh URI %q: %v", destination, err)
         }
 
-        if url.User != nil && url.User.Username() != "" {
-            username = url.User.Username()
-        } else {
-            currentUser, err := user.Current()
-            if err != nil {
-                return "", "", fmt.Errorf("unable to determine current user: %v", err)
+        // Anything url.Parse would set aside, such as a path or fragment, could hide a host from a reader who splits
+        // the string differently, so only a user, host, port and jump query are accepted
+
+        if url.Opaque != "" || url.Path != "" || url.Fragment != "" || strings.Contains(destination, "#") {
+            return destinationSpec{}, fmt.Errorf("invalid ssh URI %q: want ssh://[user@]host[:port][?jump=host[,host...]]", destination)
+        }
+
+        if url.User != nil {
+            if _, hasPassword := url.User.Password(); hasPassword {
+                return destinationSpec{}, fmt.Errorf("invalid ssh URI: passwords are not supported")
             }
-            username = currentUser.Username
+            username = url.User.Username()
         }
 
         host = url.Hostname()
         port = url.Port()
 
         if host == "" {
-            return "", "", fmt.Errorf("invalid ssh URI: missing host")
+            return destinationSpec{}, fmt.Errorf("invalid ssh URI: missing host")
+        }
+
+        query := url.Query()
+        for key := range query {
+            if key != "jump" {
+                return destinationSpec{}, fmt.Errorf("invalid ssh URI: unknown query parameter %q", key)
+            }
+        }
+
+        given = url.Host
+        if username != "" {
+            given = username + "@" + given
         }
 
-        if port == "" {
-            port = defaultPort
+        if value := query.Get("jump"); value != "" {
+            for _, jump := range strings.Split(value, ",") {
+                if jump = strings.TrimSpace(jump); jump == "" {
+                    return destinationSpec{}, fmt.Errorf("invalid ssh URI: empty jump host")
+                }
+                jumps = append(jumps, jump)
+            }
         }
 
     } else {
 
         // Plain form: [user@]host
 
+        if strings.ContainsAny(destination, "/?#") {
+            return destinationSpec{}, fmt.Errorf("invalid ssh destination %q: want [user@]host or an ssh:// URI", destination)
+        }
+
+        given = destination
+
         atIndex := strings.Index(destination, "@")
 
         if atIndex != -1 {
             username = destination[:atIndex]
             host = destination[atIndex+1:]
             if username == "" {
-                return "", "", fmt.Errorf("invalid ssh destination: empty username")
+                return destinationSpec{}, fmt.Errorf("invalid ssh destination: empty username")
             }
             if host == "" {
-                return "", "", fmt.Errorf("invalid ssh destination: empty host")
+                return destinationSpec{}, fmt.Errorf("invalid ssh destination: empty host")
             }
-        } else {
-            currentUser, err := user.Current()
-            if err != nil {
-                return "", "", fmt.Errorf("unable to determine current user: %v", err)
+            if strings.Contains(host, "@") {
+                return destinationSpec{}, fmt.Errorf("invalid ssh destination %q: more than one @", destination)
             }
-            username = currentUser.Username
+        } else {
             host = destination
             if host == "" {
-                return "", "", fmt.Errorf("invalid ssh destination: empty host")
+                return destinationSpec{}, fmt.Errorf("invalid ssh destination: empty host")
             }
         }
-
-        port = defaultPort
     }
 
-    return username, net.JoinHostPort(host, port), nil
+    return destinationSpec{given: given, username: username, host: host, port: port, jumps: jumps}, nil
+}
+
+// Return the name of the user running the executor
+func currentUsername() (string, error) {
+    currentUser, err := user.Current()
+    if err != nil {
+        return "", fmt.Errorf("unable to determine current user: %v", err)
+    }
+    return currentUser.Username, nil
 }
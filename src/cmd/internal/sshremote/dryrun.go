@@ -0,0 +1,27 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+package sshremote
+
+// DryRun reports what a request would have run on one destination. It is returned in place of running the command.
+type DryRun struct {
+
+    // Backend is ssh, local or docker
+    Backend string `json:"backend"`
+
+    // Address, User, Identities and Jumps describe an SSH destination as resolved. Identities lists the identity files
+    // in the order they would be offered; Jumps lists the address of each jump host.
+    Address    string   `json:"address,omitempty"`
+    User       string   `json:"user,omitempty"`
+    Identities []string `json:"identities,omitempty"`
+    Jumps      []string `json:"jumps,omitempty"`
+
+    // Directory is the working directory of a local command and Container the container of a docker command
+    Directory string `json:"directory,omitempty"`
+    Container string `json:"container,omitempty"`
+
+    Command    string `json:"command"`
+    PolicyRule string `json:"policyRule,omitempty"`
+
+    // Handshake is true when the destination was reached: over SSH, connected, host key verified and authenticated
+    Handshake bool `json:"handshake"`
+}
@@ -0,0 +1,56 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+package sshremote
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path"
+    "strings"
+)
+
+// ExitReasons maps a command's exit codes to the reason phrases reported for them
+type ExitReasons map[int]string
+
+// Reason returns the reason phrase for exitCode, preferring the phrase in reasons to that of the default table
+func (reasons ExitReasons) Reason(exitCode int) string {
+    if reason, ok := reasons[exitCode]; ok {
+        return reason
+    }
+    return getExitReason(exitCode)
+}
+
+// ExitReasonTable maps program names, such as rsync, to the exit reasons of that program
+type ExitReasonTable map[string]ExitReasons
+
+// LoadExitReasonTable reads the JSON exit reason table at tablePath, an object mapping program names to objects that
+// map exit codes to reason phrases, for example {"rsync": {"23": "Partial Transfer"}}. A missing file yields an empty
+// table.
+func LoadExitReasonTable(tablePath string) (ExitReasonTable, error) {
+
+    data, err := os.ReadFile(tablePath)
+    if errors.Is(err, os.ErrNotExist) {
+        return ExitReasonTable{}, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to read exit reasons: %v", err)
+    }
+
+    var table ExitReasonTable
+    if err := json.Unmarshal(data, &table); err != nil {
+        return nil, fmt.Errorf("failed to parse exit reasons %s: %v", tablePath, err)
+    }
+
+    return table, nil
+}
+
+// For returns the exit reasons of the program command runs, named by the base name of its first word
+func (t ExitReasonTable) For(command string) ExitReasons {
+    fields := strings.Fields(command)
+    if len(fields) == 0 {
+        return nil
+    }
+    return t[path.Base(fields[0])]
+}
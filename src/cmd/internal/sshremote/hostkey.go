@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+package sshremote
+
+import (
+    "errors"
+    "fmt"
+    "net"
+    "os"
+
+    "golang.org/x/crypto/ssh"
+    "golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyError reports a host key that could not be verified against known_hosts. It carries the fingerprint of the
+// key the server presented so that operators can tell a MITM or re-imaged host apart from an authentication failure.
+type HostKeyError struct {
+    Hostname    string
+    KeyType     string
+    Fingerprint string
+    Err         error
+}
+
+func (e *HostKeyError) Error() string {
+    return fmt.Sprintf("host key verification failed for %s: %s (presented %s key %s)", e.Hostname, e.problem(), e.KeyType, e.Fingerprint)
+}
+
+func (e *HostKeyError) Unwrap() error {
+    return e.Err
+}
+
+// problem returns a short description of why the presented key was not accepted
+func (e *HostKeyError) problem() string {
+
+    var keyErr *knownhosts.KeyError
+    var revokedErr *knownhosts.RevokedError
+
+    switch {
+    case errors.As(e.Err, &revokedErr):
+        return fmt.Sprintf("key is revoked (%s:%d)", revokedErr.Revoked.Filename, revokedErr.Revoked.Line)
+    case errors.As(e.Err, &keyErr) && len(keyErr.Want) == 0:
+        return "host is not listed in known_hosts"
+    case errors.As(e.Err, &keyErr):
+        want := keyErr.Want[0]
+        return fmt.Sprintf("key does not match %s:%d", want.Filename, want.Line)
+    default:
+        return e.Err.Error()
+    }
+}
+
+// newHostKeyCallback returns a host key callback backed by the OpenSSH known_hosts file at knownHostsPath. Hashed
+// entries and the @cert-authority and @revoked markers are honored. Verification failures are reported as *HostKeyError.
+func newHostKeyCallback(knownHostsPath string) (ssh.HostKeyCallback, error) {
+
+    if _, err := os.Stat(knownHostsPath); err != nil {
+        return nil, fmt.Errorf("failed to read known_hosts: %v", err)
+    }
+
+    callback, err := knownhosts.New(knownHostsPath)
+    if err != nil {
+        return nil, fmt.Errorf("failed to parse known_hosts: %v", err)
+    }
+
+    return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+        if err := callback(hostname, remote, key); err != nil {
+            return &HostKeyError{
+                Hostname:    hostname,
+                KeyType:     key.Type(),
+                Fingerprint: ssh.FingerprintSHA256(key),
+                Err:         err,
+            }
+        }
+        return nil
+    }, nil
+}
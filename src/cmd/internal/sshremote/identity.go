@@ -0,0 +1,140 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+package sshremote
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+
+    "golang.org/x/crypto/ssh"
+)
+
+// PassphraseFunc returns the passphrase that decrypts the private key at keyPath
+type PassphraseFunc func(keyPath string) ([]byte, error)
+
+// defaultIdentityFiles lists the identities discovered in $WEBHOOK_CONFIG/ssh in the order they are offered
+var defaultIdentityFiles = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// loadIdentities loads the explicitly listed identity files followed by the default identities present in
+// sshDirectory. A listed file that does not exist is an error; a missing default identity is skipped. An identity with
+// an OpenSSH user certificate beside it (for example id_ed25519-cert.pub) is offered with the certificate first.
+func loadIdentities(explicit []string, sshDirectory string, passphrase PassphraseFunc) ([]*identitySigner, error) {
+
+    var identities []*identitySigner
+    seen := map[string]bool{}
+
+    load := func(keyPath string, required bool) error {
+        if seen[keyPath] {
+            return nil
+        }
+        seen[keyPath] = true
+
+        keyBytes, err := os.ReadFile(keyPath)
+        if errors.Is(err, os.ErrNotExist) && !required {
+            return nil
+        }
+        if err != nil {
+            return fmt.Errorf("failed to read private key: %v", err)
+        }
+
+        signer, err := parsePrivateKey(keyPath, keyBytes, passphrase)
+        if err != nil {
+            return err
+        }
+
+        // Like the ssh CLI, offer the identity's certificate before the bare key
+
+        certSigner, certPath, err := loadCertificate(keyPath, signer)
+        if err != nil {
+            return err
+        }
+        if certSigner != nil {
+            identities = append(identities, &identitySigner{Signer: certSigner, path: certPath})
+        }
+
+        identities = append(identities, &identitySigner{Signer: signer, path: keyPath})
+        return nil
+    }
+
+    for _, keyPath := range explicit {
+        if err := load(keyPath, true); err != nil {
+            return nil, err
+        }
+    }
+
+    for _, name := range defaultIdentityFiles {
+        if err := load(filepath.Join(sshDirectory, name), false); err != nil {
+            return nil, err
+        }
+    }
+
+    if len(identities) == 0 {
+        return nil, fmt.Errorf("failed to read private key: no identities found in %s", sshDirectory)
+    }
+
+    return identities, nil
+}
+
+// parsePrivateKey parses a private key, asking passphrase for the passphrase when the key is encrypted
+func parsePrivateKey(keyPath string, keyBytes []byte, passphrase PassphraseFunc) (ssh.Signer, error) {
+
+    signer, err := ssh.ParsePrivateKey(keyBytes)
+
+    var missingErr *ssh.PassphraseMissingError
+    if !errors.As(err, &missingErr) {
+        if err != nil {
+            return nil, fmt.Errorf("failed to parse private key %s: %v", keyPath, err)
+        }
+        return signer, nil
+    }
+
+    if passphrase == nil {
+        return nil, fmt.Errorf("failed to parse private key %s: key is encrypted and no passphrase source is configured", keyPath)
+    }
+
+    secret, err := passphrase(keyPath)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get passphrase for private key %s: %v", keyPath, err)
+    }
+
+    signer, err = ssh.ParsePrivateKeyWithPassphrase(keyBytes, secret)
+    if err != nil {
+        return nil, fmt.Errorf("failed to decrypt private key %s: %v", keyPath, err)
+    }
+
+    return signer, nil
+}
+
+// identitySigner wraps the signer for an identity file and records the identity's path when it signs an
+// authentication request. Because the client offers identities in order and stops at the first one the server
+// accepts, the last identity to sign is the one that authenticated.
+type identitySigner struct {
+    ssh.Signer
+    path string
+    used *string
+}
+
+func (s *identitySigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+    s.record()
+    return s.Signer.Sign(rand, data)
+}
+
+func (s *identitySigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+    s.record()
+    if algorithmSigner, ok := s.Signer.(ssh.AlgorithmSigner); ok {
+        return algorithmSigner.SignWithAlgorithm(rand, data, algorithm)
+    }
+    if algorithm != "" && algorithm != s.Signer.PublicKey().Type() {
+        return nil, fmt.Errorf("ssh: identity %s does not support signature algorithm %s", s.path, algorithm)
+    }
+    return s.Signer.Sign(rand, data)
+}
+
+func (s *identitySigner) record() {
+    if s.used != nil {
+        *s.used = s.path
+    }
+}
@@ -0,0 +1,240 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+package sshremote
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "sync"
+    "unicode/utf8"
+)
+
+// OutputLimit bounds the bytes retained from one output stream: the first Head bytes and the last Tail bytes are kept
+// and everything in between is dropped. The zero value retains everything.
+type OutputLimit struct {
+    Head int
+    Tail int
+}
+
+func (limit OutputLimit) unbounded() bool {
+    return limit.Head <= 0 && limit.Tail <= 0
+}
+
+// outputBuffer captures an output stream within an OutputLimit. It may be read while the session is still writing.
+type outputBuffer struct {
+    mutex sync.Mutex
+    limit OutputLimit
+    head  []byte
+    tail  []byte
+    total int64
+}
+
+func newOutputBuffer(limit OutputLimit) *outputBuffer {
+    return &outputBuffer{limit: limit}
+}
+
+func (b *outputBuffer) Write(p []byte) (int, error) {
+
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+
+    b.total += int64(len(p))
+
+    if b.limit.unbounded() {
+        b.head = append(b.head, p...)
+        return len(p), nil
+    }
+
+    data := p
+
+    if room := b.limit.Head - len(b.head); room > 0 {
+        n := min(room, len(data))
+        b.head = append(b.head, data[:n]...)
+        data = data[n:]
+    }
+
+    if len(data) == 0 || b.limit.Tail <= 0 {
+        return len(p), nil
+    }
+
+    // Keep the last Tail bytes, compacting only when the slice has grown to twice the limit
+
+    b.tail = append(b.tail, data...)
+    if len(b.tail) > 2*b.limit.Tail {
+        b.tail = append(b.tail[:0], b.tail[len(b.tail)-b.limit.Tail:]...)
+    }
+
+    return len(p), nil
+}
+
+// Len returns the number of bytes written to the stream, including any that were dropped
+func (b *outputBuffer) Len() int64 {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    return b.total
+}
+
+// Truncated reports whether any bytes were dropped
+func (b *outputBuffer) Truncated() bool {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    return b.truncated()
+}
+
+func (b *outputBuffer) truncated() bool {
+    return b.total > int64(len(b.head)+b.retainedTail())
+}
+
+func (b *outputBuffer) retainedTail() int {
+    return min(len(b.tail), max(b.limit.Tail, 0))
+}
+
+// String returns the retained output. When bytes were dropped a marker stating how many separates the head from the
+// tail, and neither side ends with a partial UTF-8 sequence.
+func (b *outputBuffer) String() string {
+
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+
+    tail := b.tail[len(b.tail)-b.retainedTail():]
+
+    if !b.truncated() {
+        return string(b.head) + string(tail)
+    }
+
+    head := trimPartialRuneSuffix(b.head)
+    tail = trimPartialRunePrefix(tail)
+    dropped := b.total - int64(len(head)+len(tail))
+
+    return fmt.Sprintf("%s\n... [%d bytes truncated] ...\n%s", head, dropped, tail)
+}
+
+// OutputFunc receives output from a running command as it arrives. Stream is "stdout" or "stderr". The stdout and
+// stderr streams are delivered from different goroutines.
+type OutputFunc func(stream string, data []byte)
+
+// Capture collects a command's output the way ExecuteRemoteCommand does, so that every execution backend fills a
+// Response alike. Each stream is retained within its ExecuteOptions limit and forwarded to ExecuteOptions.Output as it
+// arrives.
+type Capture struct {
+    Stdout io.Writer
+    Stderr io.Writer
+
+    stdin   []byte
+    stdout  *outputBuffer
+    stderr  *outputBuffer
+    streams []*streamWriter
+}
+
+// NewCapture returns a Capture for a command run under options
+func NewCapture(options ExecuteOptions) *Capture {
+
+    capture := &Capture{
+        stdin:  options.Stdin,
+        stdout: newOutputBuffer(options.StdoutLimit),
+        stderr: newOutputBuffer(options.StderrLimit),
+    }
+    capture.Stdout, capture.Stderr = capture.stdout, capture.stderr
+
+    if options.Output != nil {
+        stdoutStream := &streamWriter{stream: "stdout", output: options.Output}
+        stderrStream := &streamWriter{stream: "stderr", output: options.Output}
+        capture.Stdout = io.MultiWriter(capture.stdout, stdoutStream)
+        capture.Stderr = io.MultiWriter(capture.stderr, stderrStream)
+        capture.streams = []*streamWriter{stdoutStream, stderrStream}
+    }
+
+    return capture
+}
+
+// Fill flushes any output still held back from the Output function and records the retained output, the byte counts
+// and the digest of the command's stdin in response. Call it once the command has finished writing.
+func (c *Capture) Fill(response *Response) {
+
+    for _, stream := range c.streams {
+        stream.flush()
+    }
+
+    if stdout := c.stdout.String(); stdout != "" {
+        response.Stdout = &stdout
+    }
+    if stderr := c.stderr.String(); stderr != "" {
+        response.Stderr = &stderr
+    }
+
+    response.StdoutBytes, response.StderrBytes = c.stdout.Len(), c.stderr.Len()
+    response.StdoutTruncated, response.StderrTruncated = c.stdout.Truncated(), c.stderr.Truncated()
+
+    if c.stdin != nil {
+        response.StdinSha256 = stdinDigest(c.stdin)
+    }
+}
+
+// stdinDigest returns the hex SHA-256 digest of a command's stdin
+func stdinDigest(stdin []byte) string {
+    digest := sha256.Sum256(stdin)
+    return hex.EncodeToString(digest[:])
+}
+
+// streamWriter forwards output to an OutputFunc, holding back an incomplete UTF-8 sequence until the rest arrives
+type streamWriter struct {
+    mutex   sync.Mutex
+    stream  string
+    output  OutputFunc
+    pending []byte
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+
+    w.mutex.Lock()
+    defer w.mutex.Unlock()
+
+    data := append(w.pending, p...)
+    complete := trimPartialRuneSuffix(data)
+    w.pending = append([]byte(nil), data[len(complete):]...)
+
+    if len(complete) > 0 {
+        w.output(w.stream, complete)
+    }
+
+    return len(p), nil
+}
+
+// flush forwards any output still held back
+func (w *streamWriter) flush() {
+
+    w.mutex.Lock()
+    defer w.mutex.Unlock()
+
+    if len(w.pending) > 0 {
+        w.output(w.stream, w.pending)
+        w.pending = nil
+    }
+}
+
+// trimPartialRuneSuffix drops an incomplete UTF-8 sequence from the end of b
+func trimPartialRuneSuffix(b []byte) []byte {
+    for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+        if utf8.RuneStart(b[i]) {
+            if !utf8.FullRune(b[i:]) {
+                return b[:i]
+            }
+            break
+        }
+    }
+    return b
+}
+
+// trimPartialRunePrefix drops UTF-8 continuation bytes from the start of b
+func trimPartialRunePrefix(b []byte) []byte {
+    i := 0
+    for i < len(b) && i < utf8.UTFMax && !utf8.RuneStart(b[i]) {
+        i++
+    }
+    if i == utf8.UTFMax {
+        return b
+    }
+    return b[i:]
+}
@@ -0,0 +1,118 @@
+package sshremote
+
+import (
+    "strings"
+    "sync"
+    "testing"
+)
+
+func TestOutputBuffer(t *testing.T) {
+    tests := []struct {
+        name          string
+        limit         OutputLimit
+        writes        []string
+        want          string
+        wantTruncated bool
+    }{
+        {name: "unbounded", writes: []string{"hello ", "world"}, want: "hello world"},
+        {name: "within limit", limit: OutputLimit{Head: 4, Tail: 8}, writes: []string{"hello ", "world"}, want: "hello world"},
+        {name: "head and tail", limit: OutputLimit{Head: 4, Tail: 3}, writes: []string{"0123", "456789", "abc"}, want: "0123\n... [6 bytes truncated] ...\nabc", wantTruncated: true},
+        {name: "head only", limit: OutputLimit{Head: 2}, writes: []string{"0123456789"}, want: "01\n... [8 bytes truncated] ...\n", wantTruncated: true},
+        {name: "tail only", limit: OutputLimit{Tail: 2}, writes: []string{"01234", "56789"}, want: "\n... [8 bytes truncated] ...\n89", wantTruncated: true},
+        {name: "tail compaction", limit: OutputLimit{Head: 1, Tail: 2}, writes: strings.Split("abcdefghij", ""), want: "a\n... [7 bytes truncated] ...\nij", wantTruncated: true},
+        {name: "partial runes dropped", limit: OutputLimit{Head: 2, Tail: 2}, writes: []string{"é", "xxxx", "é"}, want: "é\n... [4 bytes truncated] ...\né", wantTruncated: true},
+        {name: "split runes trimmed", limit: OutputLimit{Head: 1, Tail: 1}, writes: []string{"éxxé"}, want: "\n... [6 bytes truncated] ...\n", wantTruncated: true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            buffer := newOutputBuffer(tt.limit)
+            total := 0
+            for _, write := range tt.writes {
+                buffer.Write([]byte(write))
+                total += len(write)
+            }
+            if got := buffer.String(); got != tt.want {
+                t.Fatalf("string: want %q, got %q", tt.want, got)
+            }
+            if buffer.Truncated() != tt.wantTruncated {
+                t.Fatalf("truncated: want %v, got %v", tt.wantTruncated, buffer.Truncated())
+            }
+            if buffer.Len() != int64(total) {
+                t.Fatalf("len: want %d, got %d", total, buffer.Len())
+            }
+        })
+    }
+}
+
+func TestExecuteRemoteCommand_OutputLimits(t *testing.T) {
+    server := startTestServer(t, func(session *testSession) uint32 {
+        session.Write([]byte(strings.Repeat("o", 1000)))
+        session.Stderr().Write([]byte("short"))
+        return 0
+    })
+
+    options := ExecuteOptions{StdoutLimit: OutputLimit{Head: 10, Tail: 10}, StderrLimit: OutputLimit{Head: 10, Tail: 10}}
+    response := ExecuteRemoteCommand(newTestDestination(t, server), "chatty", options)
+
+    if !response.StdoutTruncated || response.StdoutBytes != 1000 {
+        t.Fatalf("stdout: want truncated 1000 bytes, got truncated %v bytes %d", response.StdoutTruncated, response.StdoutBytes)
+    }
+    if response.Stdout == nil || !strings.Contains(*response.Stdout, "[980 bytes truncated]") {
+        t.Fatalf("stdout: want truncation marker, got %q", deref(response.Stdout))
+    }
+    if response.StderrTruncated || response.StderrBytes != 5 || deref(response.Stderr) != "short" {
+        t.Fatalf("stderr: unexpected capture %q truncated %v bytes %d", deref(response.Stderr), response.StderrTruncated, response.StderrBytes)
+    }
+}
+
+func TestStreamWriter_HoldsBackPartialRunes(t *testing.T) {
+    var chunks []string
+    w := &streamWriter{stream: "stdout", output: func(stream string, data []byte) {
+        chunks = append(chunks, stream+":"+string(data))
+    }}
+
+    euro := []byte("€")
+    w.Write(append([]byte("a"), euro[:1]...))
+    w.Write(euro[1:])
+    w.Write([]byte{0xe2})
+    w.flush()
+
+    want := []string{"stdout:a", "stdout:€", "stdout:\xe2"}
+    if len(chunks) != len(want) {
+        t.Fatalf("want chunks %q, got %q", want, chunks)
+    }
+    for i := range want {
+        if chunks[i] != want[i] {
+            t.Fatalf("want chunks %q, got %q", want, chunks)
+        }
+    }
+}
+
+func TestExecuteRemoteCommand_StreamsOutput(t *testing.T) {
+    server := startTestServer(t, func(session *testSession) uint32 {
+        session.Write([]byte("out"))
+        session.Stderr().Write([]byte("err"))
+        return 0
+    })
+
+    var mutex sync.Mutex
+    streamed := map[string]string{}
+    options := ExecuteOptions{Output: func(stream string, data []byte) {
+        mutex.Lock()
+        defer mutex.Unlock()
+        streamed[stream] += string(data)
+    }}
+
+    response := ExecuteRemoteCommand(newTestDestination(t, server), "talk", options)
+
+    if response.Status != 0 {
+        t.Fatalf("want status 0, got %d: %s", response.Status, deref(response.Error))
+    }
+    if streamed["stdout"] != "out" || streamed["stderr"] != "err" {
+        t.Fatalf("want streamed stdout %q and stderr %q, got %q", "out", "err", streamed)
+    }
+    if deref(response.Stdout) != "out" || deref(response.Stderr) != "err" {
+        t.Fatalf("streaming must not replace the captured output, got stdout %q stderr %q", deref(response.Stdout), deref(response.Stderr))
+    }
+}
@@ -0,0 +1,80 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+package sshremote
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+
+    "golang.org/x/crypto/ssh"
+)
+
+// PtyOptions describes the pseudo-terminal requested for a command. Under a PTY the command's stdout and stderr are
+// the same terminal, so all of its output arrives as stdout.
+type PtyOptions struct {
+    Term   string
+    Width  int
+    Height int
+    Modes  ssh.TerminalModes
+}
+
+// DefaultTerminalModes turns off echo, so that stdin is not copied into the output, and sets a nominal line speed
+var DefaultTerminalModes = ssh.TerminalModes{
+    ssh.ECHO:          0,
+    ssh.TTY_OP_ISPEED: 14400,
+    ssh.TTY_OP_OSPEED: 14400,
+}
+
+// terminalModes maps the mnemonics of RFC 4254 section 8 to their opcodes
+var terminalModes = map[string]uint8{
+    "VINTR": ssh.VINTR, "VQUIT": ssh.VQUIT, "VERASE": ssh.VERASE, "VKILL": ssh.VKILL, "VEOF": ssh.VEOF,
+    "VEOL": ssh.VEOL, "VEOL2": ssh.VEOL2, "VSTART": ssh.VSTART, "VSTOP": ssh.VSTOP, "VSUSP": ssh.VSUSP,
+    "VDSUSP": ssh.VDSUSP, "VREPRINT": ssh.VREPRINT, "VWERASE": ssh.VWERASE, "VLNEXT": ssh.VLNEXT,
+    "VFLUSH": ssh.VFLUSH, "VSWTCH": ssh.VSWTCH, "VSTATUS": ssh.VSTATUS, "VDISCARD": ssh.VDISCARD,
+    "IGNPAR": ssh.IGNPAR, "PARMRK": ssh.PARMRK, "INPCK": ssh.INPCK, "ISTRIP": ssh.ISTRIP, "INLCR": ssh.INLCR,
+    "IGNCR": ssh.IGNCR, "ICRNL": ssh.ICRNL, "IUCLC": ssh.IUCLC, "IXON": ssh.IXON, "IXANY": ssh.IXANY,
+    "IXOFF": ssh.IXOFF, "IMAXBEL": ssh.IMAXBEL, "IUTF8": ssh.IUTF8, "ISIG": ssh.ISIG, "ICANON": ssh.ICANON,
+    "XCASE": ssh.XCASE, "ECHO": ssh.ECHO, "ECHOE": ssh.ECHOE, "ECHOK": ssh.ECHOK, "ECHONL": ssh.ECHONL,
+    "NOFLSH": ssh.NOFLSH, "TOSTOP": ssh.TOSTOP, "IEXTEN": ssh.IEXTEN, "ECHOCTL": ssh.ECHOCTL,
+    "ECHOKE": ssh.ECHOKE, "PENDIN": ssh.PENDIN, "OPOST": ssh.OPOST, "OLCUC": ssh.OLCUC, "ONLCR": ssh.ONLCR,
+    "OCRNL": ssh.OCRNL, "ONOCR": ssh.ONOCR, "ONLRET": ssh.ONLRET, "CS7": ssh.CS7, "CS8": ssh.CS8,
+    "PARENB": ssh.PARENB, "PARODD": ssh.PARODD, "TTY_OP_ISPEED": ssh.TTY_OP_ISPEED,
+    "TTY_OP_OSPEED": ssh.TTY_OP_OSPEED,
+}
+
+// ParseTerminalModes parses a comma-separated list of NAME=value terminal modes, such as ECHO=0,ICRNL=1, over the
+// defaults in DefaultTerminalModes. Names are the mnemonics of RFC 4254 section 8 and are case-insensitive.
+func ParseTerminalModes(s string) (ssh.TerminalModes, error) {
+
+    modes := ssh.TerminalModes{}
+    for opcode, value := range DefaultTerminalModes {
+        modes[opcode] = value
+    }
+
+    for _, item := range strings.Split(s, ",") {
+        item = strings.TrimSpace(item)
+        if item == "" {
+            continue
+        }
+
+        name, value, ok := strings.Cut(item, "=")
+        if !ok {
+            return nil, fmt.Errorf("invalid terminal mode %q: want NAME=value", item)
+        }
+
+        opcode, ok := terminalModes[strings.ToUpper(strings.TrimSpace(name))]
+        if !ok {
+            return nil, fmt.Errorf("unknown terminal mode %s", name)
+        }
+
+        n, err := strconv.ParseUint(strings.TrimSpace(value), 10, 32)
+        if err != nil {
+            return nil, fmt.Errorf("invalid value for terminal mode %s: %s", name, value)
+        }
+
+        modes[opcode] = uint32(n)
+    }
+
+    return modes, nil
+}
@@ -4,8 +4,16 @@ package sshremote
 
 import (
     "bytes"
+    "errors"
     "fmt"
+    "io"
+    "net"
+    "sort"
+    "strings"
+    "time"
 
+    "github.com/NobleFactor/docker-webhook/cmd/internal/dockerapi"
+    "github.com/NobleFactor/docker-webhook/cmd/internal/shellquote"
     "golang.org/x/crypto/ssh"
 )
 
@@ -28,8 +36,6 @@ func getExitReason(exitCode int) string {
         return "Terminated by Signal"
     case 137:
         return "Killed by Signal"
-    case 255:
-        return "SSH Connection/Authorization Failed"
     default:
         return fmt.Sprintf("Exit Code %d", exitCode)
     }
@@ -38,67 +44,204 @@ func getExitReason(exitCode int) string {
 // Response mirrors the JSON output structure
 
 type Response struct {
-    Status        int     `json:"status"`
-    Reason        string  `json:"reason"`
-    Stdout        *string `json:"stdout"`
-    Stderr        *string `json:"stderr"`
-    Error         *string `json:"error"`
-    AuthToken     *string `json:"authToken,omitempty"`
-    CorrelationId string  `json:"correlationId"`
+    Status          int               `json:"status"`
+    Reason          string            `json:"reason"`
+    Stdout          *string           `json:"stdout"`
+    Stderr          *string           `json:"stderr"`
+    StdoutBytes     int64             `json:"stdoutBytes"`
+    StderrBytes     int64             `json:"stderrBytes"`
+    StdoutTruncated bool              `json:"stdoutTruncated"`
+    StderrTruncated bool              `json:"stderrTruncated"`
+    Error           *string           `json:"error"`
+    TimedOut        bool              `json:"timedOut"`
+    Signal          string            `json:"signal,omitempty"`
+    ExitMessage     string            `json:"exitMessage,omitempty"`
+    Identity        *string           `json:"identity,omitempty"`
+    Destination     string            `json:"destination,omitempty"`
+    StdinSha256     string            `json:"stdinSha256,omitempty"`
+    StderrMerged    bool              `json:"stderrMerged"`
+    Transfers       []TransferResult  `json:"transfers,omitempty"`
+    Docker          *dockerapi.Report `json:"docker,omitempty"`
+    DryRun          *DryRun           `json:"dryRun,omitempty"`
+    Attempts        int               `json:"attempts"`
+    AttemptErrors   []string          `json:"attemptErrors,omitempty"`
+    AuthToken       *string           `json:"authToken,omitempty"`
+    Reauthenticate  bool              `json:"reauthenticate,omitempty"`
+    CorrelationId   string            `json:"correlationId"`
 }
 
+// ExecuteOptions controls how ExecuteRemoteCommand runs a command
+type ExecuteOptions struct {
+
+    // Timeout bounds the command's run time. When it expires the command is sent SIGTERM, then SIGKILL after
+    // KillAfter, and the output collected so far is returned. Zero means no limit.
+    Timeout time.Duration
+
+    // KillAfter is how long to wait after each signal before escalating. Zero means five seconds.
+    KillAfter time.Duration
+
+    // StdoutLimit and StderrLimit bound the output retained from each stream
+    StdoutLimit OutputLimit
+    StderrLimit OutputLimit
+
+    // Output, when set, receives the command's output as it arrives, regardless of the retention limits
+    Output OutputFunc
+
+    // Environment holds variables to set in the command's environment. Variables the server refuses (see AcceptEnv in
+    // sshd_config) are passed by running the command under env(1) instead.
+    Environment map[string]string
+
+    // Retry controls how often a failed connection is retried
+    Retry RetryPolicy
+
+    // Pty, when set, runs the command on a pseudo-terminal. Its stderr is then merged into stdout.
+    Pty *PtyOptions
+
+    // Transfers lists files to copy over SFTP on the same connection: uploads before the command runs and downloads
+    // after it finishes. The command does not run if an upload fails.
+    Transfers []Transfer
+
+    // Cancel, when closed, stops the command as Timeout would, except that the response reports it as cancelled
+    Cancel <-chan struct{}
+
+    // ExitReasons overrides the reason phrases of the default table for the command's exit codes
+    ExitReasons ExitReasons
+
+    // Stdin, when not nil, is written to the command's standard input, which is then closed. The response records its
+    // SHA-256 digest.
+    Stdin []byte
+}
+
+// TimedOutStatus is the status every backend reports for a command that exceeded its timeout, as with timeout(1)
+const TimedOutStatus = 124
+
 // ExecuteRemoteCommand performs the core logic of remote-mac
-func ExecuteRemoteCommand(destination string, clientConfig *ssh.ClientConfig, command string) Response {
+func ExecuteRemoteCommand(destination *Destination, command string, options ExecuteOptions) Response {
+    response := runRemoteCommand(destination, command, options)
+    if options.Stdin != nil {
+        response.StdinSha256 = stdinDigest(options.Stdin)
+    }
+    return response
+}
 
-    conn, err := ssh.Dial("tcp", destination, clientConfig)
-    if err != nil {
-        errorMsg := "Failed to connect: " + err.Error()
-        return Response{Error: &errorMsg, Status: -1, Reason: "SSH Error"}
+func runRemoteCommand(destination *Destination, command string, options ExecuteOptions) Response {
+
+    conn, closeConn, attemptErrors, dialReason := dialWithRetry(destination, options.Retry, options.Cancel)
+    if conn == nil {
+        return dialFailure(attemptErrors, dialReason)
+    }
+    defer closeConn()
+
+    if destination.ServerAliveInterval > 0 {
+        stop := keepAlive(conn, destination.ServerAliveInterval)
+        defer close(stop)
+    }
+
+    // Report the connection attempts, the identity that authenticated and the files transferred whatever happens next
+
+    response := Response{
+        Identity:      destination.authenticatedIdentity(),
+        StderrMerged:  options.Pty != nil,
+        Attempts:      len(attemptErrors) + 1,
+        AttemptErrors: errorStrings(attemptErrors),
+    }
+
+    fail := func(reason string, errorMsg string) Response {
+        response.Error, response.Status, response.Reason = &errorMsg, -1, reason
+        return response
+    }
+
+    // Upload files the command needs
+
+    uploads, downloads := splitTransfers(options.Transfers)
+
+    if len(uploads) > 0 {
+        results, ok := transferFiles(conn, uploads)
+        response.Transfers = append(response.Transfers, results...)
+        if !ok {
+            for _, transfer := range downloads {
+                response.Transfers = append(response.Transfers, failedTransfer(transfer, errors.New("not attempted after an upload failed")))
+            }
+            return fail("Transfer Failed", "Failed to upload files; the command did not run")
+        }
     }
-    defer conn.Close()
 
     // Create session
 
     session, err := conn.NewSession()
     if err != nil {
-        errorMsg := "Failed to create session: " + err.Error()
-        return Response{Error: &errorMsg, Status: -1, Reason: "SSH Error"}
+        return fail("SSH Error", "Failed to create session: "+err.Error())
     }
     defer session.Close()
 
-    // Run command
+    // The server runs a certificate's forced command, which sets the environment itself, in place of the command sent
 
-    var stdoutBuf bytes.Buffer
-    var stderrBuf bytes.Buffer
+    if destination.commandForced {
+        command = environmentCommand(command, options.Environment)
+    } else {
+        command = setEnvironment(session, command, options.Environment)
+    }
 
-    session.Stdout = &stdoutBuf
-    session.Stderr = &stderrBuf
+    if options.Pty != nil {
+        err := session.RequestPty(options.Pty.Term, options.Pty.Height, options.Pty.Width, options.Pty.Modes)
+        if err != nil {
+            return fail("SSH Error", "Failed to allocate PTY: "+err.Error())
+        }
+    }
 
-    err = session.Run(command)
+    // Run command
 
-    stdoutStr := stdoutBuf.String()
-    stderrStr := stderrBuf.String()
+    capture := NewCapture(options)
+    session.Stdout = capture.Stdout
+    session.Stderr = capture.Stderr
 
-    var stdout *string
-    if stdoutStr != "" {
-        stdout = &stdoutStr
+    if options.Stdin != nil {
+        session.Stdin = bytes.NewReader(options.Stdin)
     }
 
-    var stderr *string
-    if stderrStr != "" {
-        stderr = &stderrStr
+    stopped := NotStopped
+
+    err = session.Start(command)
+    if err == nil {
+        stopped, err = wait(session, options)
     }
 
     var exitCode int
     var reason string
     var errorPtr *string
 
-    if err == nil {
+    // A command stopped by a timeout may still report the signal that ended it
+
+    var signal, exitMessage string
+    if exitErr, ok := err.(*ssh.ExitError); ok {
+        signal, exitMessage = exitErr.Signal(), exitErr.Msg()
+    }
+
+    if stopped == StoppedByTimeout {
+        errorMsg := fmt.Sprintf("command timed out after %s", options.Timeout)
+        errorPtr = &errorMsg
+        exitCode = TimedOutStatus
+        reason = "Timed Out"
+    } else if stopped == StoppedByCancel {
+        errorMsg := "command cancelled"
+        errorPtr = &errorMsg
+        exitCode = -1
+        reason = "Cancelled"
+    } else if err == nil {
         exitCode = 0
         reason = "OK"
     } else if exitErr, ok := err.(*ssh.ExitError); ok {
         exitCode = exitErr.ExitStatus()
-        reason = getExitReason(exitCode)
+        if signal != "" {
+            reason = "Terminated by Signal"
+        } else {
+            reason = options.ExitReasons.Reason(exitCode)
+        }
+    } else if _, ok := err.(*ssh.ExitMissingError); ok {
+        errorMsg := "the server closed the session without reporting an exit status"
+        errorPtr = &errorMsg
+        exitCode = -1
+        reason = "Exit Status Missing"
     } else {
         errorMsg := err.Error()
         errorPtr = &errorMsg
@@ -106,13 +249,321 @@ func ExecuteRemoteCommand(destination string, clientConfig *ssh.ClientConfig, co
         reason = "SSH Error"
     }
 
-    response := Response{
-        Stdout: stdout,
-        Stderr: stderr,
-        Error:  errorPtr,
-        Status: exitCode,
-        Reason: reason,
+    response.Error = errorPtr
+    response.Status = exitCode
+    response.Reason = reason
+    response.TimedOut = stopped == StoppedByTimeout
+    response.Signal = signal
+    response.ExitMessage = exitMessage
+    capture.Fill(&response)
+
+    // Fetch files the command produced, even when it failed
+
+    if len(downloads) > 0 {
+        results, _ := transferFiles(conn, downloads)
+        response.Transfers = append(response.Transfers, results...)
     }
 
     return response
 }
+
+// dialFailure returns the response for a destination that could not be reached after the attempts that failed with
+// attemptErrors
+func dialFailure(attemptErrors []error, reason string) Response {
+    errorMsg := "Failed to connect: " + attemptErrors[len(attemptErrors)-1].Error()
+    return Response{Error: &errorMsg, Status: -1, Reason: reason, Attempts: len(attemptErrors), AttemptErrors: errorStrings(attemptErrors)}
+}
+
+func errorStrings(errs []error) []string {
+    if len(errs) == 0 {
+        return nil
+    }
+    messages := make([]string, len(errs))
+    for i, err := range errs {
+        messages[i] = err.Error()
+    }
+    return messages
+}
+
+// splitTransfers separates uploads from downloads, keeping the order of each
+func splitTransfers(transfers []Transfer) ([]Transfer, []Transfer) {
+    var uploads, downloads []Transfer
+    for _, transfer := range transfers {
+        if transfer.Direction == Upload {
+            uploads = append(uploads, transfer)
+        } else {
+            downloads = append(downloads, transfer)
+        }
+    }
+    return uploads, downloads
+}
+
+// setEnvironment asks the server to set each variable in environment for session and returns command rewritten to set
+// those it refused through env(1), e.g. env 'NAME=value' sh -c 'command'. The rewrite assumes a POSIX login shell.
+func setEnvironment(session *ssh.Session, command string, environment map[string]string) string {
+
+    names := make([]string, 0, len(environment))
+    for name := range environment {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    refused := map[string]string{}
+
+    for _, name := range names {
+        if err := session.Setenv(name, environment[name]); err != nil {
+            refused[name] = environment[name]
+        }
+    }
+
+    return environmentCommand(command, refused)
+}
+
+// environmentCommand returns command rewritten to run with environment through env(1), or command itself when
+// environment is empty
+func environmentCommand(command string, environment map[string]string) string {
+
+    if len(environment) == 0 {
+        return command
+    }
+
+    names := make([]string, 0, len(environment))
+    for name := range environment {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    assignments := make([]string, len(names))
+    for i, name := range names {
+        assignments[i] = shellquote.Quote(name + "=" + environment[name])
+    }
+
+    return fmt.Sprintf("env %s sh -c %s", strings.Join(assignments, " "), shellquote.Quote(command))
+}
+
+// StopCause records why a backend stopped a command before it finished on its own
+type StopCause int
+
+// Stop causes
+const (
+    NotStopped StopCause = iota
+    StoppedByTimeout
+    StoppedByCancel
+)
+
+// wait waits for the command started on session to finish. If options.Timeout expires or options.Cancel is closed first the
+// command is sent SIGTERM and then SIGKILL, and wait reports why it was stopped once the command exits or the final
+// grace period lapses. The caller closes the connection, which stops any output still in flight.
+func wait(session *ssh.Session, options ExecuteOptions) (StopCause, error) {
+
+    done := make(chan error, 1)
+    go func() {
+        done <- session.Wait()
+    }()
+
+    var expired <-chan time.Time
+    if options.Timeout > 0 {
+        timer := time.NewTimer(options.Timeout)
+        defer timer.Stop()
+        expired = timer.C
+    }
+
+    var stopped StopCause
+
+    select {
+    case err := <-done:
+        return NotStopped, err
+    case <-expired:
+        stopped = StoppedByTimeout
+    case <-options.Cancel:
+        stopped = StoppedByCancel
+    }
+
+    killAfter := options.KillAfter
+    if killAfter <= 0 {
+        killAfter = 5 * time.Second
+    }
+
+    for _, signal := range []ssh.Signal{ssh.SIGTERM, ssh.SIGKILL} {
+        session.Signal(signal)
+        select {
+        case err := <-done:
+            return stopped, err
+        case <-time.After(killAfter):
+        }
+    }
+
+    return stopped, nil
+}
+
+// HopError reports a failure to connect to one hop of a jump host chain
+type HopError struct {
+    Hop     int
+    Hops    int
+    Name    string
+    Address string
+    Err     error
+}
+
+func (e *HopError) Error() string {
+    return fmt.Sprintf("hop %d of %d (%s at %s): %v", e.Hop, e.Hops, e.Name, e.Address, e.Err)
+}
+
+func (e *HopError) Unwrap() error {
+    return e.Err
+}
+
+// handshakeTimeoutError reports an SSH handshake that did not complete in time
+type handshakeTimeoutError struct {
+    timeout time.Duration
+}
+
+func (e *handshakeTimeoutError) Error() string {
+    return fmt.Sprintf("ssh: handshake timed out after %s", e.timeout)
+}
+
+func (e *handshakeTimeoutError) Timeout() bool   { return true }
+func (e *handshakeTimeoutError) Temporary() bool { return true }
+
+// dial connects to destination, tunnelling through its jump hosts with direct-tcpip channels when it has any. It
+// returns the client for the final hop and a function that closes every connection in the chain.
+func dial(destination *Destination) (*ssh.Client, func(), error) {
+
+    if len(destination.Jumps) == 0 {
+        client, err := dialDirect(destination)
+        if err != nil {
+            return nil, nil, err
+        }
+        return client, func() { client.Close() }, nil
+    }
+
+    hops := append(append([]*Destination{}, destination.Jumps...), destination)
+    clients := make([]*ssh.Client, 0, len(hops))
+
+    closeAll := func() {
+        for i := len(clients) - 1; i >= 0; i-- {
+            clients[i].Close()
+        }
+    }
+
+    for i, hop := range hops {
+
+        var client *ssh.Client
+        var err error
+
+        if i == 0 {
+            client, err = dialDirect(hop)
+        } else {
+            client, err = dialThrough(clients[i-1], hop)
+        }
+
+        if err != nil {
+            closeAll()
+            return nil, nil, &HopError{Hop: i + 1, Hops: len(hops), Name: hop.Name, Address: hop.Address, Err: err}
+        }
+
+        clients = append(clients, client)
+    }
+
+    return clients[len(clients)-1], closeAll, nil
+}
+
+// dialDirect connects to hop over TCP and starts an SSH client over the connection
+func dialDirect(hop *Destination) (*ssh.Client, error) {
+
+    conn, err := net.DialTimeout("tcp", hop.Address, hop.ClientConfig.Timeout)
+    if err != nil {
+        return nil, err
+    }
+
+    return handshake(conn, hop)
+}
+
+// dialThrough opens a direct-tcpip channel to hop through the previous hop and starts an SSH client over it. Only the
+// jump hosts of the destination are dialed through: a jump host's own ProxyJump is not followed.
+func dialThrough(previous *ssh.Client, hop *Destination) (*ssh.Client, error) {
+
+    conn, err := previous.Dial("tcp", hop.Address)
+    if err != nil {
+        return nil, err
+    }
+
+    return handshake(conn, hop)
+}
+
+// handshake starts an SSH client for hop over conn, which it closes on failure. The handshake is bounded by
+// hop.ClientConfig.Timeout; since a channel through a jump host has no deadlines, conn is closed when the timeout
+// expires. A server that closes conn before sending its version is reported with a *closedBeforeVersionError.
+func handshake(conn net.Conn, hop *Destination) (*ssh.Client, error) {
+
+    tracked := &versionConn{Conn: conn}
+
+    var timer *time.Timer
+    if hop.ClientConfig.Timeout > 0 {
+        timer = time.AfterFunc(hop.ClientConfig.Timeout, func() { conn.Close() })
+    }
+
+    clientConn, channels, requests, err := ssh.NewClientConn(tracked, hop.Address, hop.ClientConfig)
+
+    if timer != nil && !timer.Stop() {
+        if err == nil {
+            clientConn.Close()
+        }
+        return nil, &handshakeTimeoutError{timeout: hop.ClientConfig.Timeout}
+    }
+    if err != nil {
+        conn.Close()
+        if !tracked.received.Load() && (errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)) {
+            return nil, &closedBeforeVersionError{err: err}
+        }
+        return nil, err
+    }
+
+    return ssh.NewClient(clientConn, channels, requests), nil
+}
+
+// keepAlive sends an OpenSSH keepalive request every interval and closes conn after three consecutive requests go
+// unanswered, matching the ssh CLI's default ServerAliveCountMax. Closing the returned channel stops the keepalive.
+func keepAlive(conn *ssh.Client, interval time.Duration) chan<- struct{} {
+
+    stop := make(chan struct{})
+
+    go func() {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        missed := 0
+        for {
+            select {
+            case <-stop:
+                return
+            case <-ticker.C:
+            }
+
+            reply := make(chan error, 1)
+            go func() {
+                _, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
+                reply <- err
+            }()
+
+            select {
+            case <-stop:
+                return
+            case err := <-reply:
+                if err == nil {
+                    missed = 0
+                    continue
+                }
+                missed++
+            case <-time.After(interval):
+                missed++
+            }
+
+            if missed >= 3 {
+                conn.Close()
+                return
+            }
+        }
+    }()
+
+    return stop
+}
@@ -0,0 +1,876 @@
+package sshremote
+
+import (
+    "bytes"
+    "crypto/ecdsa"
+    "crypto/ed25519"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/binary"
+    "encoding/hex"
+    "encoding/pem"
+    "errors"
+    "io"
+    "net"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/pkg/sftp"
+    "golang.org/x/crypto/ssh"
+    "golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testSession is a session opened on testServer. Signals delivered by the client are sent to signals.
+// testPty records a pty-req
+type testPty struct {
+    term          string
+    columns, rows uint32
+    modes         []byte
+}
+
+type testSession struct {
+    ssh.Channel
+    command string
+    env     map[string]string
+    pty     *testPty
+    signals chan string
+
+    // exitSignal, when set, is reported with exit-signal instead of the exit status; noExitStatus reports neither
+    exitSignal   string
+    exitMessage  string
+    noExitStatus bool
+}
+
+// testExec is the handler invoked by testServer for each "exec" request. It returns the exit status to report.
+type testExec func(session *testSession) uint32
+
+// testServer is a minimal in-process SSH server used to exercise the client side of this package
+type testServer struct {
+    address  string
+    hostKey  ssh.Signer
+    listener net.Listener
+}
+
+func newTestSigner(t *testing.T) ssh.Signer {
+    t.Helper()
+    _, private, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil {
+        t.Fatalf("failed to generate key: %v", err)
+    }
+    signer, err := ssh.NewSignerFromKey(private)
+    if err != nil {
+        t.Fatalf("failed to create signer: %v", err)
+    }
+    return signer
+}
+
+func startTestServer(t *testing.T, exec testExec) *testServer {
+    t.Helper()
+    return startTestServerFor(t, exec)
+}
+
+// startTestServerFor starts a test server that accepts only the authorized keys, or any key when none are given
+func startTestServerFor(t *testing.T, exec testExec, authorized ...ssh.PublicKey) *testServer {
+    t.Helper()
+    return startTestServerWithCallback(t, exec, func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+        if len(authorized) == 0 {
+            return &ssh.Permissions{}, nil
+        }
+        for _, authorizedKey := range authorized {
+            if bytes.Equal(authorizedKey.Marshal(), key.Marshal()) {
+                return &ssh.Permissions{}, nil
+            }
+        }
+        return nil, errors.New("unauthorized key")
+    })
+}
+
+// startTestServerWithCallback starts a test server that authenticates clients with publicKeyCallback
+func startTestServerWithCallback(t *testing.T, exec testExec, publicKeyCallback func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error)) *testServer {
+    t.Helper()
+
+    hostKey := newTestSigner(t)
+    config := &ssh.ServerConfig{PublicKeyCallback: publicKeyCallback}
+    config.AddHostKey(hostKey)
+
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("failed to listen: %v", err)
+    }
+    t.Cleanup(func() { listener.Close() })
+
+    go func() {
+        for {
+            conn, err := listener.Accept()
+            if err != nil {
+                return
+            }
+            go serveTestConn(conn, config, exec)
+        }
+    }()
+
+    return &testServer{address: listener.Addr().String(), hostKey: hostKey, listener: listener}
+}
+
+func serveTestConn(conn net.Conn, config *ssh.ServerConfig, exec testExec) {
+    _, channels, requests, err := ssh.NewServerConn(conn, config)
+    if err != nil {
+        conn.Close()
+        return
+    }
+    go ssh.DiscardRequests(requests)
+    for newChannel := range channels {
+        if newChannel.ChannelType() == "direct-tcpip" {
+            go forwardTestChannel(newChannel)
+            continue
+        }
+        if newChannel.ChannelType() == "direct-streamlocal@openssh.com" {
+            go forwardTestSocket(newChannel)
+            continue
+        }
+        if newChannel.ChannelType() != "session" {
+            newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+            continue
+        }
+        channel, requests, err := newChannel.Accept()
+        if err != nil {
+            continue
+        }
+        go serveTestSession(channel, requests, exec)
+    }
+}
+
+func serveTestSession(channel ssh.Channel, requests <-chan *ssh.Request, exec testExec) {
+    session := &testSession{Channel: channel, env: map[string]string{}, signals: make(chan string, 4)}
+    for request := range requests {
+        switch request.Type {
+        case "exec":
+            var payload struct{ Command string }
+            ssh.Unmarshal(request.Payload, &payload)
+            session.command = payload.Command
+            request.Reply(true, nil)
+            go func() {
+                status := exec(session)
+                switch {
+                case session.noExitStatus:
+                case session.exitSignal != "":
+                    channel.SendRequest("exit-signal", false, ssh.Marshal(struct {
+                        Signal     string
+                        CoreDumped bool
+                        Error      string
+                        Lang       string
+                    }{Signal: session.exitSignal, Error: session.exitMessage}))
+                default:
+                    reply := make([]byte, 4)
+                    binary.BigEndian.PutUint32(reply, status)
+                    channel.SendRequest("exit-status", false, reply)
+                }
+                channel.Close()
+            }()
+        case "subsystem":
+            var payload struct{ Name string }
+            ssh.Unmarshal(request.Payload, &payload)
+            if payload.Name != "sftp" {
+                request.Reply(false, nil)
+                continue
+            }
+            request.Reply(true, nil)
+            go func() {
+                server, err := sftp.NewServer(channel)
+                if err == nil {
+                    server.Serve()
+                }
+                channel.Close()
+            }()
+        case "pty-req":
+            var payload struct {
+                Term          string
+                Columns, Rows uint32
+                Width, Height uint32
+                Modes         string
+            }
+            ssh.Unmarshal(request.Payload, &payload)
+            if payload.Term == "unsupported" {
+                request.Reply(false, nil)
+                continue
+            }
+            session.pty = &testPty{term: payload.Term, columns: payload.Columns, rows: payload.Rows, modes: []byte(payload.Modes)}
+            request.Reply(true, nil)
+        case "env":
+            // Accept only WEBHOOK_* variables, as with AcceptEnv WEBHOOK_* in sshd_config
+            var payload struct{ Name, Value string }
+            ssh.Unmarshal(request.Payload, &payload)
+            accepted := strings.HasPrefix(payload.Name, "WEBHOOK_")
+            if accepted {
+                session.env[payload.Name] = payload.Value
+            }
+            request.Reply(accepted, nil)
+        case "signal":
+            var payload struct{ Signal string }
+            ssh.Unmarshal(request.Payload, &payload)
+            select {
+            case session.signals <- payload.Signal:
+            default:
+            }
+        default:
+            request.Reply(false, nil)
+        }
+    }
+}
+
+// forwardTestChannel serves a direct-tcpip channel by connecting to the requested address and copying in both directions
+func forwardTestChannel(newChannel ssh.NewChannel) {
+    var target struct {
+        Host       string
+        Port       uint32
+        OriginHost string
+        OriginPort uint32
+    }
+    if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+        newChannel.Reject(ssh.ConnectionFailed, err.Error())
+        return
+    }
+    conn, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+    if err != nil {
+        newChannel.Reject(ssh.ConnectionFailed, err.Error())
+        return
+    }
+    channel, requests, err := newChannel.Accept()
+    if err != nil {
+        conn.Close()
+        return
+    }
+    go ssh.DiscardRequests(requests)
+    go func() {
+        io.Copy(conn, channel)
+        conn.Close()
+    }()
+    io.Copy(channel, conn)
+    channel.Close()
+}
+
+// forwardTestSocket serves a direct-streamlocal channel by connecting to the requested Unix socket and copying in both
+// directions
+func forwardTestSocket(newChannel ssh.NewChannel) {
+    var target struct {
+        SocketPath string
+        Reserved0  string
+        Reserved1  uint32
+    }
+    if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+        newChannel.Reject(ssh.ConnectionFailed, err.Error())
+        return
+    }
+    conn, err := net.Dial("unix", target.SocketPath)
+    if err != nil {
+        newChannel.Reject(ssh.ConnectionFailed, err.Error())
+        return
+    }
+    channel, requests, err := newChannel.Accept()
+    if err != nil {
+        conn.Close()
+        return
+    }
+    go ssh.DiscardRequests(requests)
+    go func() {
+        io.Copy(conn, channel)
+        conn.Close()
+    }()
+    io.Copy(channel, conn)
+    channel.Close()
+}
+
+// writeKnownHosts writes a known_hosts file listing key for the server address and returns the config directory
+func writeKnownHosts(t *testing.T, lines ...string) string {
+    t.Helper()
+    configDirectory := t.TempDir()
+    if err := os.MkdirAll(filepath.Join(configDirectory, "ssh"), 0o700); err != nil {
+        t.Fatalf("failed to create ssh directory: %v", err)
+    }
+    content := strings.Join(lines, "\n") + "\n"
+    if err := os.WriteFile(filepath.Join(configDirectory, "ssh", "known_hosts"), []byte(content), 0o600); err != nil {
+        t.Fatalf("failed to write known_hosts: %v", err)
+    }
+    return configDirectory
+}
+
+func testClientConfig(t *testing.T, hostKeyCallback ssh.HostKeyCallback) *ssh.ClientConfig {
+    t.Helper()
+    return &ssh.ClientConfig{
+        User:            "tester",
+        Auth:            []ssh.AuthMethod{ssh.PublicKeys(newTestSigner(t))},
+        HostKeyCallback: hostKeyCallback,
+        Timeout:         5 * time.Second,
+    }
+}
+
+func TestHostKeyCallback(t *testing.T) {
+    server := startTestServer(t, func(session *testSession) uint32 {
+        session.Write([]byte("hello\n"))
+        return 0
+    })
+    address := knownhosts.Normalize(server.address)
+    other := newTestSigner(t)
+
+    tests := []struct {
+        name       string
+        line       string
+        wantReason string
+        wantErr    string
+    }{
+        {name: "plain entry", line: knownhosts.Line([]string{address}, server.hostKey.PublicKey()), wantReason: "OK"},
+        {name: "hashed entry", line: knownhosts.Line([]string{knownhosts.HashHostname(address)}, server.hostKey.PublicKey()), wantReason: "OK"},
+        {name: "mismatch", line: knownhosts.Line([]string{address}, other.PublicKey()), wantReason: "Host Key Verification Failed", wantErr: "key does not match"},
+        {name: "unknown host", line: knownhosts.Line([]string{"elsewhere.example"}, server.hostKey.PublicKey()), wantReason: "Host Key Verification Failed", wantErr: "not listed"},
+        {name: "revoked", line: "@revoked * " + string(ssh.MarshalAuthorizedKey(server.hostKey.PublicKey())), wantReason: "Host Key Verification Failed", wantErr: "revoked"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            configDirectory := writeKnownHosts(t, strings.TrimSpace(tt.line))
+            callback, err := newHostKeyCallback(filepath.Join(configDirectory, "ssh", "known_hosts"))
+            if err != nil {
+                t.Fatalf("unexpected error: %v", err)
+            }
+
+            destination := &Destination{Name: server.address, Address: server.address, ClientConfig: testClientConfig(t, callback)}
+            response := ExecuteRemoteCommand(destination, "echo hello", ExecuteOptions{})
+            if response.Reason != tt.wantReason {
+                t.Fatalf("reason: want %q, got %q (error: %v)", tt.wantReason, response.Reason, response.Error)
+            }
+            if tt.wantErr == "" {
+                return
+            }
+            if response.Error == nil || !strings.Contains(*response.Error, tt.wantErr) {
+                t.Fatalf("error: want substring %q, got %v", tt.wantErr, response.Error)
+            }
+            fingerprint := ssh.FingerprintSHA256(server.hostKey.PublicKey())
+            if !strings.Contains(*response.Error, fingerprint) {
+                t.Fatalf("error: want presented fingerprint %q, got %q", fingerprint, *response.Error)
+            }
+        })
+    }
+}
+
+func TestHostKeyCallback_MissingKnownHosts(t *testing.T) {
+    _, err := newHostKeyCallback(filepath.Join(t.TempDir(), "known_hosts"))
+    if err == nil {
+        t.Fatalf("expected error for missing known_hosts")
+    }
+    var hostKeyErr *HostKeyError
+    if errors.As(err, &hostKeyErr) {
+        t.Fatalf("missing known_hosts must not be reported as a host key mismatch")
+    }
+}
+
+func TestExecuteRemoteCommand_JumpHosts(t *testing.T) {
+    echo := func(session *testSession) uint32 {
+        session.Write([]byte(session.command))
+        return 0
+    }
+    bastion1 := startTestServer(t, echo)
+    bastion2 := startTestServer(t, echo)
+    target := startTestServer(t, echo)
+
+    configDirectory := writeKnownHosts(t,
+        knownhosts.Line([]string{knownhosts.Normalize(bastion1.address)}, bastion1.hostKey.PublicKey()),
+        knownhosts.Line([]string{knownhosts.Normalize(bastion2.address)}, bastion2.hostKey.PublicKey()),
+        knownhosts.Line([]string{knownhosts.Normalize(target.address)}, target.hostKey.PublicKey()),
+    )
+    callback, err := newHostKeyCallback(filepath.Join(configDirectory, "ssh", "known_hosts"))
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    hop := func(name string, address string) *Destination {
+        return &Destination{Name: name, Address: address, ClientConfig: testClientConfig(t, callback)}
+    }
+
+    destination := hop("target", target.address)
+    destination.Jumps = []*Destination{hop("bastion1", bastion1.address), hop("bastion2", bastion2.address)}
+
+    response := ExecuteRemoteCommand(destination, "hostname", ExecuteOptions{})
+    if response.Reason != "OK" || response.Stdout == nil || *response.Stdout != "hostname" {
+        t.Fatalf("unexpected response: reason %q stdout %v error %v", response.Reason, response.Stdout, response.Error)
+    }
+
+    // A hop that cannot be reached is named in the error
+
+    unreachable := hop("nowhere", "127.0.0.1:1")
+    unreachable.Jumps = []*Destination{hop("bastion1", bastion1.address)}
+
+    response = ExecuteRemoteCommand(unreachable, "hostname", ExecuteOptions{})
+    if response.Error == nil || !strings.Contains(*response.Error, "hop 2 of 2 (nowhere") {
+        t.Fatalf("expected error naming hop 2, got %v", response.Error)
+    }
+
+    // A hop that accepts the connection but never speaks SSH times out
+
+    silent, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("failed to listen: %v", err)
+    }
+    t.Cleanup(func() { silent.Close() })
+    go func() {
+        for {
+            conn, err := silent.Accept()
+            if err != nil {
+                return
+            }
+            go io.Copy(io.Discard, conn)
+        }
+    }()
+
+    mute := hop("mute", silent.Addr().String())
+    mute.ClientConfig.Timeout = 100 * time.Millisecond
+    mute.Jumps = []*Destination{hop("bastion1", bastion1.address)}
+
+    response = ExecuteRemoteCommand(mute, "hostname", ExecuteOptions{})
+    if response.Reason != "Connection Timed Out" || response.Error == nil || !strings.Contains(*response.Error, "hop 2 of 2 (mute") {
+        t.Fatalf("expected hop 2 to time out, got reason %q error %v", response.Reason, deref(response.Error))
+    }
+}
+
+func TestExecuteRemoteCommand_Identities(t *testing.T) {
+
+    // id_ed25519 is offered first but only the encrypted id_ecdsa key is authorized
+
+    _, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil {
+        t.Fatalf("failed to generate key: %v", err)
+    }
+    ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatalf("failed to generate key: %v", err)
+    }
+    ed25519Block, err := ssh.MarshalPrivateKey(ed25519Key, "")
+    if err != nil {
+        t.Fatalf("failed to marshal key: %v", err)
+    }
+    ecdsaBlock, err := ssh.MarshalPrivateKeyWithPassphrase(ecdsaKey, "", []byte("s3cret"))
+    if err != nil {
+        t.Fatalf("failed to marshal key: %v", err)
+    }
+    ecdsaPublic, err := ssh.NewPublicKey(&ecdsaKey.PublicKey)
+    if err != nil {
+        t.Fatalf("failed to convert key: %v", err)
+    }
+
+    server := startTestServerFor(t, func(session *testSession) uint32 { return 0 }, ecdsaPublic)
+
+    configDirectory := writeKnownHosts(t, knownhosts.Line([]string{knownhosts.Normalize(server.address)}, server.hostKey.PublicKey()))
+    for name, block := range map[string]*pem.Block{"id_ed25519": ed25519Block, "id_ecdsa": ecdsaBlock} {
+        if err := os.WriteFile(filepath.Join(configDirectory, "ssh", name), pem.EncodeToMemory(block), 0o600); err != nil {
+            t.Fatalf("failed to write %s: %v", name, err)
+        }
+    }
+
+    // Without a passphrase source the encrypted key cannot be loaded
+
+    if _, err := ParseSshDestination("ssh://tester@"+server.address, configDirectory, nil); err == nil {
+        t.Fatalf("expected error for encrypted key without passphrase source")
+    }
+
+    var requested []string
+    passphrase := func(keyPath string) ([]byte, error) {
+        requested = append(requested, filepath.Base(keyPath))
+        return []byte("s3cret"), nil
+    }
+
+    destination, err := ParseSshDestination("ssh://tester@"+server.address, configDirectory, passphrase)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if strings.Join(requested, ",") != "id_ecdsa" {
+        t.Fatalf("passphrase requested for %v, want [id_ecdsa]", requested)
+    }
+
+    response := ExecuteRemoteCommand(destination, "true", ExecuteOptions{})
+    if response.Reason != "OK" {
+        t.Fatalf("unexpected response: reason %q error %v", response.Reason, response.Error)
+    }
+    want := filepath.Join(configDirectory, "ssh", "id_ecdsa")
+    if response.Identity == nil || *response.Identity != want {
+        t.Fatalf("identity: want %q, got %v", want, response.Identity)
+    }
+}
+
+func TestExecuteRemoteCommand_Certificates(t *testing.T) {
+    _, authorityKey, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil {
+        t.Fatalf("failed to generate key: %v", err)
+    }
+    authority, err := ssh.NewSignerFromKey(authorityKey)
+    if err != nil {
+        t.Fatalf("failed to create signer: %v", err)
+    }
+
+    var permissions *ssh.Permissions
+    var sent string
+    checker := &ssh.CertChecker{
+        SupportedCriticalOptions: []string{"force-command"},
+        IsUserAuthority: func(auth ssh.PublicKey) bool {
+            return bytes.Equal(auth.Marshal(), authority.PublicKey().Marshal())
+        },
+    }
+    server := startTestServerWithCallback(t, func(session *testSession) uint32 { sent = session.command; return 0 }, func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+        if _, ok := key.(*ssh.Certificate); !ok {
+            return nil, errors.New("certificate required")
+        }
+        p, err := checker.Authenticate(conn, key)
+        permissions = p
+        return p, err
+    })
+
+    configDirectory := writeKnownHosts(t, knownhosts.Line([]string{knownhosts.Normalize(server.address)}, server.hostKey.PublicKey()))
+    _, userKey, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil {
+        t.Fatalf("failed to generate key: %v", err)
+    }
+    userBlock, err := ssh.MarshalPrivateKey(userKey, "")
+    if err != nil {
+        t.Fatalf("failed to marshal key: %v", err)
+    }
+    keyPath := filepath.Join(configDirectory, "ssh", "id_ed25519")
+    if err := os.WriteFile(keyPath, pem.EncodeToMemory(userBlock), 0o600); err != nil {
+        t.Fatalf("failed to write key: %v", err)
+    }
+
+    // Without a certificate the bare key is refused
+
+    destination, err := ParseSshDestination("ssh://tester@"+server.address, configDirectory, nil)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if response := ExecuteRemoteCommand(destination, "true", ExecuteOptions{}); response.Reason == "OK" {
+        t.Fatalf("expected bare key to be refused")
+    }
+
+    // A certificate beside the key is offered first
+
+    userSigner, err := ssh.NewSignerFromKey(userKey)
+    if err != nil {
+        t.Fatalf("failed to create signer: %v", err)
+    }
+    cert := &ssh.Certificate{Key: userSigner.PublicKey(), CertType: ssh.UserCert, ValidPrincipals: []string{"tester"}, ValidBefore: ssh.CertTimeInfinity}
+    if err := cert.SignCert(rand.Reader, authority); err != nil {
+        t.Fatalf("failed to sign certificate: %v", err)
+    }
+    if err := os.WriteFile(keyPath+"-cert.pub", ssh.MarshalAuthorizedKey(cert), 0o600); err != nil {
+        t.Fatalf("failed to write certificate: %v", err)
+    }
+
+    destination, err = ParseSshDestination("ssh://tester@"+server.address, configDirectory, nil)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    response := ExecuteRemoteCommand(destination, "true", ExecuteOptions{})
+    if response.Reason != "OK" || response.Identity == nil || *response.Identity != keyPath+"-cert.pub" {
+        t.Fatalf("unexpected response: reason %q identity %v error %v", response.Reason, response.Identity, deref(response.Error))
+    }
+
+    // An ephemeral certificate carries the requested principals and forced command
+
+    caBlock, err := ssh.MarshalPrivateKey(authorityKey, "")
+    if err != nil {
+        t.Fatalf("failed to marshal key: %v", err)
+    }
+    caPath := filepath.Join(configDirectory, "ca")
+    if err := os.WriteFile(caPath, pem.EncodeToMemory(caBlock), 0o600); err != nil {
+        t.Fatalf("failed to write key: %v", err)
+    }
+    ca, err := LoadCertificateAuthority(caPath, nil)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    request := CertificateRequest{KeyId: "location-a:cid", Principals: []string{"tester"}, ForceCommand: "uptime", Lifetime: time.Minute}
+    if err := destination.UseEphemeralCertificate(ca, request); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    response = ExecuteRemoteCommand(destination, "uptime", ExecuteOptions{})
+    if response.Reason != "OK" || response.Identity == nil || !strings.Contains(*response.Identity, "location-a:cid") {
+        t.Fatalf("unexpected response: reason %q identity %v error %v", response.Reason, response.Identity, deref(response.Error))
+    }
+    if permissions == nil || permissions.CriticalOptions["force-command"] != "uptime" {
+        t.Fatalf("expected force-command uptime, got %v", permissions)
+    }
+
+    // The forced command sets the environment itself, and is exactly the command sent
+
+    request.Environment = map[string]string{"WEBHOOK_SUBJECT": "deployer"}
+    if err := destination.UseEphemeralCertificate(ca, request); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    response = ExecuteRemoteCommand(destination, "uptime", ExecuteOptions{Environment: request.Environment})
+    want := `env WEBHOOK_SUBJECT=deployer sh -c uptime`
+    if response.Reason != "OK" || permissions.CriticalOptions["force-command"] != want || sent != want {
+        t.Fatalf("want force-command and command %s, got %v and %s (reason %q)", want, permissions.CriticalOptions, sent, response.Reason)
+    }
+
+    request.Principals = []string{"someone-else"}
+    if err := destination.UseEphemeralCertificate(ca, request); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if response := ExecuteRemoteCommand(destination, "uptime", ExecuteOptions{}); response.Reason == "OK" {
+        t.Fatalf("expected certificate for another principal to be refused")
+    }
+}
+
+func deref(s *string) string {
+    if s == nil {
+        return "<nil>"
+    }
+    return *s
+}
+
+// newTestDestination returns a destination for server that trusts its host key
+func newTestDestination(t *testing.T, server *testServer) *Destination {
+    t.Helper()
+    configDirectory := writeKnownHosts(t, knownhosts.Line([]string{knownhosts.Normalize(server.address)}, server.hostKey.PublicKey()))
+    callback, err := newHostKeyCallback(filepath.Join(configDirectory, "ssh", "known_hosts"))
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    return &Destination{Name: server.address, Address: server.address, ClientConfig: testClientConfig(t, callback)}
+}
+
+func TestExecuteRemoteCommand_Timeout(t *testing.T) {
+    tests := []struct {
+        name        string
+        ignoreTerm  bool
+        wantSignals []string
+    }{
+        {name: "exits on SIGTERM", wantSignals: []string{"TERM"}},
+        {name: "killed after SIGTERM is ignored", ignoreTerm: true, wantSignals: []string{"TERM", "KILL"}},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var received []string
+            server := startTestServer(t, func(session *testSession) uint32 {
+                session.Write([]byte("partial output\n"))
+                session.Stderr().Write([]byte("partial error\n"))
+                for signal := range session.signals {
+                    received = append(received, signal)
+                    if signal == "KILL" || !tt.ignoreTerm {
+                        return 143
+                    }
+                }
+                return 0
+            })
+
+            response := ExecuteRemoteCommand(newTestDestination(t, server), "sleep 600", ExecuteOptions{Timeout: 100 * time.Millisecond, KillAfter: 200 * time.Millisecond})
+            if !response.TimedOut || response.Reason != "Timed Out" || response.Status != TimedOutStatus {
+                t.Fatalf("unexpected response: timedOut %v reason %q status %d", response.TimedOut, response.Reason, response.Status)
+            }
+            if response.Stdout == nil || *response.Stdout != "partial output\n" {
+                t.Fatalf("stdout: want partial output, got %q", deref(response.Stdout))
+            }
+            if response.Stderr == nil || *response.Stderr != "partial error\n" {
+                t.Fatalf("stderr: want partial error, got %q", deref(response.Stderr))
+            }
+            if strings.Join(received, ",") != strings.Join(tt.wantSignals, ",") {
+                t.Fatalf("signals: want %v, got %v", tt.wantSignals, received)
+            }
+        })
+    }
+}
+
+func TestExecuteRemoteCommand_NoTimeout(t *testing.T) {
+    server := startTestServer(t, func(session *testSession) uint32 {
+        session.Write([]byte("done\n"))
+        return 3
+    })
+
+    response := ExecuteRemoteCommand(newTestDestination(t, server), "true", ExecuteOptions{Timeout: time.Minute})
+    if response.TimedOut || response.Status != 3 || response.Reason != "Exit Code 3" {
+        t.Fatalf("unexpected response: timedOut %v reason %q status %d", response.TimedOut, response.Reason, response.Status)
+    }
+}
+
+func TestExecuteRemoteCommand_Stdin(t *testing.T) {
+    server := startTestServer(t, func(session *testSession) uint32 {
+        payload, _ := io.ReadAll(session)
+        session.Write(payload)
+        return 0
+    })
+
+    payload := []byte(`{"ref":"refs/heads/main"}`)
+    response := ExecuteRemoteCommand(newTestDestination(t, server), "cat", ExecuteOptions{Stdin: payload})
+
+    if response.Status != 0 || deref(response.Stdout) != string(payload) {
+        t.Fatalf("want the payload echoed, got status %d stdout %q error %q", response.Status, deref(response.Stdout), deref(response.Error))
+    }
+    digest := sha256.Sum256(payload)
+    if response.StdinSha256 != hex.EncodeToString(digest[:]) {
+        t.Fatalf("want stdin digest %x, got %q", digest, response.StdinSha256)
+    }
+}
+
+func TestExecuteRemoteCommand_Environment(t *testing.T) {
+    var command string
+    var env map[string]string
+    server := startTestServer(t, func(session *testSession) uint32 {
+        command, env = session.command, session.env
+        return 0
+    })
+
+    environment := map[string]string{
+        "WEBHOOK_CORRELATION_ID": "cid-1",
+        "WEBHOOK_SUBJECT":        "deployer",
+        "LC_DEPLOY":              "it's",
+    }
+
+    response := ExecuteRemoteCommand(newTestDestination(t, server), "echo $LC_DEPLOY && true", ExecuteOptions{Environment: environment})
+    if response.Status != 0 {
+        t.Fatalf("want status 0, got %d: %s", response.Status, deref(response.Error))
+    }
+    if env["WEBHOOK_CORRELATION_ID"] != "cid-1" || env["WEBHOOK_SUBJECT"] != "deployer" || len(env) != 2 {
+        t.Fatalf("want accepted variables set with Setenv, got %v", env)
+    }
+    if want := `env 'LC_DEPLOY=it'\''s' sh -c 'echo $LC_DEPLOY && true'`; command != want {
+        t.Fatalf("want refused variables passed through env:\n want %s\n got  %s", want, command)
+    }
+}
+
+func TestExecuteRemoteCommand_Pty(t *testing.T) {
+    var pty *testPty
+    server := startTestServer(t, func(session *testSession) uint32 {
+        pty = session.pty
+        session.Write([]byte("[sudo] password required\r\n"))
+        return 0
+    })
+
+    modes, err := ParseTerminalModes("ICRNL=1")
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    options := ExecuteOptions{Pty: &PtyOptions{Term: "vt100", Width: 132, Height: 43, Modes: modes}}
+    response := ExecuteRemoteCommand(newTestDestination(t, server), "sudo -n true", options)
+
+    if response.Status != 0 || !response.StderrMerged {
+        t.Fatalf("want status 0 with stderr merged, got status %d merged %v: %s", response.Status, response.StderrMerged, deref(response.Error))
+    }
+    if pty == nil || pty.term != "vt100" || pty.columns != 132 || pty.rows != 43 {
+        t.Fatalf("want a vt100 132x43 PTY, got %+v", pty)
+    }
+
+    // Modes are encoded as opcode and uint32 pairs ending with TTY_OP_END; ECHO=0 comes from the defaults
+
+    encoded := map[byte]uint32{}
+    for b := pty.modes; len(b) >= 5; b = b[5:] {
+        encoded[b[0]] = binary.BigEndian.Uint32(b[1:5])
+    }
+    if value, ok := encoded[ssh.ECHO]; !ok || value != 0 {
+        t.Fatalf("want ECHO=0, got %v", encoded)
+    }
+    if encoded[ssh.ICRNL] != 1 {
+        t.Fatalf("want ICRNL=1, got %v", encoded)
+    }
+
+    response = ExecuteRemoteCommand(newTestDestination(t, server), "true", ExecuteOptions{})
+    if response.StderrMerged {
+        t.Fatalf("want stderr separated without a PTY")
+    }
+}
+
+func TestExecuteRemoteCommand_ExitReasons(t *testing.T) {
+    server := startTestServer(t, func(session *testSession) uint32 {
+        switch session.command {
+        case "killed":
+            session.exitSignal, session.exitMessage = "KILL", "out of memory"
+        case "vanished":
+            session.noExitStatus = true
+        case "rsync":
+            return 23
+        }
+        return 255
+    })
+
+    destination := newTestDestination(t, server)
+
+    tests := []struct {
+        command     string
+        options     ExecuteOptions
+        wantStatus  int
+        wantReason  string
+        wantSignal  string
+        wantMessage string
+    }{
+        {command: "killed", wantStatus: 137, wantReason: "Terminated by Signal", wantSignal: "KILL", wantMessage: "out of memory"},
+        {command: "vanished", wantStatus: -1, wantReason: "Exit Status Missing"},
+        {command: "exit", wantStatus: 255, wantReason: "Exit Code 255"},
+        {command: "rsync", wantStatus: 23, wantReason: "Exit Code 23"},
+        {command: "rsync", options: ExecuteOptions{ExitReasons: ExitReasons{23: "Partial Transfer"}}, wantStatus: 23, wantReason: "Partial Transfer"},
+    }
+
+    for _, tt := range tests {
+        response := ExecuteRemoteCommand(destination, tt.command, tt.options)
+        if response.Status != tt.wantStatus || response.Reason != tt.wantReason || response.Signal != tt.wantSignal || response.ExitMessage != tt.wantMessage {
+            t.Errorf("%s: want %d %q %q %q, got %d %q %q %q", tt.command, tt.wantStatus, tt.wantReason, tt.wantSignal, tt.wantMessage, response.Status, response.Reason, response.Signal, response.ExitMessage)
+        }
+    }
+}
+
+func TestExitReasonTable_For(t *testing.T) {
+    tablePath := filepath.Join(t.TempDir(), "exit_reasons.json")
+    if err := os.WriteFile(tablePath, []byte(`{"rsync": {"23": "Partial Transfer"}}`), 0o600); err != nil {
+        t.Fatalf("failed to write exit reasons: %v", err)
+    }
+
+    table, err := LoadExitReasonTable(tablePath)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if reasons := table.For("/usr/bin/rsync -a src/ dst/"); reasons[23] != "Partial Transfer" {
+        t.Fatalf("want the rsync reasons, got %v", reasons)
+    }
+    if reasons := table.For("docker compose up"); reasons != nil {
+        t.Fatalf("want no reasons for docker, got %v", reasons)
+    }
+
+    if table, err := LoadExitReasonTable(filepath.Join(t.TempDir(), "missing.json")); err != nil || len(table) != 0 {
+        t.Fatalf("want an empty table for a missing file, got %v, %v", table, err)
+    }
+}
+
+func TestParseTerminalModes(t *testing.T) {
+    tests := []struct {
+        input   string
+        want    ssh.TerminalModes
+        wantErr bool
+    }{
+        {input: "", want: DefaultTerminalModes},
+        {input: "echo=1, onlcr=0", want: ssh.TerminalModes{ssh.ECHO: 1, ssh.ONLCR: 0, ssh.TTY_OP_ISPEED: 14400, ssh.TTY_OP_OSPEED: 14400}},
+        {input: "ECHO", wantErr: true},
+        {input: "BOGUS=1", wantErr: true},
+        {input: "ECHO=-1", wantErr: true},
+    }
+
+    for _, tt := range tests {
+        modes, err := ParseTerminalModes(tt.input)
+        if tt.wantErr {
+            if err == nil {
+                t.Fatalf("%q: want error", tt.input)
+            }
+            continue
+        }
+        if err != nil {
+            t.Fatalf("%q: unexpected error: %v", tt.input, err)
+        }
+        if len(modes) != len(tt.want) {
+            t.Fatalf("%q: want %v, got %v", tt.input, tt.want, modes)
+        }
+        for opcode, value := range tt.want {
+            if modes[opcode] != value {
+                t.Fatalf("%q: want %v, got %v", tt.input, tt.want, modes)
+            }
+        }
+    }
+}
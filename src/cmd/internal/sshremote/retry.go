@@ -0,0 +1,156 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+package sshremote
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "math/rand"
+    "net"
+    "strings"
+    "sync/atomic"
+    "syscall"
+    "time"
+
+    "golang.org/x/crypto/ssh"
+)
+
+// RetryPolicy controls how often a failed connection is retried. Only transient failures, such as a refused, reset or
+// timed out connection, are retried; authentication and host key failures never are. The wait before each retry
+// doubles from InitialBackoff up to MaxBackoff, with up to half of it replaced by random jitter.
+type RetryPolicy struct {
+
+    // Attempts is the largest number of connection attempts. Zero means one.
+    Attempts int
+
+    InitialBackoff time.Duration
+    MaxBackoff     time.Duration
+}
+
+// backoff returns the wait before retry number retry, counting from one
+func (policy RetryPolicy) backoff(retry int) time.Duration {
+
+    wait := policy.InitialBackoff
+    for i := 1; i < retry; i++ {
+        wait *= 2
+        if policy.MaxBackoff > 0 && wait >= policy.MaxBackoff {
+            break
+        }
+    }
+    if policy.MaxBackoff > 0 && wait > policy.MaxBackoff {
+        wait = policy.MaxBackoff
+    }
+    if wait <= 0 {
+        return 0
+    }
+
+    half := wait / 2
+    return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// classifyDialError returns the response reason for a connection failure and whether it is worth retrying
+func classifyDialError(err error) (string, bool) {
+
+    var hostKeyErr *HostKeyError
+    if errors.As(err, &hostKeyErr) {
+        return "Host Key Verification Failed", false
+    }
+
+    // The client reports running out of authentication methods with an untyped error, so its text is matched too
+
+    var authErr ssh.ServerAuthError
+    if errors.As(err, &authErr) || strings.Contains(err.Error(), "ssh: unable to authenticate") {
+        return "Authentication Failed", false
+    }
+
+    var dnsErr *net.DNSError
+    if errors.As(err, &dnsErr) {
+        return "Host Not Found", dnsErr.IsTemporary || dnsErr.IsTimeout
+    }
+
+    var channelErr *ssh.OpenChannelError
+    if errors.As(err, &channelErr) && channelErr.Reason == ssh.ConnectionFailed {
+        return "Connection Failed", true
+    }
+
+    // A server that closes the connection once it has sent its version usually does so because it will never accept
+    // this client, for example when no algorithm is shared, so only a connection closed before then is retried
+
+    var closedEarly *closedBeforeVersionError
+
+    switch {
+    case errors.Is(err, syscall.ECONNREFUSED):
+        return "Connection Refused", true
+    case errors.As(err, &closedEarly), errors.Is(err, syscall.ECONNRESET):
+        return "Connection Reset", true
+    case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+        return "Connection Reset", false
+    case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+        return "Host Unreachable", true
+    }
+
+    var netErr net.Error
+    if errors.As(err, &netErr) && netErr.Timeout() {
+        return "Connection Timed Out", true
+    }
+
+    return "SSH Error", false
+}
+
+// closedBeforeVersionError reports a server that closed the connection before sending its SSH version, as one that is
+// still starting or shedding load does
+type closedBeforeVersionError struct {
+    err error
+}
+
+func (e *closedBeforeVersionError) Error() string {
+    return fmt.Sprintf("%v (closed before the SSH version exchange)", e.err)
+}
+
+func (e *closedBeforeVersionError) Unwrap() error {
+    return e.err
+}
+
+// versionConn records whether the server has sent anything, which tells a connection closed before the SSH version
+// exchange from one closed during key exchange
+type versionConn struct {
+    net.Conn
+    received atomic.Bool
+}
+
+func (c *versionConn) Read(b []byte) (int, error) {
+    n, err := c.Conn.Read(b)
+    if n > 0 {
+        c.received.Store(true)
+    }
+    return n, err
+}
+
+// dialWithRetry dials destination under policy. It returns the connection as dial does, the error of each failed
+// attempt, and the reason for the last failure. Closing cancel abandons any remaining retries.
+func dialWithRetry(destination *Destination, policy RetryPolicy, cancel <-chan struct{}) (*ssh.Client, func(), []error, string) {
+
+    var attemptErrors []error
+
+    for attempt := 1; ; attempt++ {
+
+        conn, closeConn, err := dial(destination)
+        if err == nil {
+            return conn, closeConn, attemptErrors, ""
+        }
+
+        attemptErrors = append(attemptErrors, err)
+
+        reason, transient := classifyDialError(err)
+        if !transient || attempt >= policy.Attempts {
+            return nil, nil, attemptErrors, reason
+        }
+
+        select {
+        case <-time.After(policy.backoff(attempt)):
+        case <-cancel:
+            return nil, nil, attemptErrors, reason
+        }
+    }
+}
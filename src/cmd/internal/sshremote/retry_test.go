@@ -0,0 +1,149 @@
+package sshremote
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "os"
+    "syscall"
+    "testing"
+    "time"
+
+    "golang.org/x/crypto/ssh"
+)
+
+// timeoutError is a net.Error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyDialError(t *testing.T) {
+    refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+
+    tests := []struct {
+        name          string
+        err           error
+        wantReason    string
+        wantTransient bool
+    }{
+        {name: "host key", err: &HostKeyError{Hostname: "web-1", Err: errors.New("key mismatch")}, wantReason: "Host Key Verification Failed"},
+        {name: "authentication", err: fmt.Errorf("ssh: handshake failed: %w", errors.New("ssh: unable to authenticate, attempted methods [none publickey], no supported methods remain")), wantReason: "Authentication Failed"},
+        {name: "unknown host", err: &net.DNSError{Err: "no such host", Name: "web-9", IsNotFound: true}, wantReason: "Host Not Found"},
+        {name: "DNS timeout", err: &net.DNSError{Err: "timeout", Name: "web-1", IsTimeout: true}, wantReason: "Host Not Found", wantTransient: true},
+        {name: "refused", err: refused, wantReason: "Connection Refused", wantTransient: true},
+        {name: "refused at a jump host", err: &HopError{Hop: 1, Hops: 2, Err: refused}, wantReason: "Connection Refused", wantTransient: true},
+        {name: "refused beyond a jump host", err: &HopError{Hop: 2, Hops: 2, Err: &ssh.OpenChannelError{Reason: ssh.ConnectionFailed, Message: "Connection refused"}}, wantReason: "Connection Failed", wantTransient: true},
+        {name: "reset", err: &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, wantReason: "Connection Reset", wantTransient: true},
+        {name: "server authentication error", err: fmt.Errorf("ssh: handshake failed: %w", ssh.ServerAuthError{Errors: []error{errors.New("denied")}}), wantReason: "Authentication Failed"},
+        {name: "closed before the version exchange", err: &closedBeforeVersionError{err: fmt.Errorf("ssh: handshake failed: %w", io.EOF)}, wantReason: "Connection Reset", wantTransient: true},
+        {name: "closed during key exchange", err: fmt.Errorf("ssh: handshake failed: %w", io.EOF), wantReason: "Connection Reset"},
+        {name: "unrecognised handshake failure", err: fmt.Errorf("ssh: handshake failed: %w", errors.New("unexpected message")), wantReason: "SSH Error"},
+        {name: "unreachable", err: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.EHOSTUNREACH)}, wantReason: "Host Unreachable", wantTransient: true},
+        {name: "timeout", err: &net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}, wantReason: "Connection Timed Out", wantTransient: true},
+        {name: "other", err: errors.New("ssh: no common algorithm for key exchange"), wantReason: "SSH Error"},
+    }
+
+    for _, tt := range tests {
+        reason, transient := classifyDialError(tt.err)
+        if reason != tt.wantReason || transient != tt.wantTransient {
+            t.Fatalf("%s: want %q transient %v, got %q transient %v", tt.name, tt.wantReason, tt.wantTransient, reason, transient)
+        }
+    }
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+    policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+    for retry, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 5: time.Second, 60: time.Second} {
+        for i := 0; i < 20; i++ {
+            if wait := policy.backoff(retry); wait < want/2 || wait > want {
+                t.Fatalf("retry %d: want a wait between %s and %s, got %s", retry, want/2, want, wait)
+            }
+        }
+    }
+}
+
+func TestExecuteRemoteCommand_RetriesTransientFailures(t *testing.T) {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("failed to listen: %v", err)
+    }
+    address := listener.Addr().String()
+    listener.Close()
+
+    destination := &Destination{Name: "down", Address: address, ClientConfig: &ssh.ClientConfig{HostKeyCallback: ssh.InsecureIgnoreHostKey()}}
+    options := ExecuteOptions{Retry: RetryPolicy{Attempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}}
+
+    response := ExecuteRemoteCommand(destination, "true", options)
+
+    if response.Reason != "Connection Refused" || response.Attempts != 3 || len(response.AttemptErrors) != 3 {
+        t.Fatalf("want 3 refused attempts, got reason %q attempts %d errors %q", response.Reason, response.Attempts, response.AttemptErrors)
+    }
+}
+
+func TestExecuteRemoteCommand_RetriesOnlyEarlyClose(t *testing.T) {
+    tests := []struct {
+        name         string
+        greeting     string
+        wantAttempts int
+    }{
+        {name: "closed before the version exchange", wantAttempts: 3},
+        {name: "closed after the version exchange", greeting: "SSH-2.0-Test\r\n", wantAttempts: 1},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            listener, err := net.Listen("tcp", "127.0.0.1:0")
+            if err != nil {
+                t.Fatalf("failed to listen: %v", err)
+            }
+            t.Cleanup(func() { listener.Close() })
+            go func() {
+                for {
+                    conn, err := listener.Accept()
+                    if err != nil {
+                        return
+                    }
+                    // Close only once the client has sent everything it will before the server's key exchange
+                    io.WriteString(conn, tt.greeting)
+                    if tt.greeting != "" {
+                        conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+                        io.Copy(io.Discard, conn)
+                    }
+                    conn.Close()
+                }
+            }()
+
+            destination := &Destination{Name: "closing", Address: listener.Addr().String(), ClientConfig: &ssh.ClientConfig{HostKeyCallback: ssh.InsecureIgnoreHostKey()}}
+            options := ExecuteOptions{Retry: RetryPolicy{Attempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}}
+
+            response := ExecuteRemoteCommand(destination, "true", options)
+            if response.Reason != "Connection Reset" || response.Attempts != tt.wantAttempts {
+                t.Fatalf("want %d attempts with reason Connection Reset, got reason %q attempts %d errors %q", tt.wantAttempts, response.Reason, response.Attempts, response.AttemptErrors)
+            }
+        })
+    }
+}
+
+func TestExecuteRemoteCommand_DoesNotRetryAuthenticationFailures(t *testing.T) {
+    server := startTestServerFor(t, func(session *testSession) uint32 { return 0 }, newTestSigner(t).PublicKey())
+
+    options := ExecuteOptions{Retry: RetryPolicy{Attempts: 3, InitialBackoff: time.Millisecond}}
+    response := ExecuteRemoteCommand(newTestDestination(t, server), "true", options)
+
+    if response.Reason != "Authentication Failed" || response.Attempts != 1 || len(response.AttemptErrors) != 1 {
+        t.Fatalf("want a single failed attempt, got reason %q attempts %d errors %q", response.Reason, response.Attempts, response.AttemptErrors)
+    }
+}
+
+func TestExecuteRemoteCommand_ReportsAttempts(t *testing.T) {
+    server := startTestServer(t, func(session *testSession) uint32 { return 0 })
+
+    response := ExecuteRemoteCommand(newTestDestination(t, server), "true", ExecuteOptions{})
+    if response.Status != 0 || response.Attempts != 1 || response.AttemptErrors != nil {
+        t.Fatalf("want one successful attempt, got status %d attempts %d errors %q", response.Status, response.Attempts, response.AttemptErrors)
+    }
+}
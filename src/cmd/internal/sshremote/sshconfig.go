@@ -0,0 +1,236 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+package sshremote
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "os"
+    "path"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// hostConfig holds the ssh_config options that apply to a single host alias
+type hostConfig struct {
+    HostName            string
+    User                string
+    Port                string
+    IdentityFiles       []string
+    ConnectTimeout      time.Duration
+    ServerAliveInterval time.Duration
+    ProxyJump           []string
+}
+
+// sshConfigBlock is a Host block from an OpenSSH-style ssh_config file
+type sshConfigBlock struct {
+    patterns []string
+    options  []sshConfigOption
+}
+
+type sshConfigOption struct {
+    keyword string
+    value   string
+    line    int
+}
+
+// sshConfig is an OpenSSH-style ssh_config file. Only Host blocks are supported; Match blocks are skipped.
+type sshConfig struct {
+    path   string
+    blocks []sshConfigBlock
+}
+
+// loadSshConfig reads the ssh_config file at configPath. A missing file yields an empty configuration.
+func loadSshConfig(configPath string) (*sshConfig, error) {
+
+    file, err := os.Open(configPath)
+    if errors.Is(err, os.ErrNotExist) {
+        return &sshConfig{path: configPath}, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to read ssh config: %v", err)
+    }
+    defer file.Close()
+
+    // Options that appear before the first Host line apply to every host
+
+    config := &sshConfig{path: configPath}
+    block := &sshConfigBlock{patterns: []string{"*"}}
+    skipping := false
+    lineNumber := 0
+
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        lineNumber++
+
+        keyword, value, err := splitSshConfigLine(scanner.Text())
+        if err != nil {
+            return nil, fmt.Errorf("%s:%d: %v", configPath, lineNumber, err)
+        }
+        if keyword == "" {
+            continue
+        }
+
+        switch keyword {
+        case "host":
+            config.blocks = append(config.blocks, *block)
+            block = &sshConfigBlock{patterns: strings.Fields(value)}
+            skipping = false
+        case "match":
+            config.blocks = append(config.blocks, *block)
+            block = &sshConfigBlock{}
+            skipping = true
+        case "include":
+            return nil, fmt.Errorf("%s:%d: Include is not supported", configPath, lineNumber)
+        default:
+            if !skipping {
+                block.options = append(block.options, sshConfigOption{keyword: keyword, value: value, line: lineNumber})
+            }
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, fmt.Errorf("failed to read ssh config: %v", err)
+    }
+
+    config.blocks = append(config.blocks, *block)
+    return config, nil
+}
+
+// splitSshConfigLine splits an ssh_config line into a lower-cased keyword and its value. Both forms `Keyword value`
+// and `Keyword=value` are accepted and a double-quoted value is unquoted.
+func splitSshConfigLine(line string) (string, string, error) {
+
+    line = strings.TrimSpace(line)
+    if line == "" || strings.HasPrefix(line, "#") {
+        return "", "", nil
+    }
+
+    index := strings.IndexAny(line, " \t=")
+    if index == -1 {
+        return "", "", fmt.Errorf("missing value for %s", line)
+    }
+
+    keyword := strings.ToLower(line[:index])
+    value := strings.TrimSpace(line[index:])
+    value = strings.TrimSpace(strings.TrimPrefix(value, "="))
+
+    if strings.HasPrefix(value, `"`) {
+        if len(value) < 2 || !strings.HasSuffix(value, `"`) {
+            return "", "", fmt.Errorf("unterminated quote in %s", keyword)
+        }
+        value = value[1 : len(value)-1]
+    }
+
+    if value == "" {
+        return "", "", fmt.Errorf("missing value for %s", keyword)
+    }
+
+    return keyword, value, nil
+}
+
+// resolve returns the options that apply to alias. As with OpenSSH the first obtained value for each option wins,
+// except IdentityFile which accumulates across all matching blocks.
+func (config *sshConfig) resolve(alias string) (hostConfig, error) {
+
+    var result hostConfig
+    seen := map[string]bool{}
+
+    for _, block := range config.blocks {
+        if !matchHostPatterns(block.patterns, alias) {
+            continue
+        }
+        for _, option := range block.options {
+
+            if option.keyword == "identityfile" {
+                result.IdentityFiles = append(result.IdentityFiles, option.value)
+                continue
+            }
+            if seen[option.keyword] {
+                continue
+            }
+            seen[option.keyword] = true
+
+            var err error
+
+            switch option.keyword {
+            case "hostname":
+                result.HostName = strings.ReplaceAll(option.value, "%h", alias)
+            case "user":
+                result.User = option.value
+            case "port":
+                _, err = strconv.ParseUint(option.value, 10, 16)
+                result.Port = option.value
+            case "connecttimeout":
+                result.ConnectTimeout, err = parseSshConfigSeconds(option.value)
+            case "serveraliveinterval":
+                result.ServerAliveInterval, err = parseSshConfigSeconds(option.value)
+            case "proxyjump":
+                if !strings.EqualFold(option.value, "none") {
+                    for _, jump := range strings.Split(option.value, ",") {
+                        result.ProxyJump = append(result.ProxyJump, strings.TrimSpace(jump))
+                    }
+                }
+            }
+
+            if err != nil {
+                return hostConfig{}, fmt.Errorf("%s:%d: invalid %s %q", config.path, option.line, option.keyword, option.value)
+            }
+        }
+    }
+
+    return result, nil
+}
+
+// matchHostPatterns reports whether alias matches a Host pattern list. A negated pattern (!pattern) that matches
+// excludes the alias regardless of any other pattern on the line.
+func matchHostPatterns(patterns []string, alias string) bool {
+
+    matched := false
+
+    for _, pattern := range patterns {
+        negated := strings.HasPrefix(pattern, "!")
+        pattern = strings.TrimPrefix(pattern, "!")
+        if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(alias)); ok {
+            if negated {
+                return false
+            }
+            matched = true
+        }
+    }
+
+    return matched
+}
+
+// parseSshConfigSeconds parses an ssh_config time value expressed in seconds
+func parseSshConfigSeconds(value string) (time.Duration, error) {
+    seconds, err := strconv.ParseUint(value, 10, 32)
+    if err != nil {
+        return 0, err
+    }
+    return time.Duration(seconds) * time.Second, nil
+}
+
+// expandIdentityFile expands the ~ prefix and %-tokens of an IdentityFile value. Relative paths are resolved against
+// sshDirectory.
+func expandIdentityFile(identityFile string, sshDirectory string, hostName string, user string) (string, error) {
+
+    replacer := strings.NewReplacer("%%", "%", "%h", hostName, "%r", user)
+    identityFile = replacer.Replace(identityFile)
+
+    if identityFile == "~" || strings.HasPrefix(identityFile, "~/") {
+        home, err := os.UserHomeDir()
+        if err != nil {
+            return "", fmt.Errorf("unable to expand %s: %v", identityFile, err)
+        }
+        identityFile = filepath.Join(home, identityFile[1:])
+    }
+
+    if !filepath.IsAbs(identityFile) {
+        identityFile = filepath.Join(sshDirectory, identityFile)
+    }
+
+    return identityFile, nil
+}
@@ -0,0 +1,373 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+package sshremote
+
+import (
+    "bufio"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path"
+    "path/filepath"
+    "strconv"
+    "strings"
+
+    "github.com/pkg/sftp"
+    "golang.org/x/crypto/ssh"
+)
+
+// Transfer directions
+const (
+    Upload   = "upload"
+    Download = "download"
+)
+
+// Transfer copies one file between the executor and the destination over SFTP. Uploads run before the command and
+// downloads after it.
+type Transfer struct {
+    Direction string
+
+    // Local is the path of the file on the executor; Remote is the absolute path of the file on the destination
+    Local  string
+    Remote string
+
+    // Mode is the permission bits given to the file written. Zero means 0644 for uploads and 0600 for downloads.
+    Mode os.FileMode
+
+    // Sha256, when set, is the hex SHA-256 digest the file must have. A download that does not match is discarded.
+    Sha256 string
+}
+
+// TransferResult reports the outcome of one Transfer
+type TransferResult struct {
+    Direction string  `json:"direction"`
+    Local     string  `json:"local"`
+    Remote    string  `json:"remote"`
+    Bytes     int64   `json:"bytes"`
+    Sha256    string  `json:"sha256,omitempty"`
+    Mode      string  `json:"mode,omitempty"`
+    Error     *string `json:"error"`
+}
+
+// ParseTransfer parses a transfer given on the command line. An upload is LOCAL:REMOTE[:MODE[:SHA256]] and a download
+// is REMOTE:LOCAL[:MODE[:SHA256]], where MODE is octal and either optional field may be empty.
+func ParseTransfer(direction, spec string) (Transfer, error) {
+
+    fields := strings.Split(spec, ":")
+    if len(fields) < 2 || len(fields) > 4 || fields[0] == "" || fields[1] == "" {
+        return Transfer{}, fmt.Errorf("invalid %s %q: want SOURCE:TARGET[:MODE[:SHA256]]", direction, spec)
+    }
+
+    transfer := Transfer{Direction: direction, Local: fields[0], Remote: fields[1]}
+    if direction == Download {
+        transfer.Local, transfer.Remote = fields[1], fields[0]
+    }
+
+    if len(fields) > 2 && fields[2] != "" {
+        mode, err := strconv.ParseUint(fields[2], 8, 32)
+        if err != nil || mode > 0o777 {
+            return Transfer{}, fmt.Errorf("invalid %s %q: mode must be octal permission bits", direction, spec)
+        }
+        transfer.Mode = os.FileMode(mode)
+    }
+
+    if len(fields) > 3 && fields[3] != "" {
+        digest, err := hex.DecodeString(fields[3])
+        if err != nil || len(digest) != sha256.Size {
+            return Transfer{}, fmt.Errorf("invalid %s %q: checksum must be a hex SHA-256 digest", direction, spec)
+        }
+        transfer.Sha256 = strings.ToLower(fields[3])
+    }
+
+    return transfer, nil
+}
+
+// TransferAllowlist lists the remote paths that may be uploaded to and downloaded from, and the local directory that
+// local paths are confined to
+type TransferAllowlist struct {
+    localRoot string
+    uploads   []string
+    downloads []string
+}
+
+// LoadTransferAllowlist reads the remote path allowlist at allowlistPath. Each line is "upload PATTERN" or "download
+// PATTERN", where PATTERN is an absolute path that may contain path.Match wildcards or end in / to allow everything
+// beneath a directory. Blank lines and lines starting with # are ignored. A missing file allows nothing. Local paths
+// are resolved against localRoot and may not leave it.
+func LoadTransferAllowlist(allowlistPath, localRoot string) (*TransferAllowlist, error) {
+
+    allowlist := &TransferAllowlist{localRoot: filepath.Clean(localRoot)}
+
+    file, err := os.Open(allowlistPath)
+    if errors.Is(err, os.ErrNotExist) {
+        return allowlist, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to read transfer allowlist: %v", err)
+    }
+    defer file.Close()
+
+    scanner := bufio.NewScanner(file)
+    lineNumber := 0
+
+    for scanner.Scan() {
+        lineNumber++
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+
+        fields := strings.Fields(line)
+        if len(fields) != 2 || !path.IsAbs(fields[1]) {
+            return nil, fmt.Errorf("%s:%d: want \"upload|download /absolute/pattern\"", allowlistPath, lineNumber)
+        }
+        if _, err := path.Match(fields[1], ""); err != nil {
+            return nil, fmt.Errorf("%s:%d: invalid pattern %s: %v", allowlistPath, lineNumber, fields[1], err)
+        }
+
+        switch fields[0] {
+        case Upload:
+            allowlist.uploads = append(allowlist.uploads, fields[1])
+        case Download:
+            allowlist.downloads = append(allowlist.downloads, fields[1])
+        default:
+            return nil, fmt.Errorf("%s:%d: unknown direction %s", allowlistPath, lineNumber, fields[0])
+        }
+    }
+
+    if err := scanner.Err(); err != nil {
+        return nil, fmt.Errorf("failed to read transfer allowlist: %v", err)
+    }
+
+    return allowlist, nil
+}
+
+// Resolve checks transfer against the allowlist and returns it with its local path made absolute and its remote path
+// cleaned
+func (a *TransferAllowlist) Resolve(transfer Transfer) (Transfer, error) {
+
+    remote := path.Clean(transfer.Remote)
+    if !path.IsAbs(remote) {
+        return Transfer{}, fmt.Errorf("remote path %s is not absolute", transfer.Remote)
+    }
+
+    patterns := a.uploads
+    if transfer.Direction == Download {
+        patterns = a.downloads
+    }
+
+    if !matchRemotePath(patterns, remote) {
+        return Transfer{}, fmt.Errorf("%s of remote path %s is not allowed", transfer.Direction, remote)
+    }
+
+    local := filepath.Join(a.localRoot, filepath.FromSlash(transfer.Local))
+    if filepath.IsAbs(transfer.Local) || !within(a.localRoot, local) {
+        return Transfer{}, fmt.Errorf("local path %s is outside %s", transfer.Local, a.localRoot)
+    }
+
+    // Refuse a local path that escapes the root through a symbolic link. A download may create directories, so check
+    // the nearest ancestor that exists.
+
+    existing := local
+    if transfer.Direction == Download {
+        existing = filepath.Dir(local)
+        for existing != a.localRoot {
+            if _, err := os.Stat(existing); !errors.Is(err, os.ErrNotExist) {
+                break
+            }
+            existing = filepath.Dir(existing)
+        }
+    }
+
+    root, err := filepath.EvalSymlinks(a.localRoot)
+    if err != nil {
+        return Transfer{}, fmt.Errorf("failed to resolve local root %s: %v", a.localRoot, err)
+    }
+    resolved, err := filepath.EvalSymlinks(existing)
+    if err != nil {
+        return Transfer{}, fmt.Errorf("failed to resolve local path %s: %v", transfer.Local, err)
+    }
+    if !within(root, resolved) {
+        return Transfer{}, fmt.Errorf("local path %s is outside %s", transfer.Local, a.localRoot)
+    }
+
+    transfer.Local = local
+    transfer.Remote = remote
+    return transfer, nil
+}
+
+// within reports whether target is root or lies beneath it
+func within(root, target string) bool {
+    relative, err := filepath.Rel(root, target)
+    return err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+func matchRemotePath(patterns []string, remote string) bool {
+    for _, pattern := range patterns {
+        if strings.HasSuffix(pattern, "/") {
+            if strings.HasPrefix(remote, pattern) {
+                return true
+            }
+            continue
+        }
+        if matched, _ := path.Match(pattern, remote); matched {
+            return true
+        }
+    }
+    return false
+}
+
+// transferFiles runs each transfer over an SFTP session on conn. It stops at the first failure and reports the
+// transfers that did not run as not attempted.
+func transferFiles(conn *ssh.Client, transfers []Transfer) ([]TransferResult, bool) {
+
+    results := make([]TransferResult, 0, len(transfers))
+
+    client, err := sftp.NewClient(conn)
+    if err != nil {
+        for _, transfer := range transfers {
+            results = append(results, failedTransfer(transfer, fmt.Errorf("failed to start SFTP: %v", err)))
+        }
+        return results, false
+    }
+    defer client.Close()
+
+    for i, transfer := range transfers {
+
+        var result TransferResult
+        if transfer.Direction == Upload {
+            result = upload(client, transfer)
+        } else {
+            result = download(client, transfer)
+        }
+        results = append(results, result)
+
+        if result.Error != nil {
+            for _, skipped := range transfers[i+1:] {
+                results = append(results, failedTransfer(skipped, errors.New("not attempted after an earlier transfer failed")))
+            }
+            return results, false
+        }
+    }
+
+    return results, true
+}
+
+// upload copies transfer.Local to transfer.Remote through a temporary file that is renamed into place once complete
+func upload(client *sftp.Client, transfer Transfer) TransferResult {
+
+    mode := transfer.Mode
+    if mode == 0 {
+        mode = 0o644
+    }
+
+    local, err := os.Open(transfer.Local)
+    if err != nil {
+        return failedTransfer(transfer, err)
+    }
+    defer local.Close()
+
+    temporary := transfer.Remote + ".webhook-upload"
+
+    remote, err := client.OpenFile(temporary, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+    if err != nil {
+        return failedTransfer(transfer, fmt.Errorf("failed to create %s: %v", temporary, err))
+    }
+
+    digest := sha256.New()
+    n, err := io.Copy(remote, io.TeeReader(local, digest))
+    if closeErr := remote.Close(); err == nil {
+        err = closeErr
+    }
+
+    result := transferResult(transfer, n, hex.EncodeToString(digest.Sum(nil)), mode)
+
+    if err == nil && transfer.Sha256 != "" && transfer.Sha256 != result.Sha256 {
+        err = fmt.Errorf("checksum mismatch: want %s", transfer.Sha256)
+    }
+    if err == nil {
+        err = client.Chmod(temporary, mode)
+    }
+    if err == nil {
+        err = client.PosixRename(temporary, transfer.Remote)
+    }
+    if err != nil {
+        client.Remove(temporary)
+        return withError(result, err)
+    }
+
+    return result
+}
+
+// download copies transfer.Remote to transfer.Local through a temporary file that is renamed into place once complete
+// and verified
+func download(client *sftp.Client, transfer Transfer) TransferResult {
+
+    mode := transfer.Mode
+    if mode == 0 {
+        mode = 0o600
+    }
+
+    remote, err := client.Open(transfer.Remote)
+    if err != nil {
+        return failedTransfer(transfer, err)
+    }
+    defer remote.Close()
+
+    if err := os.MkdirAll(filepath.Dir(transfer.Local), 0o700); err != nil {
+        return failedTransfer(transfer, err)
+    }
+
+    local, err := os.CreateTemp(filepath.Dir(transfer.Local), ".webhook-download-*")
+    if err != nil {
+        return failedTransfer(transfer, err)
+    }
+    defer os.Remove(local.Name())
+
+    digest := sha256.New()
+    n, err := io.Copy(io.MultiWriter(local, digest), remote)
+    if closeErr := local.Close(); err == nil {
+        err = closeErr
+    }
+
+    result := transferResult(transfer, n, hex.EncodeToString(digest.Sum(nil)), mode)
+
+    if err == nil && transfer.Sha256 != "" && transfer.Sha256 != result.Sha256 {
+        err = fmt.Errorf("checksum mismatch: want %s", transfer.Sha256)
+    }
+    if err == nil {
+        err = os.Chmod(local.Name(), mode)
+    }
+    if err == nil {
+        err = os.Rename(local.Name(), transfer.Local)
+    }
+    if err != nil {
+        return withError(result, err)
+    }
+
+    return result
+}
+
+func transferResult(transfer Transfer, n int64, sha256 string, mode os.FileMode) TransferResult {
+    return TransferResult{
+        Direction: transfer.Direction,
+        Local:     transfer.Local,
+        Remote:    transfer.Remote,
+        Bytes:     n,
+        Sha256:    sha256,
+        Mode:      fmt.Sprintf("%04o", mode),
+    }
+}
+
+func failedTransfer(transfer Transfer, err error) TransferResult {
+    return withError(TransferResult{Direction: transfer.Direction, Local: transfer.Local, Remote: transfer.Remote}, err)
+}
+
+func withError(result TransferResult, err error) TransferResult {
+    message := err.Error()
+    result.Error = &message
+    return result
+}
@@ -0,0 +1,210 @@
+package sshremote
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestParseTransfer(t *testing.T) {
+    digest := strings.Repeat("ab", 32)
+
+    tests := []struct {
+        direction string
+        spec      string
+        want      Transfer
+        wantErr   bool
+    }{
+        {direction: Upload, spec: "compose.yml:/srv/app/compose.yml", want: Transfer{Direction: Upload, Local: "compose.yml", Remote: "/srv/app/compose.yml"}},
+        {direction: Upload, spec: ".env:/srv/app/.env:0600:" + digest, want: Transfer{Direction: Upload, Local: ".env", Remote: "/srv/app/.env", Mode: 0o600, Sha256: digest}},
+        {direction: Download, spec: "/var/log/app.log:logs/app.log::" + strings.ToUpper(digest), want: Transfer{Direction: Download, Local: "logs/app.log", Remote: "/var/log/app.log", Sha256: digest}},
+        {direction: Upload, spec: "compose.yml", wantErr: true},
+        {direction: Upload, spec: "a:b:999", wantErr: true},
+        {direction: Upload, spec: "a:b:0644:abc", wantErr: true},
+        {direction: Upload, spec: "a:b:0644:" + digest + ":extra", wantErr: true},
+    }
+
+    for _, tt := range tests {
+        got, err := ParseTransfer(tt.direction, tt.spec)
+        if tt.wantErr {
+            if err == nil {
+                t.Fatalf("%s: want error", tt.spec)
+            }
+            continue
+        }
+        if err != nil || got != tt.want {
+            t.Fatalf("%s: want %+v, got %+v (err %v)", tt.spec, tt.want, got, err)
+        }
+    }
+}
+
+func TestTransferAllowlist_Resolve(t *testing.T) {
+    configDirectory := t.TempDir()
+    localRoot := filepath.Join(configDirectory, "files")
+    outside := t.TempDir()
+
+    os.MkdirAll(localRoot, 0o700)
+    os.WriteFile(filepath.Join(localRoot, "compose.yml"), []byte("services: {}\n"), 0o600)
+    os.WriteFile(filepath.Join(outside, "secret"), []byte("secret\n"), 0o600)
+    os.Symlink(filepath.Join(outside, "secret"), filepath.Join(localRoot, "link"))
+    os.Symlink(outside, filepath.Join(localRoot, "linked-dir"))
+
+    allowlistPath := filepath.Join(configDirectory, "sftp_allowlist")
+    os.WriteFile(allowlistPath, []byte("# deployments\nupload /srv/app/*.yml\ndownload /var/log/app/\n"), 0o600)
+
+    allowlist, err := LoadTransferAllowlist(allowlistPath, localRoot)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    tests := []struct {
+        name     string
+        transfer Transfer
+        wantErr  string
+    }{
+        {name: "allowed upload", transfer: Transfer{Direction: Upload, Local: "compose.yml", Remote: "/srv/app/compose.yml"}},
+        {name: "allowed download into a new directory", transfer: Transfer{Direction: Download, Local: "logs/today/app.log", Remote: "/var/log/app/deploy.log"}},
+        {name: "remote path not allowed", transfer: Transfer{Direction: Upload, Local: "compose.yml", Remote: "/etc/passwd"}, wantErr: "not allowed"},
+        {name: "direction not allowed", transfer: Transfer{Direction: Download, Local: "compose.yml", Remote: "/srv/app/compose.yml"}, wantErr: "not allowed"},
+        {name: "remote traversal", transfer: Transfer{Direction: Upload, Local: "compose.yml", Remote: "/srv/app/../../etc/x.yml"}, wantErr: "not allowed"},
+        {name: "relative remote path", transfer: Transfer{Direction: Upload, Local: "compose.yml", Remote: "app.yml"}, wantErr: "not absolute"},
+        {name: "local traversal", transfer: Transfer{Direction: Upload, Local: "../sftp_allowlist", Remote: "/srv/app/a.yml"}, wantErr: "outside"},
+        {name: "absolute local path", transfer: Transfer{Direction: Upload, Local: "/etc/passwd", Remote: "/srv/app/a.yml"}, wantErr: "outside"},
+        {name: "symlink out of the root", transfer: Transfer{Direction: Upload, Local: "link", Remote: "/srv/app/a.yml"}, wantErr: "outside"},
+        {name: "download through a linked directory", transfer: Transfer{Direction: Download, Local: "linked-dir/new/app.log", Remote: "/var/log/app/a.log"}, wantErr: "outside"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            resolved, err := allowlist.Resolve(tt.transfer)
+            if tt.wantErr != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                    t.Fatalf("want error containing %q, got %v", tt.wantErr, err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("unexpected error: %v", err)
+            }
+            if !filepath.IsAbs(resolved.Local) || !strings.HasPrefix(resolved.Local, localRoot) {
+                t.Fatalf("want local path resolved under %s, got %s", localRoot, resolved.Local)
+            }
+        })
+    }
+}
+
+func TestTransferAllowlist_Missing(t *testing.T) {
+    allowlist, err := LoadTransferAllowlist(filepath.Join(t.TempDir(), "missing"), t.TempDir())
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if _, err := allowlist.Resolve(Transfer{Direction: Upload, Local: "a", Remote: "/tmp/a"}); err == nil {
+        t.Fatalf("want a missing allowlist to allow nothing")
+    }
+}
+
+func TestExecuteRemoteCommand_Transfers(t *testing.T) {
+    var uploaded []byte
+    remoteDirectory := t.TempDir()
+    server := startTestServer(t, func(session *testSession) uint32 {
+        uploaded, _ = os.ReadFile(filepath.Join(remoteDirectory, "compose.yml"))
+        os.WriteFile(filepath.Join(remoteDirectory, "deploy.log"), []byte("deployed\n"), 0o644)
+        return 0
+    })
+
+    localDirectory := t.TempDir()
+    payload := []byte("services: {}\n")
+    os.WriteFile(filepath.Join(localDirectory, "compose.yml"), payload, 0o600)
+    digest := sha256.Sum256(payload)
+
+    transfers := []Transfer{
+        {Direction: Download, Local: filepath.Join(localDirectory, "logs", "deploy.log"), Remote: filepath.Join(remoteDirectory, "deploy.log")},
+        {Direction: Upload, Local: filepath.Join(localDirectory, "compose.yml"), Remote: filepath.Join(remoteDirectory, "compose.yml"), Mode: 0o640, Sha256: hex.EncodeToString(digest[:])},
+    }
+    os.MkdirAll(filepath.Join(localDirectory, "logs"), 0o700)
+
+    response := ExecuteRemoteCommand(newTestDestination(t, server), "docker compose up -d", ExecuteOptions{Transfers: transfers})
+
+    if response.Status != 0 {
+        t.Fatalf("want status 0, got %d: %s", response.Status, deref(response.Error))
+    }
+    if string(uploaded) != string(payload) {
+        t.Fatalf("want the upload in place before the command ran, got %q", uploaded)
+    }
+    if len(response.Transfers) != 2 || response.Transfers[0].Direction != Upload || response.Transfers[1].Direction != Download {
+        t.Fatalf("want the upload reported before the download, got %+v", response.Transfers)
+    }
+    for _, result := range response.Transfers {
+        if result.Error != nil {
+            t.Fatalf("%s %s: unexpected error: %s", result.Direction, result.Remote, *result.Error)
+        }
+    }
+    if upload := response.Transfers[0]; upload.Bytes != int64(len(payload)) || upload.Sha256 != hex.EncodeToString(digest[:]) || upload.Mode != "0640" {
+        t.Fatalf("unexpected upload result: %+v", upload)
+    }
+    if info, err := os.Stat(filepath.Join(remoteDirectory, "compose.yml")); err != nil || info.Mode().Perm() != 0o640 {
+        t.Fatalf("want the uploaded file with mode 0640, got %v (err %v)", info.Mode(), err)
+    }
+    downloaded, err := os.ReadFile(filepath.Join(localDirectory, "logs", "deploy.log"))
+    if err != nil || string(downloaded) != "deployed\n" || response.Transfers[1].Bytes != 9 {
+        t.Fatalf("want the log downloaded after the command, got %q (err %v)", downloaded, err)
+    }
+}
+
+func TestExecuteRemoteCommand_FailedUploadSkipsCommand(t *testing.T) {
+    ran := false
+    server := startTestServer(t, func(session *testSession) uint32 {
+        ran = true
+        return 0
+    })
+
+    localDirectory := t.TempDir()
+    os.WriteFile(filepath.Join(localDirectory, "compose.yml"), []byte("services: {}\n"), 0o600)
+
+    transfers := []Transfer{
+        {Direction: Upload, Local: filepath.Join(localDirectory, "compose.yml"), Remote: filepath.Join(t.TempDir(), "compose.yml"), Sha256: strings.Repeat("00", 32)},
+        {Direction: Download, Local: filepath.Join(localDirectory, "deploy.log"), Remote: "/var/log/deploy.log"},
+    }
+
+    response := ExecuteRemoteCommand(newTestDestination(t, server), "docker compose up -d", ExecuteOptions{Transfers: transfers})
+
+    if ran || response.Reason != "Transfer Failed" || response.Status != -1 || response.Attempts != 1 {
+        t.Fatalf("want the command skipped with reason Transfer Failed, got ran %v reason %q status %d", ran, response.Reason, response.Status)
+    }
+    if len(response.Transfers) != 2 || response.Transfers[0].Error == nil || !strings.Contains(*response.Transfers[0].Error, "checksum mismatch") {
+        t.Fatalf("want a checksum mismatch reported for the upload, got %+v", response.Transfers)
+    }
+    if response.Transfers[1].Error == nil || !strings.Contains(*response.Transfers[1].Error, "not attempted") {
+        t.Fatalf("want the download reported as not attempted, got %+v", response.Transfers[1])
+    }
+}
+
+func TestExecuteRemoteCommand_SessionFailureKeepsUploads(t *testing.T) {
+    ran := false
+    server := startTestServer(t, func(session *testSession) uint32 {
+        ran = true
+        return 0
+    })
+
+    localDirectory := t.TempDir()
+    os.WriteFile(filepath.Join(localDirectory, "compose.yml"), []byte("services: {}\n"), 0o600)
+    remote := filepath.Join(t.TempDir(), "compose.yml")
+
+    transfers := []Transfer{{Direction: Upload, Local: filepath.Join(localDirectory, "compose.yml"), Remote: remote}}
+    options := ExecuteOptions{Transfers: transfers, Pty: &PtyOptions{Term: "unsupported", Width: 80, Height: 24}}
+
+    response := ExecuteRemoteCommand(newTestDestination(t, server), "docker compose up -d", options)
+
+    if ran || response.Reason != "SSH Error" || response.Status != -1 || response.Attempts != 1 {
+        t.Fatalf("want the command skipped with reason SSH Error, got ran %v reason %q status %d attempts %d", ran, response.Reason, response.Status, response.Attempts)
+    }
+    if len(response.Transfers) != 1 || response.Transfers[0].Error != nil {
+        t.Fatalf("want the completed upload reported, got %+v", response.Transfers)
+    }
+    if _, err := os.Stat(remote); err != nil {
+        t.Fatalf("want the uploaded file in place: %v", err)
+    }
+}
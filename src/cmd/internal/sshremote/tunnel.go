@@ -0,0 +1,58 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+package sshremote
+
+import (
+    "net"
+
+    "golang.org/x/crypto/ssh"
+)
+
+// Tunnel is an open connection to a destination through which services listening on the remote host are reached
+type Tunnel struct {
+    destination   *Destination
+    conn          *ssh.Client
+    closeConn     func()
+    stopKeepAlive chan<- struct{}
+    attemptErrors []error
+}
+
+// OpenTunnel connects to destination as ExecuteRemoteCommand does, retrying transient failures under retry until cancel
+// is closed. When no connection can be made it returns the response reporting why.
+func OpenTunnel(destination *Destination, retry RetryPolicy, cancel <-chan struct{}) (*Tunnel, *Response) {
+
+    conn, closeConn, attemptErrors, dialReason := dialWithRetry(destination, retry, cancel)
+    if conn == nil {
+        response := dialFailure(attemptErrors, dialReason)
+        return nil, &response
+    }
+
+    tunnel := &Tunnel{destination: destination, conn: conn, closeConn: closeConn, attemptErrors: attemptErrors}
+
+    if destination.ServerAliveInterval > 0 {
+        tunnel.stopKeepAlive = keepAlive(conn, destination.ServerAliveInterval)
+    }
+
+    return tunnel, nil
+}
+
+// DialUnix connects to the Unix socket at socketPath on the remote host over a direct-streamlocal channel, as
+// ssh -L does for a socket. The server must allow it: see AllowStreamLocalForwarding in sshd_config.
+func (t *Tunnel) DialUnix(socketPath string) (net.Conn, error) {
+    return t.conn.Dial("unix", socketPath)
+}
+
+// Fill records the identity that authenticated and the connection attempts in response
+func (t *Tunnel) Fill(response *Response) {
+    response.Identity = t.destination.authenticatedIdentity()
+    response.Attempts = len(t.attemptErrors) + 1
+    response.AttemptErrors = errorStrings(t.attemptErrors)
+}
+
+// Close closes the connection and every channel opened through it
+func (t *Tunnel) Close() {
+    if t.stopKeepAlive != nil {
+        close(t.stopKeepAlive)
+    }
+    t.closeConn()
+}
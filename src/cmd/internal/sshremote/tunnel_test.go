@@ -0,0 +1,67 @@
+package sshremote
+
+import (
+    "bufio"
+    "net"
+    "path/filepath"
+    "testing"
+)
+
+func TestOpenTunnel_DialUnix(t *testing.T) {
+    socketPath := filepath.Join(t.TempDir(), "echo.sock")
+    listener, err := net.Listen("unix", socketPath)
+    if err != nil {
+        t.Fatalf("failed to listen: %v", err)
+    }
+    t.Cleanup(func() { listener.Close() })
+    go func() {
+        for {
+            conn, err := listener.Accept()
+            if err != nil {
+                return
+            }
+            go func() {
+                defer conn.Close()
+                line, _ := bufio.NewReader(conn).ReadString('\n')
+                conn.Write([]byte("echo " + line))
+            }()
+        }
+    }()
+
+    server := startTestServer(t, nil)
+
+    tunnel, failure := OpenTunnel(newTestDestination(t, server), RetryPolicy{}, nil)
+    if failure != nil {
+        t.Fatalf("unexpected failure: %s", deref(failure.Error))
+    }
+    defer tunnel.Close()
+
+    conn, err := tunnel.DialUnix(socketPath)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    defer conn.Close()
+
+    conn.Write([]byte("ping\n"))
+    reply, err := bufio.NewReader(conn).ReadString('\n')
+    if err != nil || reply != "echo ping\n" {
+        t.Fatalf("want the reply through the tunnel, got %q (err %v)", reply, err)
+    }
+
+    var response Response
+    tunnel.Fill(&response)
+    if response.Attempts != 1 || response.AttemptErrors != nil {
+        t.Fatalf("want one attempt, got %+v", response)
+    }
+}
+
+func TestOpenTunnel_Refused(t *testing.T) {
+    server := startTestServer(t, nil)
+    destination := newTestDestination(t, server)
+    server.listener.Close()
+
+    tunnel, failure := OpenTunnel(destination, RetryPolicy{Attempts: 1}, nil)
+    if tunnel != nil || failure == nil || failure.Reason != "Connection Refused" {
+        t.Fatalf("want the connection refused, got %v %+v", tunnel, failure)
+    }
+}
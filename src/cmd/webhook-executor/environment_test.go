@@ -0,0 +1,41 @@
+package main
+
+import (
+    "net"
+    "testing"
+
+    "github.com/golang-jwt/jwt/v5"
+)
+
+func TestNewEnvironment(t *testing.T) {
+    token := &jwt.Token{Claims: jwt.MapClaims{"sub": "deployer", "repo": "NobleFactor/site", "run-id": float64(42)}}
+    clientIps := []net.IP{net.ParseIP("203.0.113.7"), net.ParseIP("10.0.0.1")}
+
+    environment := newEnvironment(remoteEnvironmentVariables, []string{"repo", "run-id", "missing"}, token, "cid-1", clientIps)
+
+    want := map[string]string{
+        "WEBHOOK_CORRELATION_ID": "cid-1",
+        "WEBHOOK_SUBJECT":        "deployer",
+        "WEBHOOK_CLIENT_IP":      "203.0.113.7",
+        "WEBHOOK_CLAIM_REPO":     "NobleFactor/site",
+        "WEBHOOK_CLAIM_RUN_ID":   "42",
+    }
+    if len(environment) != len(want) {
+        t.Fatalf("want %v, got %v", want, environment)
+    }
+    for name, value := range want {
+        if environment[name] != value {
+            t.Fatalf("%s: want %q, got %q", name, value, environment[name])
+        }
+    }
+}
+
+func TestNewEnvironment_OnlyListedVariables(t *testing.T) {
+    token := &jwt.Token{Claims: jwt.MapClaims{"sub": "deployer"}}
+
+    environment := newEnvironment([]string{"WEBHOOK_CORRELATION_ID", "WEBHOOK_CLIENT_IP"}, nil, token, "cid-1", nil)
+
+    if len(environment) != 1 || environment["WEBHOOK_CORRELATION_ID"] != "cid-1" {
+        t.Fatalf("want only the correlation ID, got %v", environment)
+    }
+}
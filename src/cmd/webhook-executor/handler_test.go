@@ -20,6 +20,7 @@ func TestHandler_AttachesAuthTokenWhenRefreshed(t *testing.T) {
     loc := "handler-location"
     claims := jwt.MapClaims{
         "sub": loc,
+        "iss": "webhook-executor",
         "iat": now.Unix(),
         "exp": now.Add(5 * time.Second).Unix(),
     }
@@ -0,0 +1,28 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestCheckKnownHostsFile(t *testing.T) {
+    configDirectory := t.TempDir()
+    knownHosts := filepath.Join(configDirectory, "ssh", "known_hosts")
+
+    err := checkKnownHostsFile(configDirectory)
+    if err == nil || !strings.Contains(err.Error(), knownHosts) {
+        t.Fatalf("want an error naming %s, got %v", knownHosts, err)
+    }
+
+    if err := os.MkdirAll(filepath.Dir(knownHosts), 0o700); err != nil {
+        t.Fatalf("failed to create ssh directory: %v", err)
+    }
+    if err := os.WriteFile(knownHosts, nil, 0o600); err != nil {
+        t.Fatalf("failed to write known_hosts: %v", err)
+    }
+    if err := checkKnownHostsFile(configDirectory); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+}
@@ -3,18 +3,32 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/NobleFactor/docker-webhook/cmd/internal/argparse"
 	"github.com/NobleFactor/docker-webhook/cmd/internal/azure"
+	"github.com/NobleFactor/docker-webhook/cmd/internal/catalog"
+	"github.com/NobleFactor/docker-webhook/cmd/internal/executor"
 	"github.com/NobleFactor/docker-webhook/cmd/internal/jwt"
+	"github.com/NobleFactor/docker-webhook/cmd/internal/policy"
 	"github.com/NobleFactor/docker-webhook/cmd/internal/sshremote"
+	gojwt "github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
@@ -49,7 +63,11 @@ func main() {
 
 	log.SetPrefix(fmt.Sprintf("[webhook-executor][%s] ", correlationId))
 
-	log.Printf("Arguments parsed successfully: destination=%s, command=%s, client-ips=%v", parsed.Destination, parsed.Command, parsed.ClientIps)
+	if parsed.Output == argparse.OutputNdjson {
+		stream = newNdjsonStream(os.Stdout, correlationId)
+	}
+
+	log.Printf("Arguments parsed successfully: destinations=%v, command=%s, action=%s, params=%v, client-ips=%v", parsed.Destinations, parsed.Command, parsed.Action, parsed.Params, parsed.ClientIps)
 
 	// Validate environment early
 
@@ -61,7 +79,7 @@ func main() {
 		return
 	}
 
-	secretName, err := getSecretName()
+	configDirectory, err := getConfigDirectory()
 	if err != nil {
 		message := err.Error()
 		log.Printf("[ERROR] %s", message)
@@ -69,7 +87,63 @@ func main() {
 		return
 	}
 
-	configDirectory, err := getConfigDirectory()
+	publicKeyFiles, err := getJwtPublicKeyFiles(configDirectory)
+	if err != nil {
+		message := err.Error()
+		log.Printf("[ERROR] %s", message)
+		outputJson(sshremote.Response{Error: &message, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+		return
+	}
+
+	publicKeySecretNames, err := getJwtPublicKeySecretNames()
+	if err != nil {
+		message := err.Error()
+		log.Printf("[ERROR] %s", message)
+		outputJson(sshremote.Response{Error: &message, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+		return
+	}
+
+	jwks, err := getJwks(configDirectory)
+	if err != nil {
+		message := err.Error()
+		log.Printf("[ERROR] %s", message)
+		outputJson(sshremote.Response{Error: &message, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+		return
+	}
+
+	secretName, err := getSecretName(len(publicKeyFiles) > 0 || len(publicKeySecretNames) > 0 || jwks != nil)
+	if err != nil {
+		message := err.Error()
+		log.Printf("[ERROR] %s", message)
+		outputJson(sshremote.Response{Error: &message, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+		return
+	}
+
+	previousSecretVersions, err := getPreviousSecretVersions()
+	if err != nil {
+		message := err.Error()
+		log.Printf("[ERROR] %s", message)
+		outputJson(sshremote.Response{Error: &message, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+		return
+	}
+
+	signingKeyFile, err := getJwtSigningKey(configDirectory)
+	if err != nil {
+		message := err.Error()
+		log.Printf("[ERROR] %s", message)
+		outputJson(sshremote.Response{Error: &message, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+		return
+	}
+
+	jwtAlgorithms, err := getJwtAlgorithms()
+	if err != nil {
+		message := err.Error()
+		log.Printf("[ERROR] %s", message)
+		outputJson(sshremote.Response{Error: &message, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+		return
+	}
+
+	jwtProfile, err := getJwtProfile()
 	if err != nil {
 		message := err.Error()
 		log.Printf("[ERROR] %s", message)
@@ -95,34 +169,181 @@ func main() {
 		return
 	}
 
-	log.Printf("WEBHOOK_KEYVAULT_URL         : %s", keyVaultURL)
-	log.Printf("WEBHOOK_CONFIG               : %s", configDirectory)
-	log.Printf("WEBHOOK_LOCATION             : %s", location)
-	log.Printf("WEBHOOK_TOKEN_SECRET_NAME    : %s", secretName)
-	log.Printf("WEBHOOK_TOKEN_TTL            : %s", tokenTtl)
-	log.Printf("WEBHOOK_TOKEN_REFRESH_WINDOW : %s", tokenRefreshWindow)
+	maxSessionAge, err := getTokenMaxSessionAge()
+	if err != nil {
+		message := err.Error()
+		log.Printf("[ERROR] %s", message)
+		outputJson(sshremote.Response{Error: &message, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+		return
+	}
+
+	passphraseSecretPrefix, err := getSshPassphraseSecretPrefix()
+	if err != nil {
+		message := err.Error()
+		log.Printf("[ERROR] %s", message)
+		outputJson(sshremote.Response{Error: &message, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+		return
+	}
+
+	certificateAuthorityKey, err := getSshCertificateAuthorityKey(configDirectory)
+	if err != nil {
+		message := err.Error()
+		log.Printf("[ERROR] %s", message)
+		outputJson(sshremote.Response{Error: &message, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+		return
+	}
+
+	certificateTtl, err := getSshCertificateTtl()
+	if err != nil {
+		message := err.Error()
+		log.Printf("[ERROR] %s", message)
+		outputJson(sshremote.Response{Error: &message, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+		return
+	}
+
+	commandTimeout, err := getCommandTimeout()
+	if err != nil {
+		message := err.Error()
+		log.Printf("[ERROR] %s", message)
+		outputJson(sshremote.Response{Error: &message, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+		return
+	}
+
+	outputLimit, err := getOutputLimit()
+	if err != nil {
+		message := err.Error()
+		log.Printf("[ERROR] %s", message)
+		outputJson(sshremote.Response{Error: &message, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+		return
+	}
+
+	heartbeatInterval, err := getHeartbeatInterval()
+	if err != nil {
+		message := err.Error()
+		log.Printf("[ERROR] %s", message)
+		outputJson(sshremote.Response{Error: &message, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+		return
+	}
+
+	fanOutParallelism, err := getFanOutParallelism()
+	if err != nil {
+		message := err.Error()
+		log.Printf("[ERROR] %s", message)
+		outputJson(sshremote.Response{Error: &message, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+		return
+	}
+
+	stdinLimit, err := getStdinLimit()
+	if err != nil {
+		message := err.Error()
+		log.Printf("[ERROR] %s", message)
+		outputJson(sshremote.Response{Error: &message, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+		return
+	}
+
+	stdinDirectory, err := getStdinDirectory(configDirectory)
+	if err != nil {
+		message := err.Error()
+		log.Printf("[ERROR] %s", message)
+		outputJson(sshremote.Response{Error: &message, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+		return
+	}
+
+	remoteEnvironment, err := getRemoteEnvironment()
+	if err != nil {
+		message := err.Error()
+		log.Printf("[ERROR] %s", message)
+		outputJson(sshremote.Response{Error: &message, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+		return
+	}
+
+	remoteEnvironmentClaims := getRemoteEnvironmentClaims()
+
+	retryPolicy, err := getDialRetryPolicy()
+	if err != nil {
+		message := err.Error()
+		log.Printf("[ERROR] %s", message)
+		outputJson(sshremote.Response{Error: &message, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+		return
+	}
+
+	policyPath := getPolicyPath(configDirectory)
+	knownHostsErr := checkKnownHostsFile(configDirectory)
+	dockerSocket := getDockerSocket()
+	remoteDockerSocket := getRemoteDockerSocket()
+	localPath := getLocalPath()
+
+	log.Printf("WEBHOOK_KEYVAULT_URL                   : %s", keyVaultURL)
+	log.Printf("WEBHOOK_CONFIG                         : %s", configDirectory)
+	log.Printf("WEBHOOK_LOCATION                       : %s", location)
+	log.Printf("WEBHOOK_TOKEN_SECRET_NAME              : %s", secretName)
+	log.Printf("WEBHOOK_TOKEN_SECRET_PREVIOUS_VERSIONS : %d", previousSecretVersions)
+	log.Printf("WEBHOOK_JWT_ALGORITHMS                 : %s", strings.Join(jwtAlgorithms, ","))
+	log.Printf("WEBHOOK_JWT_PUBLIC_KEYS                : %s", strings.Join(publicKeyFiles, ","))
+	log.Printf("WEBHOOK_JWT_PUBLIC_KEY_SECRET_NAMES    : %s", strings.Join(publicKeySecretNames, ","))
+	log.Printf("WEBHOOK_JWT_SIGNING_KEY                : %s", signingKeyFile)
+	if jwks != nil {
+		log.Printf("WEBHOOK_JWT_JWKS                       : %s", jwks.Location)
+		log.Printf("WEBHOOK_JWT_JWKS_CACHE                 : %s", jwks.CachePath)
+		log.Printf("WEBHOOK_JWT_JWKS_REFETCH_INTERVAL      : %s", jwks.RefetchInterval)
+		log.Printf("WEBHOOK_JWT_JWKS_MAX_STALE             : %s", jwks.MaxStale)
+	}
+	log.Printf("WEBHOOK_JWT_ISSUER                     : %s", jwtProfile.Issuer)
+	log.Printf("WEBHOOK_JWT_REQUIRED_CLAIMS            : %s", strings.Join(jwtProfile.RequiredClaims, ","))
+	log.Printf("WEBHOOK_JWT_AUDIENCES                  : %s", strings.Join(jwtProfile.Audiences, ","))
+	log.Printf("WEBHOOK_JWT_MAX_AGE                    : %s", jwtProfile.MaxAge)
+	log.Printf("WEBHOOK_JWT_LEEWAY                     : %s", jwtProfile.Leeway)
+	log.Printf("WEBHOOK_TOKEN_TTL                      : %s", tokenTtl)
+	log.Printf("WEBHOOK_TOKEN_REFRESH_WINDOW           : %s", tokenRefreshWindow)
+	log.Printf("WEBHOOK_TOKEN_MAX_SESSION_AGE          : %s", maxSessionAge)
+	log.Printf("WEBHOOK_SSH_PASSPHRASE_SECRET_PREFIX   : %s", passphraseSecretPrefix)
+	log.Printf("WEBHOOK_SSH_CA_KEY                     : %s", certificateAuthorityKey)
+	log.Printf("WEBHOOK_SSH_CERTIFICATE_TTL            : %s", certificateTtl)
+	log.Printf("WEBHOOK_COMMAND_TIMEOUT                : %s", commandTimeout)
+	log.Printf("WEBHOOK_OUTPUT_HEAD_BYTES              : %d", outputLimit.Head)
+	log.Printf("WEBHOOK_OUTPUT_TAIL_BYTES              : %d", outputLimit.Tail)
+	log.Printf("WEBHOOK_HEARTBEAT_INTERVAL             : %s", heartbeatInterval)
+	log.Printf("WEBHOOK_FANOUT_PARALLELISM             : %d", fanOutParallelism)
+	log.Printf("WEBHOOK_STDIN_MAX_BYTES                : %d", stdinLimit)
+	log.Printf("WEBHOOK_STDIN_DIRECTORY                : %s", stdinDirectory)
+	log.Printf("WEBHOOK_SSH_DIAL_ATTEMPTS              : %d", retryPolicy.Attempts)
+	log.Printf("WEBHOOK_SSH_DIAL_BACKOFF               : %s", retryPolicy.InitialBackoff)
+	log.Printf("WEBHOOK_SSH_DIAL_MAX_BACKOFF           : %s", retryPolicy.MaxBackoff)
+	log.Printf("WEBHOOK_REMOTE_ENV                     : %s", strings.Join(remoteEnvironment, ","))
+	log.Printf("WEBHOOK_REMOTE_ENV_CLAIMS              : %s", strings.Join(remoteEnvironmentClaims, ","))
+	log.Printf("WEBHOOK_POLICY                         : %s", policyPath)
+	log.Printf("WEBHOOK_DOCKER_SOCKET                  : %s", dockerSocket)
+	log.Printf("WEBHOOK_REMOTE_DOCKER_SOCKET           : %s", remoteDockerSocket)
+	log.Printf("WEBHOOK_LOCAL_PATH                     : %s", localPath)
+	if knownHostsErr != nil {
+		log.Printf("[WARN] %v; SSH destinations are refused", knownHostsErr)
+	}
 
-	destination := parsed.Destination
+	destinations := parsed.Destinations
 	command := parsed.Command
-	authHeader := parsed.AuthHeader // Fetch JWT secret from Azure Key Vault (once)
+	authHeader := parsed.AuthHeader // Load the keys that verify and refresh the JWT (once)
 
-	var jwtSecret []byte
+	tokenKeys := &jwt.Keys{Profile: jwtProfile, MaxSessionAge: maxSessionAge}
 
 	if authHeader != "" {
 		var err error
-		jwtSecret, err = azure.FetchSecretFromKeyVault(keyVaultURL, secretName)
+		tokenKeys, err = loadTokenKeys(keyVaultURL, secretName, previousSecretVersions, publicKeyFiles, publicKeySecretNames, signingKeyFile)
 		if err != nil {
-			log.Printf("[ERROR] Failed to fetch JWT secret from Azure Key Vault: %v", err)
-			errorStr := fmt.Sprintf("failed to fetch JWT secret: %v", err)
+			log.Printf("[ERROR] Failed to load JWT keys: %v", err)
+			errorStr := fmt.Sprintf("failed to load JWT keys: %v", err)
 			outputJson(sshremote.Response{Error: &errorStr, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
 			return
 		}
-		log.Printf("JWT secret fetched successfully from Azure Key Vault")
+		tokenKeys.JWKS = jwks
+		tokenKeys.Algorithms = jwtAlgorithms
+		tokenKeys.Profile = jwtProfile
+		tokenKeys.MaxSessionAge = maxSessionAge
+		log.Printf("JWT keys loaded successfully: HMAC secret version=%q, previous versions=%d, public keys=%d, signing key=%v, JWKS=%v", tokenKeys.SecretVersion, len(tokenKeys.PreviousSecrets), len(tokenKeys.PublicKeys), tokenKeys.SigningKey != nil, jwks != nil)
 	}
 
 	// Validate JWT (required) — returns parsed token for reuse by refresh
 
-	tokenStr, parsedToken, err := jwt.ValidateJWT(authHeader, string(jwtSecret), location)
+	tokenStr, parsedToken, err := tokenKeys.ValidateJWT(authHeader, location)
 	if err != nil {
 		log.Printf("[ERROR] JWT validation failed: %v", err)
 		errorStr := "invalid JWT"
@@ -135,11 +356,15 @@ func main() {
 	// Attempt to refresh the token. Field name in response: `authToken` (string)
 
 	var refreshedToken string
+	var reauthenticate bool
 
-	if authHeader != "" && len(jwtSecret) > 0 {
+	if authHeader != "" {
 		// Refresh if token is within configured window; new TTL = configured value
-		newTok, refreshed, err := jwt.RefreshJWT(parsedToken, tokenStr, string(jwtSecret), location, tokenRefreshWindow, tokenTtl)
-		if err != nil {
+		newTok, refreshed, err := tokenKeys.RefreshJWT(parsedToken, tokenStr, location, tokenRefreshWindow, tokenTtl)
+		if errors.Is(err, jwt.ErrReauthenticationRequired) {
+			log.Printf("[WARN] token not refreshed: %v", err)
+			reauthenticate = true
+		} else if err != nil {
 			log.Printf("[WARN] token refresh attempt failed: %v", err)
 		} else {
 			// always capture the token returned (either refreshed or the original)
@@ -150,24 +375,293 @@ func main() {
 		}
 	}
 
-	// Execute the remote command
+	// Render a catalog action into its command so that unknown or invalid parameters are rejected before connecting
+
+	var actionExitReasons map[int]string
+
+	if parsed.Action != "" {
+		actions, err := catalog.Load(filepath.Join(configDirectory, "catalog.json"))
+		if err != nil {
+			log.Printf("[ERROR] Failed to load the action catalog: %v", err)
+			errorStr := fmt.Sprintf("failed to load action catalog: %v", err)
+			outputJson(sshremote.Response{Error: &errorStr, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+			return
+		}
+		invocation, err := actions.Render(parsed.Action, parsed.Params, destinations)
+		if err != nil {
+			log.Printf("[ERROR] Action rejected: %v", err)
+			errorStr := fmt.Sprintf("invalid action: %v", err)
+			outputJson(sshremote.Response{Error: &errorStr, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+			return
+		}
+		destinations = invocation.Destinations
+		command = invocation.Command
+		actionExitReasons = invocation.ExitReasons
+		if invocation.Timeout > 0 {
+			commandTimeout = invocation.Timeout
+		}
+		log.Printf("Action %s rendered as: %s", invocation.Action, command)
+	}
+
+	// A Docker operation is authorized and logged as the docker command it stands for
+
+	var operation *executor.Operation
+
+	if parsed.Docker != "" {
+		parsedOperation, err := executor.ParseOperation(parsed.Docker, parsed.DockerTarget)
+		if err != nil {
+			log.Printf("[ERROR] Docker operation rejected: %v", err)
+			errorStr := fmt.Sprintf("invalid docker operation: %v", err)
+			outputJson(sshremote.Response{Error: &errorStr, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+			return
+		}
+		operation = &parsedOperation
+		command = operation.Command()
+		log.Printf("Docker operation: %s", command)
+	}
+
+	// Name what the command's exit codes mean. An action's own reasons win over those for its program.
+
+	exitReasonTable, err := sshremote.LoadExitReasonTable(filepath.Join(configDirectory, "exit_reasons.json"))
+	if err != nil {
+		log.Printf("[ERROR] Failed to load the exit reasons: %v", err)
+		errorStr := fmt.Sprintf("failed to load exit reasons: %v", err)
+		outputJson(sshremote.Response{Error: &errorStr, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+		return
+	}
+
+	exitReasons := sshremote.ExitReasons{}
+	for code, reason := range exitReasonTable.For(command) {
+		exitReasons[code] = reason
+	}
+	for code, reason := range actionExitReasons {
+		exitReasons[code] = reason
+	}
+
+	// Authorize the token's subject and roles for every destination and the command before anything is parsed or dialed
+
+	var accessPolicy *policy.Policy
+
+	if policyPath == "" {
+		log.Printf("[WARN] WEBHOOK_POLICY is none; every subject may run any command on any destination")
+	} else {
+		accessPolicy, err = policy.Load(policyPath)
+		if err != nil {
+			log.Printf("[ERROR] Failed to load the authorization policy: %v", err)
+			errorStr := fmt.Sprintf("failed to load authorization policy: %v", err)
+			outputJson(sshremote.Response{Error: &errorStr, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+			return
+		}
+		if len(accessPolicy.Rules) == 0 {
+			log.Printf("[WARN] No authorization rules at %s; every request is forbidden", policyPath)
+		}
+	}
+
+	rules := make(map[string]string, len(destinations))
+
+	for _, destination := range destinations {
+		rule, err := accessPolicy.Authorize(policy.Request{
+			Subject:         jwt.ClaimString(parsedToken, "sub"),
+			Roles:           jwt.ClaimStrings(parsedToken, "roles"),
+			Destination:     destination,
+			Command:         command,
+			ConfigDirectory: configDirectory,
+		})
+		if err != nil {
+			log.Printf("[WARN] Forbidden: correlation-id=%s %v", correlationId, err)
+			errorStr := fmt.Sprintf("forbidden: %v", err)
+			outputJson(sshremote.Response{Error: &errorStr, Status: -1, Reason: "Forbidden", CorrelationId: correlationId})
+			return
+		}
+		if rule != "" {
+			log.Printf("Policy rule %q allows the command on %s", rule, destination)
+		}
+		rules[destination] = rule
+	}
+
+	// Host keys are verified against known_hosts, so without it no SSH destination can be reached
+
+	for _, destination := range destinations {
+		if knownHostsErr != nil && executor.IsSsh(destination) {
+			message := knownHostsErr.Error()
+			log.Printf("[ERROR] %s", message)
+			outputJson(sshremote.Response{Error: &message, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+			return
+		}
+	}
+
+	// Resolve the pseudo-terminal before connecting so that invalid terminal modes fail fast
+
+	var pty *sshremote.PtyOptions
+
+	if parsed.Pty {
+		modes, err := sshremote.ParseTerminalModes(parsed.PtyModes)
+		if err != nil {
+			log.Printf("[ERROR] Invalid terminal modes: %v", err)
+			errorStr := fmt.Sprintf("invalid --pty-modes: %v", err)
+			outputJson(sshremote.Response{Error: &errorStr, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+			return
+		}
+		pty = &sshremote.PtyOptions{Term: parsed.PtyTerm, Width: parsed.PtyWidth, Height: parsed.PtyHeight, Modes: modes}
+		log.Printf("Allocating a %dx%d %s PTY; stderr will be merged into stdout", pty.Width, pty.Height, pty.Term)
+	}
 
-	log.Printf("Executing remote SSH command: ssh %s %s", destination, command)
+	// Check file transfers against the allowlist before connecting
 
-	destination, clientConfig, err := sshremote.ParseSshDestination(destination, configDirectory)
+	transfers, err := resolveTransfers(parsed.Uploads, parsed.Downloads, configDirectory)
+	if err == nil && len(parsed.Downloads) > 0 && len(destinations) > 1 {
+		err = fmt.Errorf("--download cannot be used with several destinations")
+	}
+	if err == nil && len(transfers) > 0 && certificateAuthorityKey != "" {
+		err = fmt.Errorf("WEBHOOK_SSH_CA_KEY certificates force a command, which the server runs in place of SFTP")
+	}
 	if err != nil {
-		log.Printf("[ERROR] SSH destination parsing failed: %v", err)
-		errorStr := "invalid SSH destination"
+		log.Printf("[ERROR] File transfer rejected: %v", err)
+		errorStr := fmt.Sprintf("invalid file transfer: %v", err)
 		outputJson(sshremote.Response{Error: &errorStr, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
 		return
 	}
 
-	response := sshremote.ExecuteRemoteCommand(destination, clientConfig, command)
+	// Read the payload for the remote command's stdin before connecting so that an oversized payload fails fast
+
+	var stdin []byte
+
+	if parsed.Stdin != "" {
+		stdin, err = readStdin(parsed.Stdin, stdinDirectory, stdinLimit)
+		if err != nil {
+			log.Printf("[ERROR] Failed to read stdin: %v", err)
+			errorStr := fmt.Sprintf("invalid stdin: %v", err)
+			outputJson(sshremote.Response{Error: &errorStr, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+			return
+		}
+		digest := sha256.Sum256(stdin)
+		log.Printf("Forwarding %d bytes of stdin with SHA-256 %x", len(stdin), digest)
+	}
+
+	// Execute the command on each destination's backend
+
+	if parsed.DryRun {
+		log.Printf("Dry run (handshake=%v) of command on %s: %s", parsed.Handshake, strings.Join(destinations, " "), command)
+	} else {
+		log.Printf("Executing command on %s: %s", strings.Join(destinations, " "), command)
+	}
+
+	passphrase := newPassphraseFunc(keyVaultURL, passphraseSecretPrefix)
+
+	executorConfig := executor.Config{
+		ConfigDirectory: configDirectory,
+		Passphrase:      passphrase,
+		DockerSocket:    dockerSocket,
+		LocalPath:       localPath,
+	}
+
+	environment := newEnvironment(remoteEnvironment, remoteEnvironmentClaims, parsedToken, correlationId, parsed.ClientIps)
+
+	executors := make([]executor.Executor, 0, len(destinations))
+
+	for _, destination := range destinations {
+
+		target, err := executor.New(destination, executorConfig)
+		if err != nil {
+			log.Printf("[ERROR] Destination parsing failed for %s: %v", destination, err)
+			errorStr := "invalid destination"
+			outputJson(sshremote.Response{Error: &errorStr, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+			return
+		}
+
+		// Scope an SSH login with a short-lived certificate derived from the token's claims when a CA key is configured
+
+		if sshTarget, ok := target.(*executor.SSH); ok && certificateAuthorityKey != "" {
+			request := newCertificateRequest(parsedToken, sshTarget.Destination.ClientConfig.User, command, correlationId, certificateTtl)
+			request.Environment = environment
+			request.PermitPty = pty != nil
+			request.PermitPortForwarding = operation != nil
+			err := issueCertificate(sshTarget.Destination, certificateAuthorityKey, passphrase, request)
+			if err != nil {
+				log.Printf("[ERROR] SSH certificate issuance failed for %s: %v", destination, err)
+				errorStr := "failed to issue SSH certificate"
+				outputJson(sshremote.Response{Error: &errorStr, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+				return
+			}
+			log.Printf("Issued SSH certificate %s for principals %v valid for %s", request.KeyId, request.Principals, certificateTtl)
+		}
+
+		if operation != nil {
+			target, err = executor.WithOperation(target, *operation, remoteDockerSocket)
+			if err != nil {
+				log.Printf("[ERROR] Docker operation rejected for %s: %v", destination, err)
+				errorStr := fmt.Sprintf("invalid docker operation: %v", err)
+				outputJson(sshremote.Response{Error: &errorStr, Status: -1, Reason: "Executor Error", CorrelationId: correlationId})
+				return
+			}
+		}
+
+		// Report what would run instead of running it
+
+		if parsed.DryRun {
+			target = executor.DryRun(target, rules[destination], parsed.Handshake)
+		}
+
+		executors = append(executors, target)
+	}
+
+	if parsed.Timeout > 0 {
+		timeout := limitTimeout(commandTimeout, parsed.Timeout)
+		if timeout != parsed.Timeout {
+			log.Printf("[WARN] Request asked for a %s command timeout; limited to %s", parsed.Timeout, timeout)
+		} else {
+			log.Printf("Command timeout overridden by request: %s", parsed.Timeout)
+		}
+		commandTimeout = timeout
+	}
+
+	options := sshremote.ExecuteOptions{
+		Timeout:     commandTimeout,
+		StdoutLimit: outputLimit,
+		StderrLimit: outputLimit,
+		Stdin:       stdin,
+		Pty:         pty,
+		Transfers:   transfers,
+		Retry:       retryPolicy,
+		ExitReasons: exitReasons,
+		Environment: environment,
+	}
+
+	// In NDJSON mode, keep the connection alive while the command is quiet
+
+	stopHeartbeat := func() {}
+
+	if stream != nil {
+		stopHeartbeat = stream.heartbeat(heartbeatInterval)
+	}
+
+	if len(executors) > 1 {
+		aggregate := executeOnAll(executors, command, options, parsed, fanOutParallelism)
+		stopHeartbeat()
+		aggregate.CorrelationId = correlationId
+		if refreshedToken != "" {
+			aggregate.AuthToken = &refreshedToken
+		}
+		aggregate.Reauthenticate = reauthenticate
+		log.Printf("Command execution completed on %d destinations: %d succeeded, %d failed, %d cancelled, %d skipped", aggregate.Total, aggregate.Succeeded, aggregate.Failed, aggregate.Cancelled, aggregate.Skipped)
+		outputAggregateJson(aggregate)
+		return
+	}
+
+	if stream != nil {
+		options.Output = stream.outputFor(destinations[0])
+		stream.start(destinations[0], command)
+	}
+
+	response := executors[0].Execute(command, options)
+	stopHeartbeat()
+	logResponse(response)
 	response.CorrelationId = correlationId
 	if refreshedToken != "" {
 		response.AuthToken = &refreshedToken
 	}
-	log.Printf("Remote SSH command execution completed")
+	response.Reauthenticate = reauthenticate
+	log.Printf("Command execution completed")
 	outputJson(response)
 }
 
@@ -187,16 +681,175 @@ func getKeyVaultURL() (string, error) {
 	return u, nil
 }
 
-// Validates the value of WEBHOOK_TOKEN_SECRET_NAME
-func getSecretName() (string, error) {
+// Validates the value of WEBHOOK_TOKEN_SECRET_NAME, which is optional when tokens may be verified with public keys
+func getSecretName(optional bool) (string, error) {
 	s := getenvOrDefault("WEBHOOK_TOKEN_SECRET_NAME", "")
-	if strings.TrimSpace(s) == "" {
-		return "", fmt.Errorf("WEBHOOK_TOKEN_SECRET_NAME is required")
+	if strings.TrimSpace(s) == "" && !optional {
+		return "", fmt.Errorf("WEBHOOK_TOKEN_SECRET_NAME is required unless WEBHOOK_JWT_PUBLIC_KEYS, WEBHOOK_JWT_PUBLIC_KEY_SECRET_NAMES or WEBHOOK_JWT_JWKS is set")
 	}
-	return s, nil
+	return strings.TrimSpace(s), nil
 }
 
-// Validates the value of WEBHOOK_CONFIG
+// Validates the value of WEBHOOK_TOKEN_SECRET_PREVIOUS_VERSIONS, how many enabled versions of the HMAC secret before
+// the current one still verify tokens
+func getPreviousSecretVersions() (int, error) {
+	s := getenvOrDefault("WEBHOOK_TOKEN_SECRET_PREVIOUS_VERSIONS", "0")
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid WEBHOOK_TOKEN_SECRET_PREVIOUS_VERSIONS: must not be negative: %s", s)
+	}
+	return n, nil
+}
+
+// Validates the value of WEBHOOK_JWT_ALGORITHMS, the algorithms tokens may be signed with. Empty accepts every
+// supported algorithm a configured key can verify.
+func getJwtAlgorithms() ([]string, error) {
+	algorithms := splitList(getenvOrDefault("WEBHOOK_JWT_ALGORITHMS", ""))
+	for _, algorithm := range algorithms {
+		if !slices.Contains(jwt.SupportedAlgorithms, algorithm) {
+			return nil, fmt.Errorf("invalid WEBHOOK_JWT_ALGORITHMS: unsupported algorithm %s: want one of %s", algorithm, strings.Join(jwt.SupportedAlgorithms, ", "))
+		}
+	}
+	return algorithms, nil
+}
+
+// Validates the values of WEBHOOK_JWT_ISSUER, WEBHOOK_JWT_REQUIRED_CLAIMS, WEBHOOK_JWT_AUDIENCES, WEBHOOK_JWT_MAX_AGE
+// and WEBHOOK_JWT_LEEWAY, the profile the claims of a valid token must satisfy. An issuer of "*" accepts any issuer and
+// required claims of "none" require none.
+func getJwtProfile() (*jwt.Profile, error) {
+	profile := jwt.DefaultProfile()
+
+	if issuer := strings.TrimSpace(getenvOrDefault("WEBHOOK_JWT_ISSUER", jwt.DefaultIssuer)); issuer == "*" {
+		profile.Issuer = ""
+	} else {
+		profile.Issuer = issuer
+	}
+
+	if claims := getenvOrDefault("WEBHOOK_JWT_REQUIRED_CLAIMS", "iss"); strings.TrimSpace(claims) == "none" {
+		profile.RequiredClaims = nil
+	} else {
+		profile.RequiredClaims = splitList(claims)
+	}
+
+	profile.Audiences = splitList(getenvOrDefault("WEBHOOK_JWT_AUDIENCES", ""))
+
+	maxAge, err := parseDurationEnv("WEBHOOK_JWT_MAX_AGE", "0s")
+	if err == nil && maxAge < 0 {
+		err = fmt.Errorf("invalid WEBHOOK_JWT_MAX_AGE: must not be negative")
+	}
+	if err != nil {
+		return nil, err
+	}
+	profile.MaxAge = maxAge
+
+	leeway, err := parseDurationEnv("WEBHOOK_JWT_LEEWAY", "0s")
+	if err == nil && leeway < 0 {
+		err = fmt.Errorf("invalid WEBHOOK_JWT_LEEWAY: must not be negative")
+	}
+	if err != nil {
+		return nil, err
+	}
+	profile.Leeway = leeway
+
+	return profile, nil
+}
+
+// Validates the value of WEBHOOK_JWT_PUBLIC_KEYS, PEM files of public keys that verify tokens, relative to
+// WEBHOOK_CONFIG unless absolute
+func getJwtPublicKeyFiles(configDirectory string) ([]string, error) {
+	files := splitList(getenvOrDefault("WEBHOOK_JWT_PUBLIC_KEYS", ""))
+	for i, p := range files {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(configDirectory, p)
+		}
+		if fi, err := os.Stat(p); err != nil || fi.IsDir() {
+			return nil, fmt.Errorf("WEBHOOK_JWT_PUBLIC_KEYS: file does not exist or is not a file: %s", p)
+		}
+		files[i] = p
+	}
+	return files, nil
+}
+
+// Validates the value of WEBHOOK_JWT_PUBLIC_KEY_SECRET_NAMES, Key Vault secrets holding PEM public keys that verify
+// tokens
+func getJwtPublicKeySecretNames() ([]string, error) {
+	names := splitList(getenvOrDefault("WEBHOOK_JWT_PUBLIC_KEY_SECRET_NAMES", ""))
+	for _, name := range names {
+		if !keyVaultSecretName.MatchString(name) {
+			return nil, fmt.Errorf("invalid WEBHOOK_JWT_PUBLIC_KEY_SECRET_NAMES: %s", name)
+		}
+	}
+	return names, nil
+}
+
+// Validates the values of WEBHOOK_JWT_JWKS, the URL or file of a JSON Web Key Set that verifies tokens, and of
+// WEBHOOK_JWT_JWKS_CACHE, WEBHOOK_JWT_JWKS_REFETCH_INTERVAL and WEBHOOK_JWT_JWKS_MAX_STALE, which control how a fetched
+// set is cached. Returns nil when no set is configured.
+func getJwks(configDirectory string) (*jwt.JWKS, error) {
+	location := strings.TrimSpace(getenvOrDefault("WEBHOOK_JWT_JWKS", ""))
+	if location == "" {
+		return nil, nil
+	}
+
+	interval, err := parseDurationEnv("WEBHOOK_JWT_JWKS_REFETCH_INTERVAL", jwt.DefaultJWKSRefetchInterval.String())
+	if err == nil && interval <= 0 {
+		err = fmt.Errorf("invalid WEBHOOK_JWT_JWKS_REFETCH_INTERVAL: must be positive")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	maxStale, err := parseDurationEnv("WEBHOOK_JWT_JWKS_MAX_STALE", jwt.DefaultJWKSMaxStale.String())
+	if err == nil && maxStale <= 0 {
+		err = fmt.Errorf("invalid WEBHOOK_JWT_JWKS_MAX_STALE: must be positive")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.Contains(location, "://") {
+		if u, err := url.Parse(location); err != nil || u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("invalid WEBHOOK_JWT_JWKS: want an https:// URL or a file: %s", location)
+		}
+		// The cache decides which keys verify tokens, so it is kept out of shared directories such as /tmp
+
+		cacheDirectory, err := os.UserCacheDir()
+		if err != nil {
+			cacheDirectory = filepath.Join(configDirectory, "cache")
+		} else {
+			cacheDirectory = filepath.Join(cacheDirectory, "webhook-executor")
+		}
+		digest := sha256.Sum256([]byte(location))
+		cachePath := getenvOrDefault("WEBHOOK_JWT_JWKS_CACHE", filepath.Join(cacheDirectory, fmt.Sprintf("jwks-%x.json", digest[:8])))
+		return &jwt.JWKS{Location: location, CachePath: cachePath, RefetchInterval: interval, MaxStale: maxStale}, nil
+	}
+
+	if !filepath.IsAbs(location) {
+		location = filepath.Join(configDirectory, location)
+	}
+	if fi, err := os.Stat(location); err != nil || fi.IsDir() {
+		return nil, fmt.Errorf("WEBHOOK_JWT_JWKS does not exist or is not a file: %s", location)
+	}
+	return &jwt.JWKS{Location: location, RefetchInterval: interval}, nil
+}
+
+// Validates the value of WEBHOOK_JWT_SIGNING_KEY, the PEM private key that re-signs refreshed tokens not signed with
+// the HMAC secret, relative to WEBHOOK_CONFIG unless absolute
+func getJwtSigningKey(configDirectory string) (string, error) {
+	p := getenvOrDefault("WEBHOOK_JWT_SIGNING_KEY", "")
+	if strings.TrimSpace(p) == "" {
+		return "", nil
+	}
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(configDirectory, p)
+	}
+	if fi, err := os.Stat(p); err != nil || fi.IsDir() {
+		return "", fmt.Errorf("WEBHOOK_JWT_SIGNING_KEY does not exist or is not a file: %s", p)
+	}
+	return p, nil
+}
+
+// Validates the value of WEBHOOK_CONFIG
 func getConfigDirectory() (string, error) {
 	p := getenvOrDefault("WEBHOOK_CONFIG", "")
 	if strings.TrimSpace(p) == "" {
@@ -223,10 +876,245 @@ func getTokenRefreshWindow() (time.Duration, error) {
 	return parseDurationEnv("WEBHOOK_TOKEN_REFRESH_WINDOW", "5m")
 }
 
+// Validates the value of WEBHOOK_TOKEN_MAX_SESSION_AGE, the longest time after a token's session began that it may be
+// refreshed; 0 sets no limit
+func getTokenMaxSessionAge() (time.Duration, error) {
+	d, err := parseDurationEnv("WEBHOOK_TOKEN_MAX_SESSION_AGE", "0s")
+	if err == nil && d < 0 {
+		err = fmt.Errorf("invalid WEBHOOK_TOKEN_MAX_SESSION_AGE: must not be negative")
+	}
+	return d, err
+}
+
+// Validates the value of WEBHOOK_SSH_PASSPHRASE_SECRET_PREFIX
+func getSshPassphraseSecretPrefix() (string, error) {
+	p := getenvOrDefault("WEBHOOK_SSH_PASSPHRASE_SECRET_PREFIX", "ssh-passphrase")
+	if !keyVaultSecretName.MatchString(p) {
+		return "", fmt.Errorf("invalid WEBHOOK_SSH_PASSPHRASE_SECRET_PREFIX: %s", p)
+	}
+	return p, nil
+}
+
+// Validates the value of WEBHOOK_COMMAND_TIMEOUT. Zero disables the timeout.
+func getCommandTimeout() (time.Duration, error) {
+	d, err := parseDurationEnv("WEBHOOK_COMMAND_TIMEOUT", "10m")
+	if err == nil && d < 0 {
+		return 0, fmt.Errorf("invalid WEBHOOK_COMMAND_TIMEOUT: must not be negative")
+	}
+	return d, err
+}
+
+// Validates the values of WEBHOOK_OUTPUT_HEAD_BYTES and WEBHOOK_OUTPUT_TAIL_BYTES, which bound the output retained from
+// each of stdout and stderr. Setting both to zero retains all output.
+func getOutputLimit() (sshremote.OutputLimit, error) {
+	head, err := parseByteCountEnv("WEBHOOK_OUTPUT_HEAD_BYTES", "65536")
+	if err != nil {
+		return sshremote.OutputLimit{}, err
+	}
+	tail, err := parseByteCountEnv("WEBHOOK_OUTPUT_TAIL_BYTES", "65536")
+	if err != nil {
+		return sshremote.OutputLimit{}, err
+	}
+	return sshremote.OutputLimit{Head: head, Tail: tail}, nil
+}
+
+// Validates the value of WEBHOOK_HEARTBEAT_INTERVAL, the period between heartbeat events in NDJSON output
+func getHeartbeatInterval() (time.Duration, error) {
+	d, err := parseDurationEnv("WEBHOOK_HEARTBEAT_INTERVAL", "15s")
+	if err == nil && d <= 0 {
+		return 0, fmt.Errorf("invalid WEBHOOK_HEARTBEAT_INTERVAL: must be positive")
+	}
+	return d, err
+}
+
+// Validates the value of WEBHOOK_FANOUT_PARALLELISM, the number of destinations a command runs on at once unless the
+// request sets --parallelism
+func getFanOutParallelism() (int, error) {
+	s := getenvOrDefault("WEBHOOK_FANOUT_PARALLELISM", "8")
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid WEBHOOK_FANOUT_PARALLELISM: must be a positive number: %s", s)
+	}
+	return n, nil
+}
+
+// Validates the value of WEBHOOK_STDIN_MAX_BYTES, the largest payload --stdin may forward
+func getStdinLimit() (int, error) {
+	n, err := parseByteCountEnv("WEBHOOK_STDIN_MAX_BYTES", "1048576")
+	if err == nil && n == 0 {
+		return 0, fmt.Errorf("invalid WEBHOOK_STDIN_MAX_BYTES: must be positive")
+	}
+	return n, err
+}
+
+// Validates the value of WEBHOOK_STDIN_DIRECTORY, the directory a --stdin file must lie beneath. Default: the temporary
+// directory, where webhook writes the files it passes to commands; none allows no files. The directory may not hold
+// WEBHOOK_CONFIG, whose keys must never be forwarded.
+func getStdinDirectory(configDirectory string) (string, error) {
+	p := getenvOrDefault("WEBHOOK_STDIN_DIRECTORY", os.TempDir())
+	if p == "none" {
+		return "", nil
+	}
+	if !filepath.IsAbs(p) {
+		return "", fmt.Errorf("invalid WEBHOOK_STDIN_DIRECTORY: must be absolute: %s", p)
+	}
+	resolved, err := filepath.EvalSymlinks(p)
+	if fi, statErr := os.Stat(p); err != nil || statErr != nil || !fi.IsDir() {
+		return "", fmt.Errorf("WEBHOOK_STDIN_DIRECTORY does not exist or is not a directory: %s", p)
+	}
+	if config, err := filepath.EvalSymlinks(configDirectory); err == nil && withinDirectory(resolved, config) {
+		return "", fmt.Errorf("invalid WEBHOOK_STDIN_DIRECTORY: must not hold WEBHOOK_CONFIG: %s", p)
+	}
+	return filepath.Clean(p), nil
+}
+
+// Variables webhook-executor can set in the remote command's environment
+var remoteEnvironmentVariables = []string{"WEBHOOK_CORRELATION_ID", "WEBHOOK_SUBJECT", "WEBHOOK_CLIENT_IP"}
+
+// Validates the value of WEBHOOK_REMOTE_ENV, a comma-separated list of the variables to set in the remote command's
+// environment. Set it to none to set no variables.
+func getRemoteEnvironment() ([]string, error) {
+	s := getenvOrDefault("WEBHOOK_REMOTE_ENV", strings.Join(remoteEnvironmentVariables, ","))
+	if s == "none" {
+		return nil, nil
+	}
+	names := splitList(s)
+	for _, name := range names {
+		if !slices.Contains(remoteEnvironmentVariables, name) {
+			return nil, fmt.Errorf("invalid WEBHOOK_REMOTE_ENV: unknown variable %s", name)
+		}
+	}
+	return names, nil
+}
+
+// Validates the value of WEBHOOK_REMOTE_ENV_CLAIMS, a comma-separated list of JWT claims to pass to the remote command
+// as WEBHOOK_CLAIM_<NAME>
+func getRemoteEnvironmentClaims() []string {
+	return splitList(getenvOrDefault("WEBHOOK_REMOTE_ENV_CLAIMS", ""))
+}
+
+// Validates the values of WEBHOOK_SSH_DIAL_ATTEMPTS, WEBHOOK_SSH_DIAL_BACKOFF and WEBHOOK_SSH_DIAL_MAX_BACKOFF, which
+// control how a connection that fails for a transient reason is retried
+func getDialRetryPolicy() (sshremote.RetryPolicy, error) {
+	s := getenvOrDefault("WEBHOOK_SSH_DIAL_ATTEMPTS", "3")
+	attempts, err := strconv.Atoi(s)
+	if err != nil || attempts <= 0 {
+		return sshremote.RetryPolicy{}, fmt.Errorf("invalid WEBHOOK_SSH_DIAL_ATTEMPTS: must be a positive number: %s", s)
+	}
+	initial, err := parseDurationEnv("WEBHOOK_SSH_DIAL_BACKOFF", "1s")
+	if err != nil {
+		return sshremote.RetryPolicy{}, err
+	}
+	maximum, err := parseDurationEnv("WEBHOOK_SSH_DIAL_MAX_BACKOFF", "10s")
+	if err != nil {
+		return sshremote.RetryPolicy{}, err
+	}
+	if initial < 0 || maximum < initial {
+		return sshremote.RetryPolicy{}, fmt.Errorf("invalid WEBHOOK_SSH_DIAL_BACKOFF and WEBHOOK_SSH_DIAL_MAX_BACKOFF: want 0 <= backoff <= max backoff")
+	}
+	return sshremote.RetryPolicy{Attempts: attempts, InitialBackoff: initial, MaxBackoff: maximum}, nil
+}
+
+// Validates the value of WEBHOOK_SSH_CA_KEY. A relative path is resolved against WEBHOOK_CONFIG.
+func getSshCertificateAuthorityKey(configDirectory string) (string, error) {
+	p := getenvOrDefault("WEBHOOK_SSH_CA_KEY", "")
+	if strings.TrimSpace(p) == "" {
+		return "", nil
+	}
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(configDirectory, p)
+	}
+	if fi, err := os.Stat(p); err != nil || fi.IsDir() {
+		return "", fmt.Errorf("WEBHOOK_SSH_CA_KEY does not exist or is not a file: %s", p)
+	}
+	return p, nil
+}
+
+// Validates the value of WEBHOOK_SSH_CERTIFICATE_TTL
+func getSshCertificateTtl() (time.Duration, error) {
+	d, err := parseDurationEnv("WEBHOOK_SSH_CERTIFICATE_TTL", "5m")
+	if err == nil && d <= 0 {
+		return 0, fmt.Errorf("invalid WEBHOOK_SSH_CERTIFICATE_TTL: must be positive")
+	}
+	return d, err
+}
+
+// Get the value of WEBHOOK_POLICY, the authorization policy file relative to WEBHOOK_CONFIG unless absolute, or an empty
+// string when it is none and every request is allowed
+func getPolicyPath(configDirectory string) string {
+	p := getenvOrDefault("WEBHOOK_POLICY", "policy.json")
+	if p == "none" {
+		return ""
+	}
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(configDirectory, p)
+	}
+	return p
+}
+
+// Validates $WEBHOOK_CONFIG/ssh/known_hosts, which SSH destinations' host keys are verified against
+func checkKnownHostsFile(configDirectory string) error {
+	p := filepath.Join(configDirectory, "ssh", "known_hosts")
+	if fi, err := os.Stat(p); err != nil || fi.IsDir() {
+		return fmt.Errorf("%s does not exist or is not a file; add the host keys of SSH destinations to it with ssh-keyscan (make New-WebhookKnownHosts)", p)
+	}
+	return nil
+}
+
+// Get the value of WEBHOOK_DOCKER_SOCKET, the Docker daemon's socket for docker:// destinations
+func getDockerSocket() string {
+	return getenvOrDefault("WEBHOOK_DOCKER_SOCKET", executor.DefaultDockerSocket)
+}
+
+// Get the value of WEBHOOK_REMOTE_DOCKER_SOCKET, the Docker daemon's socket on SSH destinations for --docker operations
+func getRemoteDockerSocket() string {
+	return getenvOrDefault("WEBHOOK_REMOTE_DOCKER_SOCKET", executor.DefaultRemoteDockerSocket)
+}
+
+// Get the value of WEBHOOK_LOCAL_PATH, the PATH given to commands run on local:// destinations
+func getLocalPath() string {
+	return getenvOrDefault("WEBHOOK_LOCAL_PATH", executor.DefaultLocalPath)
+}
+
+// Resolve the --stdin file source to a path beneath directory, refusing one that leaves it, including through a symbolic
+// link
+func resolveStdinFile(source string, directory string) (string, error) {
+
+	if directory == "" {
+		return "", fmt.Errorf("file payloads are not allowed: WEBHOOK_STDIN_DIRECTORY is none")
+	}
+
+	name := source
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(directory, name)
+	}
+	name = filepath.Clean(name)
+
+	root, err := filepath.EvalSymlinks(directory)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %v", directory, err)
+	}
+	resolved, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return "", err
+	}
+	if !withinDirectory(directory, name) || !withinDirectory(root, resolved) {
+		return "", fmt.Errorf("%s is outside %s", source, directory)
+	}
+
+	return resolved, nil
+}
+
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 // HELPERS
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
+// Report whether target is directory or lies beneath it
+func withinDirectory(directory, target string) bool {
+	relative, err := filepath.Rel(directory, target)
+	return err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
 // Get the value of an environment variable or a default if it's blank
 func getenvOrDefault(key, def string) string {
 	if value := os.Getenv(key); value != "" {
@@ -235,8 +1123,15 @@ func getenvOrDefault(key, def string) string {
 	return def
 }
 
-// Convert an SSH remote response to its JSON representation
+// stream receives execution events when the request asked for --output=ndjson
+var stream *ndjsonStream
+
+// Convert an SSH remote response to its JSON representation. In NDJSON mode the response is the final exit event.
 func outputJson(resp sshremote.Response) {
+	if stream != nil {
+		stream.exit(resp)
+		return
+	}
 	b, err := json.Marshal(resp)
 	if err != nil {
 		log.Fatalf("failed to marshal JSON: %v", err)
@@ -244,6 +1139,357 @@ func outputJson(resp sshremote.Response) {
 	fmt.Println(string(b))
 }
 
+// Convert the aggregate response for several destinations to its JSON representation. In NDJSON mode the response is
+// the final done event.
+func outputAggregateJson(resp executor.AggregateResponse) {
+	if stream != nil {
+		stream.done(resp)
+		return
+	}
+	b, err := json.Marshal(resp)
+	if err != nil {
+		log.Fatalf("failed to marshal JSON: %v", err)
+	}
+	fmt.Println(string(b))
+}
+
+// Derive the certificate for a request from the validated token. Principals come from the token's principals claim
+// and default to the destination user; the forced command comes from its force_command claim and defaults to the
+// requested command. The caller adds the environment the forced command runs with.
+func newCertificateRequest(token *gojwt.Token, user, command, correlationId string, ttl time.Duration) sshremote.CertificateRequest {
+
+	principals := jwt.ClaimStrings(token, "principals")
+	if len(principals) == 0 {
+		principals = []string{user}
+	}
+
+	forceCommand := jwt.ClaimString(token, "force_command")
+	if forceCommand == "" {
+		forceCommand = command
+	}
+
+	return sshremote.CertificateRequest{
+		KeyId:        fmt.Sprintf("%s:%s", jwt.ClaimString(token, "sub"), correlationId),
+		Principals:   principals,
+		ForceCommand: forceCommand,
+		Lifetime:     ttl,
+	}
+}
+
+// Sign a certificate for request with the CA key at keyPath and have destination authenticate with it
+func issueCertificate(destination *sshremote.Destination, keyPath string, passphrase sshremote.PassphraseFunc, request sshremote.CertificateRequest) error {
+	authority, err := sshremote.LoadCertificateAuthority(keyPath, passphrase)
+	if err != nil {
+		return err
+	}
+	return destination.UseEphemeralCertificate(authority, request)
+}
+
+// Run command on several destinations as the request's --parallelism and --on-error direct, streaming each
+// destination's events in NDJSON mode
+func executeOnAll(destinations []executor.Executor, command string, options sshremote.ExecuteOptions, parsed argparse.ParsedArgs, defaultParallelism int) executor.AggregateResponse {
+
+	fanOut := executor.FanOutOptions{
+		Parallelism: parsed.Parallelism,
+		FailFast:    parsed.OnError == argparse.OnErrorFailFast,
+		Finished: func(response sshremote.Response) {
+			logResponse(response)
+			if stream != nil {
+				stream.exit(response)
+			}
+		},
+	}
+
+	if fanOut.Parallelism == 0 {
+		fanOut.Parallelism = defaultParallelism
+	}
+
+	if stream != nil {
+		fanOut.Output = stream.outputFor
+		fanOut.Started = func(destination string) {
+			stream.start(destination, command)
+		}
+	}
+
+	log.Printf("Running on %d destinations with parallelism %d (on error: %s)", len(destinations), fanOut.Parallelism, parsed.OnError)
+	return executor.ExecuteOnAll(destinations, command, options, fanOut)
+}
+
+// Log what a response reveals about how the command ran
+func logResponse(response sshremote.Response) {
+	prefix := ""
+	if response.Destination != "" {
+		prefix = response.Destination + ": "
+	}
+	if response.StdoutTruncated || response.StderrTruncated {
+		log.Printf("[WARN] %sOutput truncated: stdout %d bytes (truncated=%v), stderr %d bytes (truncated=%v)", prefix, response.StdoutBytes, response.StdoutTruncated, response.StderrBytes, response.StderrTruncated)
+	}
+	if response.Identity != nil {
+		log.Printf("%sAuthenticated with identity %s", prefix, *response.Identity)
+	}
+	for _, transfer := range response.Transfers {
+		if transfer.Error != nil {
+			log.Printf("[WARN] %s%s of %s failed: %s", prefix, transfer.Direction, transfer.Remote, *transfer.Error)
+		} else {
+			log.Printf("%s%s of %s: %d bytes, SHA-256 %s", prefix, transfer.Direction, transfer.Remote, transfer.Bytes, transfer.Sha256)
+		}
+	}
+	for i, attemptError := range response.AttemptErrors {
+		log.Printf("[WARN] %sConnection attempt %d failed: %s", prefix, i+1, attemptError)
+	}
+	if response.Signal != "" {
+		log.Printf("[WARN] %sCommand terminated by signal %s: %s", prefix, response.Signal, response.ExitMessage)
+	}
+	if report := response.Docker; report != nil {
+		if image := report.Image; image != nil {
+			log.Printf("%sImage %s: %s -> %s (updated=%v)", prefix, image.Reference, image.IdBefore, image.IdAfter, image.Updated)
+		}
+		for _, container := range report.Containers {
+			log.Printf("%sContainer %s %s: state %s, health %q", prefix, container.Name, container.Id, container.State, container.Health)
+		}
+		for _, warning := range report.Warnings {
+			log.Printf("[WARN] %s%s", prefix, warning)
+		}
+	}
+	if plan := response.DryRun; plan != nil {
+		log.Printf("%sDry run: %s backend, address %q, user %q, identities %v, policy rule %q, handshake=%v", prefix, plan.Backend, plan.Address, plan.User, plan.Identities, plan.PolicyRule, plan.Handshake)
+	}
+	if response.Status != 0 {
+		log.Printf("%sCommand finished with status %d (%s)", prefix, response.Status, response.Reason)
+	}
+}
+
+// Parse the requested uploads and downloads and check them against $WEBHOOK_CONFIG/ssh/sftp_allowlist. Local paths
+// are relative to $WEBHOOK_CONFIG/files.
+func resolveTransfers(uploads, downloads []string, configDirectory string) ([]sshremote.Transfer, error) {
+
+	if len(uploads) == 0 && len(downloads) == 0 {
+		return nil, nil
+	}
+
+	allowlist, err := sshremote.LoadTransferAllowlist(filepath.Join(configDirectory, "ssh", "sftp_allowlist"), filepath.Join(configDirectory, "files"))
+	if err != nil {
+		return nil, err
+	}
+
+	var transfers []sshremote.Transfer
+
+	for _, direction := range []string{sshremote.Upload, sshremote.Download} {
+		specs := uploads
+		if direction == sshremote.Download {
+			specs = downloads
+		}
+		for _, spec := range specs {
+			transfer, err := sshremote.ParseTransfer(direction, spec)
+			if err != nil {
+				return nil, err
+			}
+			transfer, err = allowlist.Resolve(transfer)
+			if err != nil {
+				return nil, err
+			}
+			transfers = append(transfers, transfer)
+		}
+	}
+
+	return transfers, nil
+}
+
+// Return the timeout a request asking for requested gets: the request may shorten the configured or action timeout,
+// but not lengthen it. A configured timeout of zero is no limit.
+func limitTimeout(configured, requested time.Duration) time.Duration {
+	if configured > 0 && requested > configured {
+		return configured
+	}
+	return requested
+}
+
+// Build the remote command's environment from the variables named by names and the claims named by claims. A claim
+// the token does not carry, like a request without a client IP, sets no variable.
+func newEnvironment(names, claims []string, token *gojwt.Token, correlationId string, clientIps []net.IP) map[string]string {
+
+	environment := map[string]string{}
+
+	set := func(name, value string) {
+		if value != "" {
+			environment[name] = value
+		}
+	}
+
+	for _, name := range names {
+		switch name {
+		case "WEBHOOK_CORRELATION_ID":
+			set(name, correlationId)
+		case "WEBHOOK_SUBJECT":
+			set(name, jwt.ClaimString(token, "sub"))
+		case "WEBHOOK_CLIENT_IP":
+			// The first address in X-Forwarded-For is the originating client
+			if len(clientIps) > 0 {
+				set(name, clientIps[0].String())
+			}
+		}
+	}
+
+	for _, claim := range claims {
+		set("WEBHOOK_CLAIM_"+strings.ToUpper(invalidVariableNameCharacters.ReplaceAllString(claim, "_")), jwt.ClaimString(token, claim))
+	}
+
+	return environment
+}
+
+var invalidVariableNameCharacters = regexp.MustCompile(`[^0-9A-Za-z_]`)
+
+// Split a comma-separated list, dropping blank entries
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Read the payload named by source: "-" reads this process' stdin, "base64:<data>" decodes data, and anything else is
+// the path of a regular file beneath directory, relative to it unless absolute. A payload larger than limit bytes is an
+// error.
+func readStdin(source string, directory string, limit int) ([]byte, error) {
+
+	var reader io.Reader
+
+	switch {
+	case source == "-":
+		reader = os.Stdin
+	case strings.HasPrefix(source, "base64:"):
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(source, "base64:"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 payload: %v", err)
+		}
+		reader = bytes.NewReader(decoded)
+	default:
+		name, err := resolveStdinFile(source, directory)
+		if err != nil {
+			return nil, err
+		}
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		if fi, err := file.Stat(); err != nil || !fi.Mode().IsRegular() {
+			return nil, fmt.Errorf("%s is not a regular file", source)
+		}
+		reader = file
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(reader, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > limit {
+		return nil, fmt.Errorf("payload exceeds %d bytes", limit)
+	}
+
+	return payload, nil
+}
+
+// Load the keys that verify and refresh tokens: the current and up to previousVersions earlier versions of the
+// hex-encoded HMAC secret and the PEM public keys held in Azure Key Vault, and the PEM public and signing keys in files
+func loadTokenKeys(keyVaultURL, secretName string, previousVersions int, publicKeyFiles, publicKeySecretNames []string, signingKeyFile string) (*jwt.Keys, error) {
+
+	keys := &jwt.Keys{}
+
+	if secretName != "" {
+		versions, err := azure.FetchSecretVersionsFromKeyVault(keyVaultURL, secretName, previousVersions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch JWT secret: %w", err)
+		}
+		if keys, err = jwt.HMACKeys(string(versions[0].Value)); err != nil {
+			return nil, fmt.Errorf("invalid JWT secret %s: %w", secretName, err)
+		}
+		keys.SecretVersion = versions[0].Version
+		for _, version := range versions[1:] {
+			previous, err := jwt.HMACKeys(string(version.Value))
+			if err != nil {
+				return nil, fmt.Errorf("invalid JWT secret %s version %s: %w", secretName, version.Version, err)
+			}
+			if keys.PreviousSecrets == nil {
+				keys.PreviousSecrets = map[string][]byte{}
+			}
+			keys.PreviousSecrets[version.Version] = previous.Secret
+		}
+	}
+
+	for _, name := range publicKeySecretNames {
+		data, err := azure.FetchSecretFromKeyVault(keyVaultURL, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch JWT public key: %w", err)
+		}
+		key, err := jwt.ParsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT public key in secret %s: %w", name, err)
+		}
+		keys.PublicKeys = append(keys.PublicKeys, key)
+	}
+
+	for _, file := range publicKeyFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT public key: %w", err)
+		}
+		key, err := jwt.ParsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT public key %s: %w", file, err)
+		}
+		keys.PublicKeys = append(keys.PublicKeys, key)
+	}
+
+	if signingKeyFile != "" {
+		data, err := os.ReadFile(signingKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT signing key: %w", err)
+		}
+		if keys.SigningKey, err = jwt.ParsePrivateKey(data); err != nil {
+			return nil, fmt.Errorf("invalid JWT signing key %s: %w", signingKeyFile, err)
+		}
+	}
+
+	return keys, nil
+}
+
+// Key Vault secret names may only contain alphanumeric characters and dashes
+var keyVaultSecretName = regexp.MustCompile(`^[0-9A-Za-z-]+$`)
+
+// Return a passphrase source that fetches the passphrase for an encrypted identity from Azure Key Vault. The secret
+// for ssh/id_ed25519 with prefix ssh-passphrase is named ssh-passphrase-id-ed25519.
+func newPassphraseFunc(keyVaultURL, prefix string) sshremote.PassphraseFunc {
+	passphrases := map[string][]byte{}
+	return func(keyPath string) ([]byte, error) {
+		name := prefix + "-" + invalidSecretNameCharacters.ReplaceAllString(filepath.Base(keyPath), "-")
+		if passphrase, ok := passphrases[name]; ok {
+			return passphrase, nil
+		}
+		log.Printf("Fetching passphrase for %s from Azure Key Vault secret %s", keyPath, name)
+		passphrase, err := azure.FetchSecretFromKeyVault(keyVaultURL, name)
+		if err != nil {
+			return nil, err
+		}
+		passphrases[name] = passphrase
+		return passphrase, nil
+	}
+}
+
+var invalidSecretNameCharacters = regexp.MustCompile(`[^0-9A-Za-z-]`)
+
+func parseByteCountEnv(key, def string) (int, error) {
+	s := getenvOrDefault(key, def)
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s: must be a non-negative number of bytes: %s", key, s)
+	}
+	return n, nil
+}
+
 func parseDurationEnv(key, def string) (time.Duration, error) {
 	s := getenvOrDefault(key, def)
 	d, err := time.ParseDuration(s)
@@ -0,0 +1,123 @@
+// SPDX-FileCopyrightText: 2016-2025 Noble Factor
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/NobleFactor/docker-webhook/cmd/internal/executor"
+	"github.com/NobleFactor/docker-webhook/cmd/internal/sshremote"
+)
+
+// ndjsonStream writes execution events to out as newline-delimited JSON. Each event is one line carrying the event
+// name, a timestamp and the correlation ID. The final exit event carries every field of sshremote.Response; when the
+// command runs on several destinations there is an exit event for each and the final done event carries every field
+// of executor.AggregateResponse.
+type ndjsonStream struct {
+	mutex         sync.Mutex
+	out           io.Writer
+	correlationId string
+}
+
+// ndjsonEvent is a start, stdout, stderr or heartbeat event
+type ndjsonEvent struct {
+	Event         string `json:"event"`
+	Timestamp     string `json:"timestamp"`
+	CorrelationId string `json:"correlationId"`
+	Destination   string `json:"destination,omitempty"`
+	Command       string `json:"command,omitempty"`
+	Data          string `json:"data,omitempty"`
+}
+
+// ndjsonExitEvent is the final event of a stream
+type ndjsonExitEvent struct {
+	Event     string `json:"event"`
+	Timestamp string `json:"timestamp"`
+	sshremote.Response
+}
+
+// ndjsonDoneEvent is the final event of a stream for several destinations
+type ndjsonDoneEvent struct {
+	Event     string `json:"event"`
+	Timestamp string `json:"timestamp"`
+	executor.AggregateResponse
+}
+
+func newNdjsonStream(out io.Writer, correlationId string) *ndjsonStream {
+	return &ndjsonStream{out: out, correlationId: correlationId}
+}
+
+// start reports that the command is about to run on destination
+func (s *ndjsonStream) start(destination, command string) {
+	s.write(ndjsonEvent{Event: "start", Timestamp: timestamp(), CorrelationId: s.correlationId, Destination: destination, Command: command})
+}
+
+// outputFor returns an sshremote.OutputFunc reporting chunks of the command's stdout or stderr on destination
+func (s *ndjsonStream) outputFor(destination string) sshremote.OutputFunc {
+	return func(stream string, data []byte) {
+		s.write(ndjsonEvent{Event: stream, Timestamp: timestamp(), CorrelationId: s.correlationId, Destination: destination, Data: string(data)})
+	}
+}
+
+// exit reports the final response
+func (s *ndjsonStream) exit(response sshremote.Response) {
+	if response.CorrelationId == "" {
+		response.CorrelationId = s.correlationId
+	}
+	s.write(ndjsonExitEvent{Event: "exit", Timestamp: timestamp(), Response: response})
+}
+
+// done reports the aggregate response of a command run on several destinations. It follows an exit event for each.
+func (s *ndjsonStream) done(response executor.AggregateResponse) {
+	if response.CorrelationId == "" {
+		response.CorrelationId = s.correlationId
+	}
+	s.write(ndjsonDoneEvent{Event: "done", Timestamp: timestamp(), AggregateResponse: response})
+}
+
+// heartbeat emits a heartbeat event every interval until the returned function is called
+func (s *ndjsonStream) heartbeat(interval time.Duration) func() {
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				s.write(ndjsonEvent{Event: "heartbeat", Timestamp: timestamp(), CorrelationId: s.correlationId})
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+func (s *ndjsonStream) write(event interface{}) {
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		log.Fatalf("failed to marshal JSON: %v", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	fmt.Fprintln(s.out, string(b))
+}
+
+func timestamp() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
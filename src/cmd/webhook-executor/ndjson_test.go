@@ -0,0 +1,88 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/json"
+    "testing"
+    "time"
+
+    "github.com/NobleFactor/docker-webhook/cmd/internal/executor"
+    "github.com/NobleFactor/docker-webhook/cmd/internal/sshremote"
+)
+
+func TestNdjsonStream_Events(t *testing.T) {
+    var out bytes.Buffer
+    stream := newNdjsonStream(&out, "test-cid")
+
+    stream.start("user@host", "uptime")
+    output := stream.outputFor("user@host")
+    output("stdout", []byte("up 3 days"))
+    output("stderr", []byte("warning"))
+    stopHeartbeat := stream.heartbeat(time.Millisecond)
+    time.Sleep(20 * time.Millisecond)
+    stopHeartbeat()
+    stdout := "up 3 days"
+    stream.exit(sshremote.Response{Status: 0, Reason: "Success", Stdout: &stdout})
+
+    var events []map[string]interface{}
+    scanner := bufio.NewScanner(&out)
+    for scanner.Scan() {
+        var event map[string]interface{}
+        if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+            t.Fatalf("line is not JSON: %q: %v", scanner.Text(), err)
+        }
+        if event["correlationId"] != "test-cid" {
+            t.Fatalf("want correlationId on every event, got %v", event)
+        }
+        if _, err := time.Parse(time.RFC3339Nano, event["timestamp"].(string)); err != nil {
+            t.Fatalf("want RFC 3339 timestamp, got %v", event["timestamp"])
+        }
+        events = append(events, event)
+    }
+
+    if len(events) < 5 {
+        t.Fatalf("want start, stdout, stderr, heartbeat and exit events, got %d events", len(events))
+    }
+    if events[0]["event"] != "start" || events[0]["destination"] != "user@host" || events[0]["command"] != "uptime" {
+        t.Fatalf("unexpected start event: %v", events[0])
+    }
+    if events[1]["event"] != "stdout" || events[1]["data"] != "up 3 days" || events[1]["destination"] != "user@host" {
+        t.Fatalf("unexpected stdout event: %v", events[1])
+    }
+    if events[2]["event"] != "stderr" || events[2]["data"] != "warning" {
+        t.Fatalf("unexpected stderr event: %v", events[2])
+    }
+    for _, event := range events[3 : len(events)-1] {
+        if event["event"] != "heartbeat" {
+            t.Fatalf("want heartbeat events before exit, got %v", event)
+        }
+    }
+
+    exit := events[len(events)-1]
+    if exit["event"] != "exit" || exit["status"] != float64(0) || exit["reason"] != "Success" || exit["stdout"] != "up 3 days" {
+        t.Fatalf("want exit event carrying the response, got %v", exit)
+    }
+}
+
+func TestNdjsonStream_Done(t *testing.T) {
+    var out bytes.Buffer
+    stream := newNdjsonStream(&out, "test-cid")
+
+    stream.exit(sshremote.Response{Status: 0, Reason: "OK", Destination: "web-1"})
+    stream.exit(sshremote.Response{Status: 1, Reason: "General Error", Destination: "web-2"})
+    stream.done(executor.AggregateResponse{Status: 1, Reason: "Partial Failure", Total: 2, Succeeded: 1, Failed: 1})
+
+    lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+    if len(lines) != 3 {
+        t.Fatalf("want 3 events, got %d", len(lines))
+    }
+
+    var done map[string]interface{}
+    if err := json.Unmarshal(lines[2], &done); err != nil {
+        t.Fatalf("line is not JSON: %q: %v", lines[2], err)
+    }
+    if done["event"] != "done" || done["reason"] != "Partial Failure" || done["succeeded"] != float64(1) || done["correlationId"] != "test-cid" {
+        t.Fatalf("want done event carrying the aggregate response, got %v", done)
+    }
+}
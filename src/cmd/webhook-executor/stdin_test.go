@@ -0,0 +1,95 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestReadStdin(t *testing.T) {
+    directory := t.TempDir()
+    payloadFile := filepath.Join(directory, "payload.json")
+    if err := os.WriteFile(payloadFile, []byte(`{"action":"push"}`), 0o600); err != nil {
+        t.Fatalf("failed to write payload: %v", err)
+    }
+
+    // A key outside the directory, and a link to it from within
+    secret := filepath.Join(t.TempDir(), "id_ed25519")
+    if err := os.WriteFile(secret, []byte("private key"), 0o600); err != nil {
+        t.Fatalf("failed to write key: %v", err)
+    }
+    if err := os.Symlink(secret, filepath.Join(directory, "link")); err != nil {
+        t.Fatalf("failed to link key: %v", err)
+    }
+
+    tests := []struct {
+        name    string
+        source  string
+        limit   int
+        want    string
+        wantErr string
+    }{
+        {name: "file", source: payloadFile, limit: 1024, want: `{"action":"push"}`},
+        {name: "file relative to the directory", source: "payload.json", limit: 1024, want: `{"action":"push"}`},
+        {name: "file outside the directory", source: secret, limit: 1024, wantErr: "is outside"},
+        {name: "relative path leaving the directory", source: "../" + filepath.Base(filepath.Dir(secret)) + "/id_ed25519", limit: 1024, wantErr: "is outside"},
+        {name: "link leaving the directory", source: "link", limit: 1024, wantErr: "is outside"},
+        {name: "directory", source: directory, limit: 1024, wantErr: "not a regular file"},
+        {name: "base64", source: "base64:eyJhY3Rpb24iOiJwdXNoIn0=", limit: 1024, want: `{"action":"push"}`},
+        {name: "empty file is still stdin", source: "base64:", limit: 1024, want: ""},
+        {name: "at the limit", source: payloadFile, limit: 17, want: `{"action":"push"}`},
+        {name: "over the limit", source: payloadFile, limit: 16, wantErr: "exceeds 16 bytes"},
+        {name: "invalid base64", source: "base64:***", limit: 1024, wantErr: "illegal base64"},
+        {name: "missing file", source: filepath.Join(directory, "missing"), limit: 1024, wantErr: "no such file"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            payload, err := readStdin(tt.source, directory, tt.limit)
+            if tt.wantErr != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                    t.Fatalf("want error containing %q, got %v", tt.wantErr, err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("unexpected error: %v", err)
+            }
+            if payload == nil || string(payload) != tt.want {
+                t.Fatalf("want payload %q, got %q", tt.want, payload)
+            }
+        })
+    }
+}
+
+func TestReadStdin_NoDirectory(t *testing.T) {
+    payloadFile := filepath.Join(t.TempDir(), "payload.json")
+    if err := os.WriteFile(payloadFile, []byte(`{}`), 0o600); err != nil {
+        t.Fatalf("failed to write payload: %v", err)
+    }
+    if _, err := readStdin(payloadFile, "", 1024); err == nil || !strings.Contains(err.Error(), "none") {
+        t.Fatalf("want file payloads refused, got %v", err)
+    }
+}
+
+func TestGetStdinDirectory(t *testing.T) {
+    directory := t.TempDir()
+    configDirectory := filepath.Join(directory, "config")
+    if err := os.Mkdir(configDirectory, 0o700); err != nil {
+        t.Fatalf("failed to create config directory: %v", err)
+    }
+
+    t.Setenv("WEBHOOK_STDIN_DIRECTORY", directory)
+    if _, err := getStdinDirectory(configDirectory); err == nil {
+        t.Fatalf("want a directory holding WEBHOOK_CONFIG refused")
+    }
+    if got, err := getStdinDirectory(t.TempDir()); err != nil || got != directory {
+        t.Fatalf("want %s, got %q (err %v)", directory, got, err)
+    }
+
+    t.Setenv("WEBHOOK_STDIN_DIRECTORY", "none")
+    if got, err := getStdinDirectory(configDirectory); err != nil || got != "" {
+        t.Fatalf("want no directory, got %q (err %v)", got, err)
+    }
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestLimitTimeout(t *testing.T) {
+    tests := []struct {
+        configured time.Duration
+        requested  time.Duration
+        want       time.Duration
+    }{
+        {configured: 10 * time.Minute, requested: 30 * time.Second, want: 30 * time.Second},
+        {configured: 10 * time.Minute, requested: time.Hour, want: 10 * time.Minute},
+        {configured: 0, requested: time.Hour, want: time.Hour},
+    }
+
+    for _, tt := range tests {
+        if got := limitTimeout(tt.configured, tt.requested); got != tt.want {
+            t.Fatalf("limitTimeout(%s, %s): want %s, got %s", tt.configured, tt.requested, tt.want, got)
+        }
+    }
+}